@@ -0,0 +1,171 @@
+package avatar
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+)
+
+// Sizes lists the edge lengths, in pixels, of the square thumbnails
+// generated for every avatar.
+var Sizes = []int{32, 64, 128}
+
+// MaxDimension is the largest width or height accepted for an upload. It
+// guards against decompression bombs: small files decoding to huge images.
+const MaxDimension = 4096
+
+var (
+	ErrUnsupportedFormat = errors.New("avatar: unsupported image format")
+	ErrTooLarge          = errors.New("avatar: image dimensions too large")
+)
+
+// ValidSize reports whether size is one of the generated thumbnail sizes.
+func ValidSize(size int) bool {
+	for _, s := range Sizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+// Process decodes a PNG, JPEG or GIF image, crops it to a centered square and
+// returns it re-encoded as PNG at each of the thumbnail Sizes. Re-encoding
+// drops any metadata or trailing payload the original file may carry.
+func Process(r io.Reader) (map[int][]byte, error) {
+	var buf bytes.Buffer
+	cfg, format, err := image.DecodeConfig(io.TeeReader(r, &buf))
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			return nil, ErrUnsupportedFormat
+		}
+		return nil, fmt.Errorf("decoding image config: %w", err)
+	}
+	switch format {
+	case "png", "jpeg", "gif":
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+	if cfg.Width > MaxDimension || cfg.Height > MaxDimension {
+		return nil, ErrTooLarge
+	}
+
+	img, _, err := image.Decode(io.MultiReader(&buf, r))
+	if err != nil {
+		return nil, fmt.Errorf("decoding image: %w", err)
+	}
+
+	square := toRGBA(img, cropSquare(img.Bounds()))
+	thumbs := make(map[int][]byte, len(Sizes))
+	for _, size := range Sizes {
+		var out bytes.Buffer
+		err = png.Encode(&out, resize(square, size))
+		if err != nil {
+			return nil, fmt.Errorf("encoding %dpx thumbnail: %w", size, err)
+		}
+		thumbs[size] = out.Bytes()
+	}
+	return thumbs, nil
+}
+
+// cropSquare returns the largest centered square within b.
+func cropSquare(b image.Rectangle) image.Rectangle {
+	side := min(b.Dx(), b.Dy())
+	x := b.Min.X + (b.Dx()-side)/2
+	y := b.Min.Y + (b.Dy()-side)/2
+	return image.Rect(x, y, x+side, y+side)
+}
+
+// toRGBA copies the region r of img to a new RGBA image with its origin at
+// the top left corner, so that its pixels are converted only once and can be
+// read directly from Pix.
+func toRGBA(img image.Image, r image.Rectangle) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, r.Min, draw.Src)
+	return dst
+}
+
+// resize scales the square image img to size×size pixels. Each destination
+// pixel is the average of the source pixels it covers, so downscaling does
+// not alias; upscaling falls back to nearest neighbour.
+func resize(img *image.RGBA, size int) *image.NRGBA {
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+	side := img.Bounds().Dx()
+	for dy := 0; dy < size; dy++ {
+		y0 := dy * side / size
+		y1 := max((dy+1)*side/size, y0+1)
+		for dx := 0; dx < size; dx++ {
+			x0 := dx * side / size
+			x1 := max((dx+1)*side/size, x0+1)
+
+			var r, g, b, a, n uint64
+			for y := y0; y < y1; y++ {
+				row := img.Pix[y*img.Stride+4*x0 : y*img.Stride+4*x1]
+				for k := 0; k < len(row); k += 4 {
+					r += uint64(row[k])
+					g += uint64(row[k+1])
+					b += uint64(row[k+2])
+					a += uint64(row[k+3])
+					n++
+				}
+			}
+			if a == 0 {
+				continue
+			}
+			// The sums are of alpha-premultiplied values, which keeps
+			// transparent pixels from bleeding into their neighbours.
+			dst.SetNRGBA(dx, dy, color.NRGBA{
+				R: uint8(r * 0xff / a),
+				G: uint8(g * 0xff / a),
+				B: uint8(b * 0xff / a),
+				A: uint8(a / n),
+			})
+		}
+	}
+	return dst
+}
+
+// Identicon returns a deterministic size×size PNG derived from seed: a 5×5
+// grid, mirrored horizontally, whose cells and colour come from the SHA-256
+// hash of the seed. It is shown for users who have not uploaded an avatar.
+func Identicon(seed string, size int) ([]byte, error) {
+	sum := sha256.Sum256([]byte(seed))
+	fg := color.NRGBA{R: sum[0], G: sum[1], B: sum[2], A: 0xff}
+	bg := color.NRGBA{R: 0xf0, G: 0xf0, B: 0xf0, A: 0xff}
+
+	const grid = 5
+	var cells [grid][grid]bool
+	for y := 0; y < grid; y++ {
+		for x := 0; x < (grid+1)/2; x++ {
+			on := sum[3+y*3+x]%2 == 0
+			cells[y][x] = on
+			cells[y][grid-1-x] = on
+		}
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for py := 0; py < size; py++ {
+		for px := 0; px < size; px++ {
+			c := bg
+			if cells[py*grid/size][px*grid/size] {
+				c = fg
+			}
+			img.SetNRGBA(px, py, c)
+		}
+	}
+
+	var out bytes.Buffer
+	err := png.Encode(&out, img)
+	if err != nil {
+		return nil, fmt.Errorf("encoding identicon: %w", err)
+	}
+	return out.Bytes(), nil
+}
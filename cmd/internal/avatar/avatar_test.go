@@ -0,0 +1,97 @@
+package avatar
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// encode returns img encoded as PNG.
+func encode(t *testing.T, img image.Image) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// checkThumbnails fails the test unless every thumbnail has its size and is
+// filled with want.
+func checkThumbnails(t *testing.T, thumbs map[int][]byte, want color.NRGBA) {
+	t.Helper()
+
+	for _, size := range Sizes {
+		img, err := png.Decode(bytes.NewReader(thumbs[size]))
+		if err != nil {
+			t.Fatalf("%dpx: %v", size, err)
+		}
+		if b := img.Bounds(); b.Dx() != size || b.Dy() != size {
+			t.Fatalf("%dpx: got bounds %v", size, b)
+		}
+		for _, p := range []image.Point{{0, 0}, {size / 2, size / 2}, {size - 1, size - 1}} {
+			got := color.NRGBAModel.Convert(img.At(p.X, p.Y))
+			if got != want {
+				t.Errorf("%dpx: got %v at %v; want %v", size, got, p, want)
+			}
+		}
+	}
+}
+
+func TestProcessCrop(t *testing.T) {
+	// A wide image with green margins around a blue centered square.
+	img := image.NewNRGBA(image.Rect(0, 0, 384, 256))
+	blue := color.NRGBA{B: 255, A: 255}
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 384; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{G: 255, A: 255})
+			if x >= 64 && x < 320 {
+				img.SetNRGBA(x, y, blue)
+			}
+		}
+	}
+
+	thumbs, err := Process(bytes.NewReader(encode(t, img)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkThumbnails(t, thumbs, blue)
+}
+
+func TestProcessTransparency(t *testing.T) {
+	// Opaque red pixels alternating with transparent black ones average to
+	// half transparent red, not to a darker red.
+	img := image.NewNRGBA(image.Rect(0, 0, 256, 256))
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 256; x++ {
+			if (x+y)%2 == 0 {
+				img.SetNRGBA(x, y, color.NRGBA{R: 255, A: 255})
+			}
+		}
+	}
+
+	thumbs, err := Process(bytes.NewReader(encode(t, img)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkThumbnails(t, thumbs, color.NRGBA{R: 255, A: 127})
+}
+
+func TestProcessErrors(t *testing.T) {
+	_, err := Process(strings.NewReader("<svg></svg>"))
+	if !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("got error %v for an SVG; want ErrUnsupportedFormat", err)
+	}
+
+	huge := image.NewGray(image.Rect(0, 0, MaxDimension+1, 1))
+	_, err = Process(bytes.NewReader(encode(t, huge)))
+	if !errors.Is(err, ErrTooLarge) {
+		t.Errorf("got error %v for a %dpx wide image; want ErrTooLarge", err, MaxDimension+1)
+	}
+}
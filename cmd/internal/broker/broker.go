@@ -0,0 +1,92 @@
+package broker
+
+import "sync"
+
+// bufferSize is the number of events a subscription holds before it is
+// considered too slow and dropped.
+const bufferSize = 16
+
+// Event tells subscribers that something happened on a topic. It carries
+// no data: subscribers load what they need from the database, which keeps
+// every subscriber to what it is allowed to see.
+type Event struct {
+	Name string
+	ID   int
+}
+
+// Broker is an in-process publish/subscribe hub. Subscribers only get the
+// events published after they subscribed.
+type Broker struct {
+	mu     sync.Mutex
+	topics map[string]map[*Subscription]struct{}
+}
+
+// New returns an empty broker.
+func New() *Broker {
+	return &Broker{topics: map[string]map[*Subscription]struct{}{}}
+}
+
+// Subscription receives the events published to a topic on C until it is
+// closed.
+type Subscription struct {
+	C <-chan Event
+
+	c      chan Event
+	topic  string
+	broker *Broker
+}
+
+// Subscribe starts receiving the events published to topic. The
+// subscription must be closed once done with.
+func (b *Broker) Subscribe(topic string) *Subscription {
+	c := make(chan Event, bufferSize)
+	s := &Subscription{C: c, c: c, topic: topic, broker: b}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.topics[topic] == nil {
+		b.topics[topic] = map[*Subscription]struct{}{}
+	}
+	b.topics[topic][s] = struct{}{}
+	return s
+}
+
+// Close stops the subscription and closes C. It can be called more than
+// once.
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.remove(s)
+}
+
+// Publish sends e to the subscribers of topic without blocking. A
+// subscriber whose buffer is full is dropped and its channel closed, so
+// that it can catch up from the database instead of stalling the others.
+func (b *Broker) Publish(topic string, e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.topics[topic] {
+		select {
+		case s.c <- e:
+		default:
+			b.remove(s)
+		}
+	}
+}
+
+// remove unsubscribes s and closes its channel, unless it already was. The
+// caller must hold b.mu.
+func (b *Broker) remove(s *Subscription) {
+	subs, ok := b.topics[s.topic]
+	if !ok {
+		return
+	}
+	if _, ok := subs[s]; !ok {
+		return
+	}
+	delete(subs, s)
+	if len(subs) == 0 {
+		delete(b.topics, s.topic)
+	}
+	close(s.c)
+}
@@ -0,0 +1,155 @@
+package feed
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// Feed is a list of entries that can be rendered as an Atom or an RSS 2.0
+// document. Every URL must be absolute.
+type Feed struct {
+	Title   string
+	Link    string // the HTML page the feed follows
+	Self    string // the feed itself, used as the Atom feed id
+	Entries []*Entry
+}
+
+// Entry is a single item of a Feed. Content holds HTML, which is escaped
+// when the feed is rendered.
+type Entry struct {
+	Title     string
+	Link      string // also used as the entry id, so it must be permanent
+	Author    string
+	Content   string
+	Published time.Time
+}
+
+// Updated returns the time the feed last changed: the publication time of
+// its newest entry, or the Unix epoch if it has none.
+func (f *Feed) Updated() time.Time {
+	updated := time.Unix(0, 0).UTC()
+	for _, e := range f.Entries {
+		if e.Published.After(updated) {
+			updated = e.Published
+		}
+	}
+	return updated
+}
+
+type atomFeed struct {
+	XMLName xml.Name     `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string       `xml:"title"`
+	ID      string       `xml:"id"`
+	Updated string       `xml:"updated"`
+	Links   []atomLink   `xml:"link"`
+	Entries []*atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title     string   `xml:"title"`
+	ID        string   `xml:"id"`
+	Link      atomLink `xml:"link"`
+	Published string   `xml:"published"`
+	Updated   string   `xml:"updated"`
+	Author    string   `xml:"author>name"`
+	Content   atomText `xml:"content"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// Atom renders the feed as an Atom 1.0 document.
+func (f *Feed) Atom() ([]byte, error) {
+	doc := atomFeed{
+		Title:   f.Title,
+		ID:      f.Self,
+		Updated: f.Updated().UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: f.Self},
+			{Rel: "alternate", Type: "text/html", Href: f.Link},
+		},
+	}
+	for _, e := range f.Entries {
+		published := e.Published.UTC().Format(time.RFC3339)
+		doc.Entries = append(doc.Entries, &atomEntry{
+			Title:     e.Title,
+			ID:        e.Link,
+			Link:      atomLink{Rel: "alternate", Type: "text/html", Href: e.Link},
+			Published: published,
+			Updated:   published,
+			Author:    e.Author,
+			Content:   atomText{Type: "html", Body: e.Content},
+		})
+	}
+	return marshal(doc)
+}
+
+type rssDocument struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string     `xml:"title"`
+	Link          string     `xml:"link"`
+	Description   string     `xml:"description"`
+	LastBuildDate string     `xml:"lastBuildDate"`
+	Items         []*rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	Creator     string  `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Description string  `xml:"description"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// RSS renders the feed as an RSS 2.0 document. Authors are given as
+// dc:creator, since the RSS author element must be an email address.
+func (f *Feed) RSS() ([]byte, error) {
+	doc := rssDocument{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         f.Title,
+			Link:          f.Link,
+			Description:   f.Title,
+			LastBuildDate: f.Updated().UTC().Format(time.RFC1123Z),
+		},
+	}
+	for _, e := range f.Entries {
+		doc.Channel.Items = append(doc.Channel.Items, &rssItem{
+			Title:       e.Title,
+			Link:        e.Link,
+			GUID:        rssGUID{IsPermaLink: true, Value: e.Link},
+			Creator:     e.Author,
+			Description: e.Content,
+			PubDate:     e.Published.UTC().Format(time.RFC1123Z),
+		})
+	}
+	return marshal(doc)
+}
+
+// marshal encodes doc as an indented XML document with its declaration.
+func marshal(doc any) ([]byte, error) {
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
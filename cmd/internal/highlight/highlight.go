@@ -0,0 +1,252 @@
+package highlight
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Token classes emitted as span classes. They are styled by
+// ui/static/css/highlight.css; no inline styles are ever produced, so the
+// output is compatible with a Content-Security-Policy without 'unsafe-inline'.
+const (
+	Keyword  = "hl-keyword"
+	Type     = "hl-type"
+	Builtin  = "hl-builtin"
+	String   = "hl-string"
+	Number   = "hl-number"
+	Comment  = "hl-comment"
+	Literal  = "hl-literal"
+	Key      = "hl-key"
+	Variable = "hl-variable"
+)
+
+// rule matches a token at the current position. If the expression has a
+// capture group, only the group is given the class and the rest of the match
+// is emitted as plain text. An empty class marks an identifier, which is
+// looked up in the language's word list.
+type rule struct {
+	rx        *regexp.Regexp
+	class     string
+	lineStart bool // only match at the start of a line, after indentation
+}
+
+// language holds the lexing rules of a supported language.
+type language struct {
+	rules    []rule
+	words    map[string]string
+	foldCase bool
+}
+
+// aliases maps alternative fence info strings to language names.
+var aliases = map[string]string{
+	"golang":        "go",
+	"bash":          "shell",
+	"sh":            "shell",
+	"zsh":           "shell",
+	"console":       "shell",
+	"shell-session": "shell",
+	"yml":           "yaml",
+	"sqlite":        "sql",
+	"postgres":      "sql",
+	"postgresql":    "sql",
+	"mysql":         "sql",
+}
+
+// Supported reports whether lang has a highlighter.
+func Supported(lang string) bool {
+	_, ok := lookup(lang)
+	return ok
+}
+
+// Code returns code as HTML with its tokens wrapped in classed spans. Code
+// in an unsupported language is only escaped.
+func Code(lang, code string) string {
+	l, ok := lookup(lang)
+	if !ok {
+		return html.EscapeString(code)
+	}
+
+	var b strings.Builder
+	plain := 0
+	flush := func(end int) {
+		b.WriteString(html.EscapeString(code[plain:end]))
+	}
+
+	for i := 0; i < len(code); {
+		start, end, class, n := l.match(code, i)
+		if n == 0 {
+			i++
+			continue
+		}
+		flush(i)
+		if class == "" {
+			b.WriteString(html.EscapeString(code[i : i+n]))
+		} else {
+			b.WriteString(html.EscapeString(code[i : i+start]))
+			b.WriteString(`<span class="` + class + `">`)
+			b.WriteString(html.EscapeString(code[i+start : i+end]))
+			b.WriteString(`</span>`)
+			b.WriteString(html.EscapeString(code[i+end : i+n]))
+		}
+		i += n
+		plain = i
+	}
+	flush(len(code))
+	return b.String()
+}
+
+// lookup returns the language registered under lang or one of its aliases.
+func lookup(lang string) (*language, bool) {
+	lang = strings.ToLower(lang)
+	if alias, ok := aliases[lang]; ok {
+		lang = alias
+	}
+	l, ok := languages[lang]
+	return l, ok
+}
+
+// match tries every rule at code[i]. It returns the bounds of the highlighted
+// token relative to i, its class and the total length of the match. A zero
+// length means no rule matched.
+func (l *language) match(code string, i int) (int, int, string, int) {
+	rest := code[i:]
+	for _, r := range l.rules {
+		if r.lineStart && !atLineStart(code, i) {
+			continue
+		}
+		m := r.rx.FindStringSubmatchIndex(rest)
+		if m == nil || m[1] == 0 {
+			continue
+		}
+		start, end := m[0], m[1]
+		if len(m) > 2 && m[2] >= 0 {
+			start, end = m[2], m[3]
+		}
+
+		class := r.class
+		if class == "" {
+			word := rest[start:end]
+			if l.foldCase {
+				word = strings.ToLower(word)
+			}
+			class = l.words[word]
+		}
+		return start, end, class, m[1]
+	}
+	return 0, 0, "", 0
+}
+
+// atLineStart reports whether only indentation and YAML sequence markers
+// precede position i on its line.
+func atLineStart(code string, i int) bool {
+	start := strings.LastIndexByte(code[:i], '\n') + 1
+	return strings.Trim(code[start:i], " \t-") == ""
+}
+
+// words builds a word list assigning class to every word in list.
+func words(dst map[string]string, class string, list string) map[string]string {
+	if dst == nil {
+		dst = make(map[string]string)
+	}
+	for _, w := range strings.Fields(list) {
+		dst[w] = class
+	}
+	return dst
+}
+
+var (
+	identRX   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*`)
+	numberRX  = regexp.MustCompile(`^(?:0[xX][0-9a-fA-F_]+|[0-9][0-9_]*(?:\.[0-9_]+)?(?:[eE][+-]?[0-9]+)?)\b`)
+	dqStrRX   = regexp.MustCompile(`^"(?:[^"\\\n]|\\.)*"?`)
+	blockCmRX = regexp.MustCompile(`^/\*(?s:.*?)(?:\*/|$)`)
+)
+
+var languages = map[string]*language{
+	"go": {
+		rules: []rule{
+			{rx: regexp.MustCompile(`^//[^\n]*`), class: Comment},
+			{rx: blockCmRX, class: Comment},
+			{rx: dqStrRX, class: String},
+			{rx: regexp.MustCompile("^`[^`]*`?"), class: String},
+			{rx: regexp.MustCompile(`^'(?:[^'\\\n]|\\[^\n]+?)'`), class: String},
+			{rx: numberRX, class: Number},
+			{rx: identRX},
+		},
+		words: words(words(words(words(nil,
+			Keyword, `break case chan const continue default defer else fallthrough
+				for func go goto if import interface map package range return select
+				struct switch type var`),
+			Type, `bool byte complex64 complex128 error float32 float64 int int8 int16
+				int32 int64 rune string uint uint8 uint16 uint32 uint64 uintptr any
+				comparable`),
+			Builtin, `append cap clear close complex copy delete imag len make max min
+				new panic print println real recover`),
+			Literal, `true false nil iota`),
+	},
+
+	"sql": {
+		foldCase: true,
+		rules: []rule{
+			{rx: regexp.MustCompile(`^--[^\n]*`), class: Comment},
+			{rx: blockCmRX, class: Comment},
+			{rx: regexp.MustCompile(`^'(?:[^']|'')*'?`), class: String},
+			{rx: regexp.MustCompile(`^"[^"\n]*"?`), class: Variable},
+			{rx: numberRX, class: Number},
+			{rx: identRX},
+		},
+		words: words(words(words(words(nil,
+			Keyword, `add all alter and as asc begin between by case check column commit
+				constraint create cross default delete desc distinct drop else end
+				exists foreign from full group having if in index inner insert into is
+				join key left like limit not offset on or order outer primary
+				references rename returning right rollback select set table then
+				transaction union unique update using values view when where with`),
+			Type, `bigint blob boolean char date datetime decimal double float int integer
+				numeric real smallint text time timestamp varchar`),
+			Builtin, `avg coalesce count ifnull lower max min nullif round substr sum
+				upper current_timestamp current_date`),
+			Literal, `null true false`),
+	},
+
+	"json": {
+		rules: []rule{
+			{rx: regexp.MustCompile(`^("(?:[^"\\\n]|\\.)*")\s*:`), class: Key},
+			{rx: dqStrRX, class: String},
+			{rx: regexp.MustCompile(`^-?[0-9]+(?:\.[0-9]+)?(?:[eE][+-]?[0-9]+)?`), class: Number},
+			{rx: identRX},
+		},
+		words: words(nil, Literal, `true false null`),
+	},
+
+	"yaml": {
+		rules: []rule{
+			{rx: regexp.MustCompile(`^#[^\n]*`), class: Comment},
+			{rx: regexp.MustCompile(`^([^\s#:'"\[\]{},&*!|>%@\-` + "`" + `][^\n:#]*?|"[^"\n]*"|'[^'\n]*'):(?:[ \t\n]|$)`), class: Key, lineStart: true},
+			{rx: dqStrRX, class: String},
+			{rx: regexp.MustCompile(`^'(?:[^'\n]|'')*'?`), class: String},
+			{rx: regexp.MustCompile(`^[&*][A-Za-z0-9_-]+`), class: Variable},
+			{rx: regexp.MustCompile(`^-?[0-9]+(?:\.[0-9]+)?\b`), class: Number},
+			{rx: identRX},
+		},
+		words: words(nil, Literal, `true false null yes no on off True False Null`),
+	},
+
+	"shell": {
+		rules: []rule{
+			{rx: regexp.MustCompile(`^#[^\n]*`), class: Comment, lineStart: true},
+			{rx: regexp.MustCompile(`^[ \t](#[^\n]*)`), class: Comment},
+			{rx: regexp.MustCompile(`^"(?:[^"\\]|\\.)*"?`), class: String},
+			{rx: regexp.MustCompile(`^'[^']*'?`), class: String},
+			{rx: regexp.MustCompile(`^\$(?:\{[^}\n]*\}?|[A-Za-z_][A-Za-z0-9_]*|[0-9@#?$!*-])`), class: Variable},
+			{rx: regexp.MustCompile(`^[0-9]+\b`), class: Number},
+			{rx: regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*`)},
+		},
+		words: words(words(nil,
+			Keyword, `if then else elif fi for while until do done case esac in function
+				return select time`),
+			Builtin, `alias bg cd command declare echo eval exec exit export fg local
+				printf pwd read readonly set shift source test trap type ulimit umask
+				unset wait sudo`),
+	},
+}
@@ -0,0 +1,136 @@
+package highlight
+
+import "testing"
+
+// span returns s wrapped in a span of the given class, as Code emits it.
+func span(class, s string) string {
+	return `<span class="` + class + `">` + s + `</span>`
+}
+
+func TestCode(t *testing.T) {
+	tests := []struct {
+		name string
+		lang string
+		code string
+		want string
+	}{
+		{
+			name: "go",
+			lang: "go",
+			code: "func f(n int) error {\n\treturn nil // done\n}",
+			want: span(Keyword, "func") + " f(n " + span(Type, "int") + ") " + span(Type, "error") + " {\n\t" +
+				span(Keyword, "return") + " " + span(Literal, "nil") + " " + span(Comment, "// done") + "\n}",
+		},
+		{
+			name: "go builtins and numbers",
+			lang: "golang",
+			code: "x := len(s) + 0x1F",
+			want: "x := " + span(Builtin, "len") + "(s) + " + span(Number, "0x1F"),
+		},
+		{
+			name: "go string escaping",
+			lang: "go",
+			code: `s := "<a href=\"x\">&</a>"`,
+			want: "s := " + span(String, `&#34;&lt;a href=\&#34;x\&#34;&gt;&amp;&lt;/a&gt;&#34;`),
+		},
+		{
+			name: "go raw string and rune",
+			lang: "go",
+			code: "`it's <b>` + '\\''",
+			want: span(String, "`it&#39;s &lt;b&gt;`") + " + " + span(String, `&#39;\&#39;&#39;`),
+		},
+		{
+			name: "go comment escaping",
+			lang: "go",
+			code: "/* a < b && \"c\" */ x",
+			want: span(Comment, "/* a &lt; b &amp;&amp; &#34;c&#34; */") + " x",
+		},
+		{
+			name: "sql",
+			lang: "sql",
+			code: "SELECT count(*) FROM users WHERE name = 'O''Brien' AND id > 10; -- <all>",
+			want: span(Keyword, "SELECT") + " " + span(Builtin, "count") + "(*) " + span(Keyword, "FROM") +
+				" users " + span(Keyword, "WHERE") + " name = " + span(String, "&#39;O&#39;&#39;Brien&#39;") +
+				" " + span(Keyword, "AND") + " id &gt; " + span(Number, "10") + "; " + span(Comment, "-- &lt;all&gt;"),
+		},
+		{
+			name: "sql case folding",
+			lang: "postgres",
+			code: `select "user" is null`,
+			want: span(Keyword, "select") + " " + span(Variable, "&#34;user&#34;") + " " +
+				span(Keyword, "is") + " " + span(Literal, "null"),
+		},
+		{
+			name: "json",
+			lang: "json",
+			code: `{"a&b": "<x>", "n": -1.5, "ok": true, "v": null}`,
+			want: "{" + span(Key, "&#34;a&amp;b&#34;") + ": " + span(String, "&#34;&lt;x&gt;&#34;") + ", " +
+				span(Key, "&#34;n&#34;") + ": " + span(Number, "-1.5") + ", " +
+				span(Key, "&#34;ok&#34;") + ": " + span(Literal, "true") + ", " +
+				span(Key, "&#34;v&#34;") + ": " + span(Literal, "null") + "}",
+		},
+		{
+			name: "yaml",
+			lang: "yml",
+			code: "# <config>\nname: \"a & b\"\nitems:\n  - port: 80\n    base: *defaults\nenabled: yes",
+			want: span(Comment, "# &lt;config&gt;") + "\n" +
+				span(Key, "name") + ": " + span(String, "&#34;a &amp; b&#34;") + "\n" +
+				span(Key, "items") + ":\n  - " + span(Key, "port") + ": " + span(Number, "80") + "\n    " +
+				span(Key, "base") + ": " + span(Variable, "*defaults") + "\n" +
+				span(Key, "enabled") + ": " + span(Literal, "yes"),
+		},
+		{
+			name: "shell",
+			lang: "bash",
+			code: "# build\nif [ -n \"$HOME\" ]; then echo 'a<b' > out; fi # done",
+			want: span(Comment, "# build") + "\n" + span(Keyword, "if") + " [ -n " +
+				span(String, "&#34;$HOME&#34;") + " ]; " + span(Keyword, "then") + " " + span(Builtin, "echo") + " " +
+				span(String, "&#39;a&lt;b&#39;") + " &gt; out; " + span(Keyword, "fi") + " " + span(Comment, "# done"),
+		},
+		{
+			name: "shell variables",
+			lang: "sh",
+			code: "cd ${DIR}/$1 && ls a#b",
+			want: span(Builtin, "cd") + " " + span(Variable, "${DIR}") + "/" + span(Variable, "$1") + " &amp;&amp; ls a#b",
+		},
+		{
+			name: "unknown language",
+			lang: "brainfuck",
+			code: `if x < 1 { "a" & 'b' }`,
+			want: `if x &lt; 1 { &#34;a&#34; &amp; &#39;b&#39; }`,
+		},
+		{
+			name: "no language",
+			lang: "",
+			code: `<script>alert("x")</script>`,
+			want: `&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;`,
+		},
+		{
+			name: "unterminated string",
+			lang: "go",
+			code: `x := "<`,
+			want: "x := " + span(String, "&#34;&lt;"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Code(tt.lang, tt.code); got != tt.want {
+				t.Errorf("Code(%q, %q)\ngot  %s\nwant %s", tt.lang, tt.code, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSupported(t *testing.T) {
+	for _, lang := range []string{"go", "Go", "golang", "sql", "sqlite", "json", "yaml", "yml", "shell", "bash", "sh"} {
+		if !Supported(lang) {
+			t.Errorf("Supported(%q) = false", lang)
+		}
+	}
+	for _, lang := range []string{"", "python", "html"} {
+		if Supported(lang) {
+			t.Errorf("Supported(%q) = true", lang)
+		}
+	}
+}
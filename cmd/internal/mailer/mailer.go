@@ -0,0 +1,105 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+	// Headers holds extra headers such as List-Unsubscribe.
+	Headers map[string]string
+}
+
+// Sender is implemented by the transports emails are delivered with.
+type Sender interface {
+	Send(msg Message) error
+}
+
+// SMTP sends emails through an SMTP server.
+type SMTP struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+// Send delivers msg through the SMTP server, authenticating if a username is
+// configured.
+func (s *SMTP) Send(msg Message) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host := s.Addr
+		if i := strings.LastIndexByte(host, ':'); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	err := smtp.SendMail(s.Addr, auth, s.From, []string{msg.To}, encode(s.From, msg))
+	if err != nil {
+		return fmt.Errorf("sending mail to %s: %w", msg.To, err)
+	}
+	return nil
+}
+
+// FileSink writes every email as a .eml file in a directory instead of
+// sending it. It is meant for development and testing.
+type FileSink struct {
+	Dir  string
+	From string
+}
+
+// Send writes msg to a new file in the sink directory.
+func (s *FileSink) Send(msg Message) error {
+	err := os.MkdirAll(s.Dir, 0o755)
+	if err != nil {
+		return fmt.Errorf("creating mail directory: %w", err)
+	}
+
+	suffix := make([]byte, 4)
+	_, err = rand.Read(suffix)
+	if err != nil {
+		return fmt.Errorf("generating file name: %w", err)
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), hex.EncodeToString(suffix))
+
+	err = os.WriteFile(filepath.Join(s.Dir, name), encode(s.From, msg), 0o644)
+	if err != nil {
+		return fmt.Errorf("writing mail file: %w", err)
+	}
+	return nil
+}
+
+// encode formats msg as an RFC 5322 message.
+func encode(from string, msg Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", headerValue(from))
+	fmt.Fprintf(&b, "To: %s\r\n", headerValue(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	for k, v := range msg.Headers {
+		fmt.Fprintf(&b, "%s: %s\r\n", headerValue(k), headerValue(v))
+	}
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// headerValue strips line breaks from a header so it cannot inject others.
+func headerValue(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
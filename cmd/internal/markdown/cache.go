@@ -0,0 +1,58 @@
+package markdown
+
+import (
+	"container/list"
+	"html/template"
+	"sync"
+)
+
+// Cache memoizes rendered Markdown. Entries are keyed by an identifier of the
+// source revision, such as a message id and revision number, and the least
+// recently used entries are evicted once the cache is full.
+type Cache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+// cacheEntry is the value stored in the elements of Cache.order.
+type cacheEntry struct {
+	key  string
+	html template.HTML
+}
+
+// NewCache returns a Cache holding at most capacity rendered documents.
+func NewCache(capacity int) *Cache {
+	return &Cache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Render returns the sanitized HTML for src, rendering it only if key is not
+// already cached. Callers must change key whenever src or opts change.
+func (c *Cache) Render(key, src string, opts Options) template.HTML {
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		c.mu.Unlock()
+		return e.Value.(*cacheEntry).html
+	}
+	c.mu.Unlock()
+
+	rendered := Render(src, opts)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		c.entries[key] = c.order.PushFront(&cacheEntry{key: key, html: rendered})
+		for c.order.Len() > c.capacity {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*cacheEntry).key)
+		}
+	}
+	return rendered
+}
@@ -0,0 +1,400 @@
+package markdown
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+
+	"forum/cmd/internal/highlight"
+)
+
+// Options customises how Markdown is rendered.
+type Options struct {
+	// Mention is called for every @name found outside of code. If it returns
+	// a non-empty URL, the mention is rendered as a link to it.
+	Mention func(name string) string
+}
+
+// renderer accumulates the HTML output of a single rendering.
+type renderer struct {
+	b    strings.Builder
+	opts Options
+}
+
+// Render converts Markdown source to HTML and sanitizes the result. It is the
+// only way rendered message bodies are turned into template.HTML.
+func Render(src string, opts Options) template.HTML {
+	return template.HTML(Sanitize(ToHTML(src, opts)))
+}
+
+// Mentions returns the distinct names @mentioned in src, in order of first
+// appearance. Mentions inside code spans and code blocks are ignored.
+func Mentions(src string) []string {
+	var names []string
+	seen := map[string]bool{}
+	ToHTML(src, Options{Mention: func(name string) string {
+		key := strings.ToLower(name)
+		if !seen[key] {
+			seen[key] = true
+			names = append(names, name)
+		}
+		return ""
+	}})
+	return names
+}
+
+// ToHTML converts Markdown source to HTML. Raw HTML in the source is escaped
+// rather than passed through. The supported syntax is a pragmatic subset of
+// CommonMark: paragraphs, hard line breaks, ATX headings, block quotes,
+// ordered and unordered lists, fenced code blocks, thematic breaks, emphasis,
+// strikethrough, code spans, links, autolinks and @mentions.
+func ToHTML(src string, opts Options) string {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	src = strings.ReplaceAll(src, "\r", "\n")
+
+	r := &renderer{opts: opts}
+	renderBlocks(r, strings.Split(src, "\n"))
+	return r.b.String()
+}
+
+var (
+	headingRX   = regexp.MustCompile(`^(#{1,6})[ \t]+(.*?)[ \t#]*$`)
+	hrRX        = regexp.MustCompile(`^ {0,3}(?:(?:-[ \t]*){3,}|(?:\*[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	fenceRX     = regexp.MustCompile("^ {0,3}(```+|~~~+)[ \t]*([^ \t`]*)")
+	bulletRX    = regexp.MustCompile(`^ {0,3}[-*+][ \t]+(.*)$`)
+	orderedRX   = regexp.MustCompile(`^ {0,3}\d{1,9}[.)][ \t]+(.*)$`)
+	quoteRX     = regexp.MustCompile(`^ {0,3}>[ \t]?(.*)$`)
+	languageRX  = regexp.MustCompile(`^[A-Za-z0-9_+-]{1,20}$`)
+	continueRX  = regexp.MustCompile(`^( {2,}|\t)(.*)$`)
+	listItemRXs = []*regexp.Regexp{bulletRX, orderedRX}
+)
+
+// renderBlocks renders a sequence of lines as block-level elements.
+func renderBlocks(r *renderer, lines []string) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+
+		case fenceRX.MatchString(line):
+			i = renderFence(r, lines, i)
+
+		case headingRX.MatchString(line):
+			m := headingRX.FindStringSubmatch(line)
+			level := string(rune('0' + len(m[1])))
+			r.b.WriteString("<h" + level + ">")
+			renderInline(r, m[2])
+			r.b.WriteString("</h" + level + ">\n")
+			i++
+
+		case hrRX.MatchString(line):
+			r.b.WriteString("<hr>\n")
+			i++
+
+		case quoteRX.MatchString(line):
+			var inner []string
+			for ; i < len(lines) && quoteRX.MatchString(lines[i]); i++ {
+				inner = append(inner, quoteRX.FindStringSubmatch(lines[i])[1])
+			}
+			r.b.WriteString("<blockquote>\n")
+			renderBlocks(r, inner)
+			r.b.WriteString("</blockquote>\n")
+
+		case bulletRX.MatchString(line):
+			i = renderList(r, lines, i, bulletRX, "ul")
+
+		case orderedRX.MatchString(line):
+			i = renderList(r, lines, i, orderedRX, "ol")
+
+		default:
+			i = renderParagraph(r, lines, i)
+		}
+	}
+}
+
+// renderFence renders the fenced code block starting at lines[start] and
+// returns the index of the first line after it. An unterminated fence runs
+// to the end of the input.
+func renderFence(r *renderer, lines []string, start int) int {
+	m := fenceRX.FindStringSubmatch(lines[start])
+	fence, lang := m[1], m[2]
+
+	i := start + 1
+	var code []string
+	for ; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			i++
+			break
+		}
+		code = append(code, lines[i])
+	}
+
+	r.b.WriteString("<pre><code")
+	if languageRX.MatchString(lang) {
+		r.b.WriteString(` class="language-` + strings.ToLower(lang) + `"`)
+	}
+	r.b.WriteString(">")
+	source := strings.Join(code, "\n")
+	if len(code) > 0 {
+		source += "\n"
+	}
+	// Unknown languages fall back to plain escaped text.
+	r.b.WriteString(highlight.Code(lang, source))
+	r.b.WriteString("</code></pre>\n")
+	return i
+}
+
+// renderList renders the list starting at lines[start] and returns the index
+// of the first line after it. Indented lines continue the previous item.
+func renderList(r *renderer, lines []string, start int, itemRX *regexp.Regexp, tag string) int {
+	var items [][]string
+	i := start
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if m := itemRX.FindStringSubmatch(line); m != nil {
+			items = append(items, []string{m[1]})
+			continue
+		}
+		if m := continueRX.FindStringSubmatch(line); m != nil && strings.TrimSpace(line) != "" {
+			last := len(items) - 1
+			items[last] = append(items[last], strings.TrimSpace(m[2]))
+			continue
+		}
+		break
+	}
+
+	r.b.WriteString("<" + tag + ">\n")
+	for _, item := range items {
+		r.b.WriteString("<li>")
+		renderInline(r, strings.Join(item, "\n"))
+		r.b.WriteString("</li>\n")
+	}
+	r.b.WriteString("</" + tag + ">\n")
+	return i
+}
+
+// renderParagraph renders the paragraph starting at lines[start] and returns
+// the index of the first line after it.
+func renderParagraph(r *renderer, lines []string, start int) int {
+	i := start
+	var text []string
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if strings.TrimSpace(line) == "" || (i > start && startsBlock(line)) {
+			break
+		}
+		text = append(text, strings.TrimSpace(line))
+	}
+
+	r.b.WriteString("<p>")
+	renderInline(r, strings.Join(text, "\n"))
+	r.b.WriteString("</p>\n")
+	return i
+}
+
+// startsBlock reports whether line interrupts a paragraph.
+func startsBlock(line string) bool {
+	if fenceRX.MatchString(line) || headingRX.MatchString(line) ||
+		hrRX.MatchString(line) || quoteRX.MatchString(line) {
+		return true
+	}
+	for _, rx := range listItemRXs {
+		if rx.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	linkRX     = regexp.MustCompile(`^\[([^\[\]]*)\]\(\s*<?([^\s()<>]*)>?(?:\s+"([^"]*)")?\s*\)`)
+	autolinkRX = regexp.MustCompile(`^<((?:https?|mailto):[^\s<>]+)>`)
+	bareURLRX  = regexp.MustCompile(`^https?://[^\s<>]*[^\s<>.,;:!?'")\]]`)
+	mentionRX  = regexp.MustCompile(`^@([A-Za-z0-9_](?:[A-Za-z0-9_.-]*[A-Za-z0-9_])?)`)
+)
+
+// renderInline renders inline Markdown: emphasis, strikethrough, code spans,
+// links and line breaks. Everything else is HTML escaped.
+func renderInline(r *renderer, s string) {
+	renderSpan(r, s, true)
+}
+
+// renderSpan renders inline Markdown. Links are only recognised if links is
+// true, so that link text never contains nested anchors.
+func renderSpan(r *renderer, s string, links bool) {
+	for i := 0; i < len(s); {
+		rest := s[i:]
+		c := s[i]
+
+		switch {
+		case c == '\\' && i+1 < len(s) && isPunct(s[i+1]):
+			r.b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			continue
+
+		case c == '\n':
+			r.b.WriteString("<br>\n")
+			i++
+			continue
+
+		case c == '`':
+			n := runLength(rest, '`')
+			if end := strings.Index(rest[n:], rest[:n]); end >= 0 {
+				code := rest[n : n+end]
+				r.b.WriteString("<code>")
+				r.b.WriteString(html.EscapeString(strings.TrimSpace(code)))
+				r.b.WriteString("</code>")
+				i += n + end + n
+				continue
+			}
+			r.b.WriteString(rest[:n])
+			i += n
+			continue
+
+		case c == '[' && links:
+			if m := linkRX.FindStringSubmatch(rest); m != nil {
+				writeLink(r, m[2], m[3], m[1])
+				i += len(m[0])
+				continue
+			}
+
+		case c == '<' && links:
+			if m := autolinkRX.FindStringSubmatch(rest); m != nil {
+				writeLink(r, m[1], "", "")
+				i += len(m[0])
+				continue
+			}
+
+		case c == 'h' && links && (i == 0 || !isWordByte(s[i-1])):
+			if m := bareURLRX.FindString(rest); m != "" {
+				writeLink(r, m, "", "")
+				i += len(m)
+				continue
+			}
+
+		case c == '@' && (i == 0 || !isWordByte(s[i-1])):
+			if m := mentionRX.FindStringSubmatch(rest); m != nil {
+				writeMention(r, m[1], links)
+				i += len(m[0])
+				continue
+			}
+
+		case c == '*' || c == '_' || c == '~':
+			if n, ok := renderEmphasis(r, s, i, links); ok {
+				i += n
+				continue
+			}
+		}
+
+		r.b.WriteString(html.EscapeString(s[i : i+1]))
+		i++
+	}
+}
+
+// renderEmphasis renders the emphasis span opened by the delimiter run at
+// s[i], if it is closed later in s, and returns the number of bytes consumed.
+// Underscores only open and close at word boundaries so that snake_case
+// identifiers are left alone.
+func renderEmphasis(r *renderer, s string, i int, links bool) (int, bool) {
+	c := s[i]
+	n := min(runLength(s[i:], c), 2)
+	if c == '~' && n != 2 {
+		return 0, false
+	}
+	if c == '_' && i > 0 && isWordByte(s[i-1]) {
+		return 0, false
+	}
+
+	delim := s[i : i+n]
+	open := i + n
+	if open >= len(s) || s[open] == ' ' || s[open] == '\n' {
+		return 0, false
+	}
+
+	for j := open + 1; j+n <= len(s); j++ {
+		if s[j:j+n] != delim || s[j-1] == ' ' || s[j-1] == '\\' {
+			continue
+		}
+		if c == '_' && j+n < len(s) && isWordByte(s[j+n]) {
+			continue
+		}
+		if n == 1 && j+1 < len(s) && s[j+1] == c {
+			// Part of a longer run; let the strong span claim it.
+			j++
+			continue
+		}
+
+		tag := "em"
+		switch {
+		case c == '~':
+			tag = "del"
+		case n == 2:
+			tag = "strong"
+		}
+		r.b.WriteString("<" + tag + ">")
+		renderSpan(r, s[open:j], links)
+		r.b.WriteString("</" + tag + ">")
+		return j + n - i, true
+	}
+	return 0, false
+}
+
+// writeLink writes an anchor element if href is a safe URL, and only its
+// text otherwise. An empty text displays the URL itself.
+func writeLink(r *renderer, href, title, text string) {
+	if !SafeURL(href) {
+		if text == "" {
+			r.b.WriteString(html.EscapeString(href))
+		}
+		renderSpan(r, text, false)
+		return
+	}
+	r.b.WriteString(`<a href="` + html.EscapeString(href) + `"`)
+	if title != "" {
+		r.b.WriteString(` title="` + html.EscapeString(title) + `"`)
+	}
+	r.b.WriteString(">")
+	if text == "" {
+		r.b.WriteString(html.EscapeString(href))
+	} else {
+		renderSpan(r, text, false)
+	}
+	r.b.WriteString("</a>")
+}
+
+// writeMention writes an @mention, as a link to the mentioned user if the
+// Mention option resolves it and links are allowed here.
+func writeMention(r *renderer, name string, links bool) {
+	var href string
+	if r.opts.Mention != nil {
+		href = r.opts.Mention(name)
+	}
+	if href == "" || !links || !SafeURL(href) {
+		r.b.WriteString("@" + html.EscapeString(name))
+		return
+	}
+	r.b.WriteString(`<a href="` + html.EscapeString(href) + `" class="mention">@` + html.EscapeString(name) + `</a>`)
+}
+
+// runLength returns how many times c repeats at the start of s.
+func runLength(s string, c byte) int {
+	n := 0
+	for n < len(s) && s[n] == c {
+		n++
+	}
+	return n
+}
+
+// isPunct reports whether c is an ASCII punctuation character.
+func isPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
+
+// isWordByte reports whether c is an ASCII letter or digit.
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
@@ -0,0 +1,179 @@
+package markdown
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// allowedTags maps every element allowed in sanitized HTML to the attributes
+// it may carry. Anything else is escaped and displayed as text.
+var allowedTags = map[string][]string{
+	"a":          {"href", "title", "class"},
+	"blockquote": nil,
+	"br":         nil,
+	"code":       {"class"},
+	"del":        nil,
+	"em":         nil,
+	"h1":         nil,
+	"h2":         nil,
+	"h3":         nil,
+	"h4":         nil,
+	"h5":         nil,
+	"h6":         nil,
+	"hr":         nil,
+	"li":         nil,
+	"ol":         nil,
+	"p":          nil,
+	"pre":        nil,
+	"span":       {"class"},
+	"strong":     nil,
+	"ul":         nil,
+}
+
+// voidTags lists the allowed elements that have no closing tag.
+var voidTags = map[string]bool{"br": true, "hr": true}
+
+var (
+	tagRX    = regexp.MustCompile(`^<(/?)([a-zA-Z][a-zA-Z0-9]*)((?:\s+[a-zA-Z_:][-a-zA-Z0-9_:.]*(?:\s*=\s*(?:"[^"]*"|'[^']*'|[^\s"'=<>` + "`" + `]+))?)*)\s*(/?)>`)
+	attrRX   = regexp.MustCompile(`([a-zA-Z_:][-a-zA-Z0-9_:.]*)(?:\s*=\s*("[^"]*"|'[^']*'|[^\s"'=<>` + "`" + `]+))?`)
+	entityRX = regexp.MustCompile(`^&(?:[a-zA-Z][a-zA-Z0-9]{1,31}|#[0-9]{1,7}|#[xX][0-9a-fA-F]{1,6});`)
+	classRX  = regexp.MustCompile(`^(?:language-[a-z0-9_+-]{1,20}|hl-[a-z]{1,20}|mention)$`)
+)
+
+// Sanitize filters an HTML fragment through an allowlist of tags and
+// attributes. Disallowed markup is escaped rather than dropped so that no
+// text is silently lost, links are restricted to safe URL schemes and marked
+// rel="nofollow", and unclosed elements are closed at the end.
+func Sanitize(s string) string {
+	var (
+		b    strings.Builder
+		open []string
+	)
+
+	for i := 0; i < len(s); {
+		switch s[i] {
+		case '<':
+			m := tagRX.FindStringSubmatch(s[i:])
+			if m == nil {
+				b.WriteString("&lt;")
+				i++
+				continue
+			}
+			closing, name, attrs := m[1] == "/", strings.ToLower(m[2]), m[3]
+
+			allowed, ok := allowedTags[name]
+			if !ok {
+				b.WriteString(html.EscapeString(m[0]))
+				i += len(m[0])
+				continue
+			}
+			i += len(m[0])
+
+			if closing {
+				// Close the innermost matching element, along with any
+				// elements left open inside it.
+				for j := len(open) - 1; j >= 0; j-- {
+					if open[j] != name {
+						continue
+					}
+					for k := len(open) - 1; k >= j; k-- {
+						b.WriteString("</" + open[k] + ">")
+					}
+					open = open[:j]
+					break
+				}
+				continue
+			}
+
+			b.WriteString("<" + name)
+			writeAttrs(&b, name, attrs, allowed)
+			b.WriteString(">")
+			if !voidTags[name] {
+				open = append(open, name)
+			}
+
+		case '&':
+			if m := entityRX.FindString(s[i:]); m != "" {
+				b.WriteString(m)
+				i += len(m)
+			} else {
+				b.WriteString("&amp;")
+				i++
+			}
+
+		case '>':
+			b.WriteString("&gt;")
+			i++
+
+		case '"':
+			b.WriteString("&#34;")
+			i++
+
+		default:
+			b.WriteByte(s[i])
+			i++
+		}
+	}
+
+	for j := len(open) - 1; j >= 0; j-- {
+		b.WriteString("</" + open[j] + ">")
+	}
+	return b.String()
+}
+
+// writeAttrs writes the allowed attributes of a tag, dropping unsafe values.
+func writeAttrs(b *strings.Builder, tag, attrs string, allowed []string) {
+	for _, m := range attrRX.FindAllStringSubmatch(attrs, -1) {
+		name := strings.ToLower(m[1])
+		if !contains(allowed, name) {
+			continue
+		}
+		value := html.UnescapeString(strings.Trim(m[2], `"'`))
+
+		switch name {
+		case "href":
+			if !SafeURL(value) {
+				continue
+			}
+		case "class":
+			if !classRX.MatchString(value) {
+				continue
+			}
+		}
+		b.WriteString(" " + name + `="` + html.EscapeString(value) + `"`)
+	}
+	if tag == "a" {
+		b.WriteString(` rel="nofollow noopener noreferrer"`)
+	}
+}
+
+// SafeURL reports whether u can be used as a link target: an http, https or
+// mailto URL, or a relative reference within the forum.
+func SafeURL(u string) bool {
+	if u == "" || strings.ContainsAny(u, "\x00\t\n\r") {
+		return false
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "http", "https", "mailto":
+		return true
+	case "":
+		return !strings.HasPrefix(u, "//") && parsed.Host == ""
+	}
+	return false
+}
+
+// contains reports whether list contains s.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+package markdown
+
+import "testing"
+
+const rel = ` rel="nofollow noopener noreferrer"`
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "script",
+			in:   `<script>alert(1)</script>`,
+			want: `&lt;script&gt;alert(1)&lt;/script&gt;`,
+		},
+		{
+			name: "img onerror",
+			in:   `<img src=x onerror=alert(1)>`,
+			want: `&lt;img src=x onerror=alert(1)&gt;`,
+		},
+		{
+			name: "event handler on allowed tag",
+			in:   `<p onclick="alert(1)">hi</p>`,
+			want: `<p>hi</p>`,
+		},
+		{
+			name: "link",
+			in:   `<a href="https://example.com/?a=1&amp;b=2" title="Example">x</a>`,
+			want: `<a href="https://example.com/?a=1&amp;b=2" title="Example"` + rel + `>x</a>`,
+		},
+		{
+			name: "rel is not overridden",
+			in:   `<a href="/thread/view/1" rel="opener">x</a>`,
+			want: `<a href="/thread/view/1"` + rel + `>x</a>`,
+		},
+		{
+			name: "javascript URL",
+			in:   `<a href="javascript:alert(1)">x</a>`,
+			want: `<a` + rel + `>x</a>`,
+		},
+		{
+			name: "mixed case scheme",
+			in:   `<a href="JaVaScRiPt:alert(1)">x</a>`,
+			want: `<a` + rel + `>x</a>`,
+		},
+		{
+			name: "entity encoded scheme",
+			in:   `<a href="&#106;avascript&#58;alert(1)">x</a>`,
+			want: `<a` + rel + `>x</a>`,
+		},
+		{
+			name: "entity encoded tab in scheme",
+			in:   `<a href="java&#x09;script:alert(1)">x</a>`,
+			want: `<a` + rel + `>x</a>`,
+		},
+		{
+			name: "data URL",
+			in:   `<a href="data:text/html;base64,PHNjcmlwdD4=">x</a>`,
+			want: `<a` + rel + `>x</a>`,
+		},
+		{
+			name: "unquoted attribute",
+			in:   `<a href=/tags title=Tags>x</a>`,
+			want: `<a href="/tags" title="Tags"` + rel + `>x</a>`,
+		},
+		{
+			name: "quote breakout",
+			in:   `<a href="/t" title='a" onmouseover="alert(1)'>x</a>`,
+			want: `<a href="/t" title="a&#34; onmouseover=&#34;alert(1)"` + rel + `>x</a>`,
+		},
+		{
+			name: "angle bracket in attribute",
+			in:   `<a href="/t" title="a><script>">x</a>`,
+			want: `<a href="/t" title="a&gt;&lt;script&gt;"` + rel + `>x</a>`,
+		},
+		{
+			name: "unterminated tag",
+			in:   `<a href="/t" onclick="alert(1)"`,
+			want: `&lt;a href=&#34;/t&#34; onclick=&#34;alert(1)&#34;`,
+		},
+		{
+			name: "allowed class",
+			in:   `<code class="language-go">x</code>`,
+			want: `<code class="language-go">x</code>`,
+		},
+		{
+			name: "disallowed class and style",
+			in:   `<span class="hidden" style="display:none">x</span>`,
+			want: `<span>x</span>`,
+		},
+		{
+			name: "unclosed tags",
+			in:   `<p><em>a`,
+			want: `<p><em>a</em></p>`,
+		},
+		{
+			name: "misnested tags",
+			in:   `<strong><em>a</strong></em>`,
+			want: `<strong><em>a</em></strong>`,
+		},
+		{
+			name: "nested lists",
+			in:   `<ul><li>a<ul><li>b</ul></ul>`,
+			want: `<ul><li>a<ul><li>b</li></ul></li></ul>`,
+		},
+		{
+			name: "stray closing tag",
+			in:   `a</em>b`,
+			want: `ab`,
+		},
+		{
+			name: "void tags",
+			in:   `a<br/>b<hr>`,
+			want: `a<br>b<hr>`,
+		},
+		{
+			name: "text",
+			in:   `a < b && "c" > d &amp; &copy;`,
+			want: `a &lt; b &amp;&amp; &#34;c&#34; &gt; d &amp; &copy;`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sanitize(tt.in); got != tt.want {
+				t.Errorf("Sanitize(%q)\ngot  %q\nwant %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSafeURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://example.com/a?b=c#d", true},
+		{"http://example.com", true},
+		{"HTTPS://example.com", true},
+		{"mailto:someone@example.com", true},
+		{"/thread/view/1", true},
+		{"thread/view/1", true},
+		{"#message-2", true},
+		{"?page=2", true},
+		{"", false},
+		{"//evil.example.com", false},
+		{"javascript:alert(1)", false},
+		{"JavaScript:alert(1)", false},
+		{" javascript:alert(1)", false},
+		{"java\tscript:alert(1)", false},
+		{"java\nscript:alert(1)", false},
+		{"javascript\x00:alert(1)", false},
+		{"vbscript:msgbox(1)", false},
+		{"data:text/html;base64,PHNjcmlwdD4=", false},
+		{"file:///etc/passwd", false},
+	}
+
+	for _, tt := range tests {
+		if got := SafeURL(tt.url); got != tt.want {
+			t.Errorf("SafeURL(%q) = %t; want %t", tt.url, got, tt.want)
+		}
+	}
+}
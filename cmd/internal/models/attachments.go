@@ -0,0 +1,132 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Attachment holds data about a file uploaded with a Message.
+type Attachment struct {
+	ID          int
+	MessageID   int
+	ThreadID    int
+	UserID      int
+	StorageKey  string
+	Filename    string
+	ContentType string
+	Size        int64
+	DateAdded   time.Time
+}
+
+// IsImage reports whether the attachment can be previewed inline as an image.
+func (a *Attachment) IsImage() bool {
+	switch a.ContentType {
+	case "image/png", "image/jpeg", "image/gif", "image/webp":
+		return true
+	}
+	return false
+}
+
+// IsText reports whether the attachment is plain text.
+func (a *Attachment) IsText() bool {
+	return strings.HasPrefix(a.ContentType, "text/plain")
+}
+
+// AttachmentModel holds a database handle for manipulating attachments.
+type AttachmentModel struct {
+	DB *sql.DB
+}
+
+// insertAttachment saves a, attached to the given message, as part of tx and
+// sets its id.
+func insertAttachment(tx *sql.Tx, messageID int, a *Attachment) error {
+	stmt := `
+		INSERT INTO attachments (message_id, user_id, storage_key, filename, content_type, size, date_added)
+		VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`
+	result, err := tx.Exec(
+		stmt,
+		messageID, a.UserID, a.StorageKey, a.Filename, a.ContentType, a.Size,
+	)
+	if err != nil {
+		return fmt.Errorf("inserting new attachment in db: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("getting last attachment id: %w", err)
+	}
+	a.ID, a.MessageID = int(id), messageID
+	return nil
+}
+
+// Get retrieves the attachment with the given id, along with the id of the
+// thread its message belongs to.
+func (m *AttachmentModel) Get(id int) (*Attachment, error) {
+	stmt := `
+		SELECT a.id, a.message_id, m.thread_id, a.user_id, a.storage_key,
+		       a.filename, a.content_type, a.size, a.date_added
+		FROM attachments a, messages m
+		WHERE a.message_id = m.id AND a.id = ?
+	`
+	var a Attachment
+	err := m.DB.QueryRow(stmt, id).Scan(
+		&a.ID, &a.MessageID, &a.ThreadID, &a.UserID, &a.StorageKey,
+		&a.Filename, &a.ContentType, &a.Size, &a.DateAdded,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, fmt.Errorf("querying database: %w", err)
+	}
+	return &a, nil
+}
+
+// ForThread retrieves the attachments of every message in a thread, keyed by
+// message id, so a whole thread can be rendered with a single query.
+func (m *AttachmentModel) ForThread(threadID int) (map[int][]*Attachment, error) {
+	stmt := `
+		SELECT a.id, a.message_id, m.thread_id, a.user_id, a.storage_key,
+		       a.filename, a.content_type, a.size, a.date_added
+		FROM attachments a, messages m
+		WHERE a.message_id = m.id AND m.thread_id = ?
+		ORDER BY a.id
+	`
+	rows, err := m.DB.Query(stmt, threadID)
+	if err != nil {
+		return nil, fmt.Errorf("getting attachments: %w", err)
+	}
+	defer rows.Close()
+
+	attachments := map[int][]*Attachment{}
+	for rows.Next() {
+		var a Attachment
+		err := rows.Scan(
+			&a.ID, &a.MessageID, &a.ThreadID, &a.UserID, &a.StorageKey,
+			&a.Filename, &a.ContentType, &a.Size, &a.DateAdded,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("scanning attachment row: %w", err)
+		}
+		attachments[a.MessageID] = append(attachments[a.MessageID], &a)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over attachment rows: %w", err)
+	}
+
+	return attachments, nil
+}
+
+// UsedBytes returns the total size of the files uploaded by a user.
+func (m *AttachmentModel) UsedBytes(userID int) (int64, error) {
+	stmt := `SELECT COALESCE(SUM(size), 0) FROM attachments WHERE user_id = ?`
+	var used int64
+	err := m.DB.QueryRow(stmt, userID).Scan(&used)
+	if err != nil {
+		return 0, fmt.Errorf("summing attachment sizes: %w", err)
+	}
+	return used, nil
+}
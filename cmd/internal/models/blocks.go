@@ -0,0 +1,92 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// BlockModel holds a database handle for manipulating the users each user
+// has blocked. Users cannot start conversations with users they blocked or
+// who blocked them.
+type BlockModel struct {
+	DB *sql.DB
+}
+
+// Block records that a user blocks another one.
+func (m *BlockModel) Block(blockerID, blockedID int) error {
+	stmt := `
+		INSERT OR IGNORE INTO user_blocks (blocker_id, blocked_id, date_added)
+		VALUES (?, ?, CURRENT_TIMESTAMP)
+	`
+	_, err := m.DB.Exec(stmt, blockerID, blockedID)
+	if err != nil {
+		return fmt.Errorf("blocking user: %w", err)
+	}
+	return nil
+}
+
+// Unblock removes the block of a user on another one, if any.
+func (m *BlockModel) Unblock(blockerID, blockedID int) error {
+	stmt := `DELETE FROM user_blocks WHERE blocker_id = ? AND blocked_id = ?`
+	_, err := m.DB.Exec(stmt, blockerID, blockedID)
+	if err != nil {
+		return fmt.Errorf("unblocking user: %w", err)
+	}
+	return nil
+}
+
+// IsBlocked reports whether a user blocks another one.
+func (m *BlockModel) IsBlocked(blockerID, blockedID int) (bool, error) {
+	stmt := `SELECT EXISTS(SELECT 1 FROM user_blocks WHERE blocker_id = ? AND blocked_id = ?)`
+	var blocked bool
+	err := m.DB.QueryRow(stmt, blockerID, blockedID).Scan(&blocked)
+	if err != nil {
+		return false, fmt.Errorf("querying database: %w", err)
+	}
+	return blocked, nil
+}
+
+// Between returns the ids of the users among others who block the user with
+// the given id or are blocked by them.
+func (m *BlockModel) Between(userID int, others []int) ([]int, error) {
+	if len(others) == 0 {
+		return nil, nil
+	}
+	args := make([]any, 0, 2*len(others)+2)
+	args = append(args, userID)
+	for _, id := range others {
+		args = append(args, id)
+	}
+	args = append(args, userID)
+	args = append(args, args[1:len(others)+1]...)
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(others)), ", ")
+
+	stmt := fmt.Sprintf(
+		`
+			SELECT blocker_id FROM user_blocks WHERE blocked_id = ? AND blocker_id IN (%[1]s)
+			UNION
+			SELECT blocked_id FROM user_blocks WHERE blocker_id = ? AND blocked_id IN (%[1]s)
+		`,
+		placeholders,
+	)
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying database: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, fmt.Errorf("scanning block row: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over block rows: %w", err)
+	}
+	return ids, nil
+}
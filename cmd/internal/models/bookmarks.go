@@ -0,0 +1,169 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Bookmark holds data about a thread or message saved by a user, with an
+// optional private note. Message is nil for thread bookmarks.
+type Bookmark struct {
+	ID        int
+	Note      string
+	Thread    *Thread
+	Message   *Message
+	DateAdded time.Time
+}
+
+// BookmarkModel holds a database handle for manipulating bookmarks. Thread
+// bookmarks are stored with a message_id of 0, so that a user bookmarks each
+// thread and message at most once.
+type BookmarkModel struct {
+	DB *sql.DB
+}
+
+// Save bookmarks a thread, or one of its messages if messageID is not zero,
+// for a user. Saving an existing bookmark replaces its note.
+func (m *BookmarkModel) Save(userID, threadID, messageID int, note string) error {
+	stmt := `
+		INSERT INTO bookmarks (user_id, thread_id, message_id, note, date_added)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id, thread_id, message_id) DO UPDATE SET note = excluded.note
+	`
+	_, err := m.DB.Exec(stmt, userID, threadID, messageID, note)
+	if err != nil {
+		return fmt.Errorf("saving bookmark: %w", err)
+	}
+	return nil
+}
+
+// Remove deletes the bookmark of a user on a thread, or on one of its
+// messages if messageID is not zero.
+func (m *BookmarkModel) Remove(userID, threadID, messageID int) error {
+	stmt := `DELETE FROM bookmarks WHERE user_id = ? AND thread_id = ? AND message_id = ?`
+	_, err := m.DB.Exec(stmt, userID, threadID, messageID)
+	if err != nil {
+		return fmt.Errorf("removing bookmark: %w", err)
+	}
+	return nil
+}
+
+// ForThread retrieves with a single query the bookmarks of a user on a thread
+// and its messages, keyed by message id. The thread bookmark is under 0.
+func (m *BookmarkModel) ForThread(userID, threadID int) (map[int]*Bookmark, error) {
+	stmt := `
+		SELECT id, message_id, note, date_added FROM bookmarks
+		WHERE user_id = ? AND thread_id = ?
+	`
+	rows, err := m.DB.Query(stmt, userID, threadID)
+	if err != nil {
+		return nil, fmt.Errorf("querying bookmarks: %w", err)
+	}
+	defer rows.Close()
+
+	bookmarks := make(map[int]*Bookmark)
+	for rows.Next() {
+		var (
+			b         Bookmark
+			messageID int
+		)
+		err := rows.Scan(&b.ID, &messageID, &b.Note, &b.DateAdded)
+		if err != nil {
+			return nil, fmt.Errorf("scanning bookmark row: %w", err)
+		}
+		bookmarks[messageID] = &b
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over bookmark rows: %w", err)
+	}
+	return bookmarks, nil
+}
+
+// bookmarkSearch returns the SQL condition matching the bookmarks whose note,
+// thread title or message body contain query, along with its args.
+func bookmarkSearch(query string) (string, []any) {
+	if query == "" {
+		return "1", nil
+	}
+	pattern := "%" + escapeLike(query) + "%"
+	cond := `(
+		b.note LIKE ? ESCAPE '\'
+		OR t.title LIKE ? ESCAPE '\'
+		OR coalesce(msg.body, '') LIKE ? ESCAPE '\'
+	)`
+	return cond, []any{pattern, pattern, pattern}
+}
+
+// ForUser retrieves a page of the bookmarks of a user matching query, newest
+// first. An empty query matches every bookmark. Bookmarks of threads the user
+// can no longer read are left out.
+func (m *BookmarkModel) ForUser(userID int, query string, limit, offset int) ([]*Bookmark, error) {
+	visible, visibleArgs := visibleTo(userID)
+	match, matchArgs := bookmarkSearch(query)
+	stmt := `
+		SELECT b.id, b.note, b.date_added, t.id, t.title,
+		       coalesce(msg.id, 0), coalesce(msg.body, ''),
+		       coalesce(u.id, 0), coalesce(u.username, ''), coalesce(u.slug, '')
+		FROM bookmarks b
+		JOIN threads t ON t.id = b.thread_id
+		LEFT JOIN messages msg ON msg.id = b.message_id
+		LEFT JOIN users u ON u.id = msg.author_id
+		WHERE b.user_id = ? AND ` + match + ` AND ` + visible + `
+		ORDER BY b.date_added DESC, b.id DESC
+		LIMIT ? OFFSET ?
+	`
+	args := append([]any{userID}, matchArgs...)
+	args = append(args, visibleArgs...)
+	rows, err := m.DB.Query(stmt, append(args, limit, offset)...)
+	if err != nil {
+		return nil, fmt.Errorf("querying bookmarks: %w", err)
+	}
+	defer rows.Close()
+
+	var bookmarks []*Bookmark
+	for rows.Next() {
+		var (
+			b   Bookmark
+			t   Thread
+			msg Message
+		)
+		err := rows.Scan(
+			&b.ID, &b.Note, &b.DateAdded, &t.ID, &t.Title,
+			&msg.ID, &msg.Body,
+			&msg.Author.ID, &msg.Author.Username, &msg.Author.Slug,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("scanning bookmark row: %w", err)
+		}
+		b.Thread = &t
+		if msg.ID != 0 {
+			msg.ThreadID, msg.ThreadTitle = t.ID, t.Title
+			b.Message = &msg
+		}
+		bookmarks = append(bookmarks, &b)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over bookmark rows: %w", err)
+	}
+	return bookmarks, nil
+}
+
+// CountForUser returns the number of bookmarks ForUser pages through.
+func (m *BookmarkModel) CountForUser(userID int, query string) (int, error) {
+	visible, visibleArgs := visibleTo(userID)
+	match, matchArgs := bookmarkSearch(query)
+	stmt := `
+		SELECT count(*)
+		FROM bookmarks b
+		JOIN threads t ON t.id = b.thread_id
+		LEFT JOIN messages msg ON msg.id = b.message_id
+		WHERE b.user_id = ? AND ` + match + ` AND ` + visible
+	args := append([]any{userID}, matchArgs...)
+	var n int
+	err := m.DB.QueryRow(stmt, append(args, visibleArgs...)...).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("counting bookmarks: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,276 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Category holds data about a category threads are organized in. Categories
+// nest one level deep: a top level category may have sub-categories, which
+// cannot have their own.
+type Category struct {
+	ID          int
+	Name        string
+	Slug        string
+	Description string
+	Position    int
+	ParentID    int // zero for top level categories
+
+	// The fields below are only set by Overview.
+	Children       []*Category
+	ThreadCount    int
+	MessageCount   int
+	LatestThread   *Thread
+	LatestActivity time.Time
+}
+
+// CategoryModel holds a database handle for manipulating categories.
+type CategoryModel struct {
+	DB *sql.DB
+}
+
+// Insert inserts a new category in the database and returns its id.
+func (m *CategoryModel) Insert(c *Category) (int, error) {
+	stmt := `
+		INSERT INTO categories (name, slug, description, position, parent_id, date_added)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`
+	result, err := m.DB.Exec(stmt, c.Name, c.Slug, c.Description, c.Position, nullID(c.ParentID))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, ErrDuplicateSlug
+		}
+		return 0, fmt.Errorf("inserting category in db: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("getting last category id: %w", err)
+	}
+	return int(id), nil
+}
+
+// Update saves the fields of an existing category.
+func (m *CategoryModel) Update(c *Category) error {
+	stmt := `
+		UPDATE categories
+		SET name = ?, slug = ?, description = ?, position = ?, parent_id = ?
+		WHERE id = ?
+	`
+	result, err := m.DB.Exec(stmt, c.Name, c.Slug, c.Description, c.Position, nullID(c.ParentID), c.ID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrDuplicateSlug
+		}
+		return fmt.Errorf("updating category: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("getting affected rows: %w", err)
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// Delete deletes a category. Categories that still hold threads or
+// sub-categories cannot be deleted.
+func (m *CategoryModel) Delete(id int) error {
+	stmt := `
+		DELETE FROM categories
+		WHERE id = ?
+		  AND NOT EXISTS (SELECT 1 FROM threads WHERE category_id = categories.id)
+		  AND NOT EXISTS (SELECT 1 FROM categories c WHERE c.parent_id = categories.id)
+	`
+	result, err := m.DB.Exec(stmt, id)
+	if err != nil {
+		return fmt.Errorf("deleting category: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("getting affected rows: %w", err)
+	}
+	if n == 0 {
+		_, err := m.Get(id)
+		if err != nil {
+			return err
+		}
+		return ErrCategoryInUse
+	}
+	return nil
+}
+
+// Get retrieves the category with the given id.
+func (m *CategoryModel) Get(id int) (*Category, error) {
+	stmt := `
+		SELECT id, name, slug, description, position, coalesce(parent_id, 0)
+		FROM categories
+		WHERE id = ?
+	`
+	return m.getCategory(stmt, id)
+}
+
+// GetBySlug retrieves the category with the given slug.
+func (m *CategoryModel) GetBySlug(slug string) (*Category, error) {
+	stmt := `
+		SELECT id, name, slug, description, position, coalesce(parent_id, 0)
+		FROM categories
+		WHERE slug = ?
+	`
+	return m.getCategory(stmt, slug)
+}
+
+// getCategory runs a query selecting a single category and scans the result.
+func (m *CategoryModel) getCategory(stmt string, args ...any) (*Category, error) {
+	var c Category
+	err := m.DB.QueryRow(stmt, args...).Scan(
+		&c.ID, &c.Name, &c.Slug, &c.Description, &c.Position, &c.ParentID,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, fmt.Errorf("querying database: %w", err)
+	}
+	return &c, nil
+}
+
+// All retrieves every category in display order: each top level category is
+// followed by its sub-categories.
+func (m *CategoryModel) All() ([]*Category, error) {
+	stmt := `
+		SELECT c.id, c.name, c.slug, c.description, c.position, coalesce(c.parent_id, 0)
+		FROM categories c
+		LEFT JOIN categories p ON p.id = c.parent_id
+		ORDER BY coalesce(p.position, c.position), coalesce(p.name, c.name),
+		         c.parent_id IS NOT NULL, c.position, c.name
+	`
+	return m.listCategories(stmt)
+}
+
+// Children retrieves the sub-categories of a category.
+func (m *CategoryModel) Children(parentID int) ([]*Category, error) {
+	stmt := `
+		SELECT id, name, slug, description, position, coalesce(parent_id, 0)
+		FROM categories
+		WHERE parent_id = ?
+		ORDER BY position, name
+	`
+	return m.listCategories(stmt, parentID)
+}
+
+// listCategories runs a query selecting categories and scans the results.
+func (m *CategoryModel) listCategories(stmt string, args ...any) ([]*Category, error) {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("getting categories: %w", err)
+	}
+	defer rows.Close()
+
+	var categories []*Category
+	for rows.Next() {
+		var c Category
+		err := rows.Scan(&c.ID, &c.Name, &c.Slug, &c.Description, &c.Position, &c.ParentID)
+		if err != nil {
+			return nil, fmt.Errorf("scanning category row: %w", err)
+		}
+		categories = append(categories, &c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over category rows: %w", err)
+	}
+
+	return categories, nil
+}
+
+// Overview retrieves the top level categories with their sub-categories,
+// along with the thread and message counts and latest activity of each.
+// Only the threads the user with the given id can read are taken into
+// account.
+func (m *CategoryModel) Overview(userID int) ([]*Category, error) {
+	visible, visibleArgs := visibleTo(userID)
+	stmt := fmt.Sprintf(
+		`
+			SELECT c.id, c.name, c.slug, c.description, c.position, coalesce(c.parent_id, 0),
+			       (SELECT count(*) FROM threads t WHERE t.category_id = c.id AND %[1]s),
+			       (
+			           SELECT count(*) FROM messages m JOIN threads t ON t.id = m.thread_id
+			           WHERE t.category_id = c.id AND %[1]s
+			       ),
+			       coalesce(lt.id, 0), coalesce(lt.title, ''), lt.date_added, lm.date_added
+			FROM categories c
+			LEFT JOIN categories p ON p.id = c.parent_id
+			LEFT JOIN threads lt ON lt.id = (
+			    SELECT t.id FROM threads t
+			    WHERE t.category_id = c.id AND %[1]s
+			    ORDER BY coalesce((SELECT max(date_added) FROM messages WHERE thread_id = t.id), t.date_added) DESC
+			    LIMIT 1
+			)
+			LEFT JOIN messages lm ON lm.id = (
+			    SELECT id FROM messages WHERE thread_id = lt.id
+			    ORDER BY date_added DESC, id DESC LIMIT 1
+			)
+			ORDER BY coalesce(p.position, c.position), coalesce(p.name, c.name),
+			         c.parent_id IS NOT NULL, c.position, c.name
+		`,
+		visible,
+	)
+	var args []any
+	for range 3 {
+		args = append(args, visibleArgs...)
+	}
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("getting category overview: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		top      []*Category
+		children []*Category
+		byID     = make(map[int]*Category)
+	)
+	for rows.Next() {
+		var (
+			c           Category
+			t           Thread
+			threadDate  sql.NullTime
+			messageDate sql.NullTime
+		)
+		err := rows.Scan(
+			&c.ID, &c.Name, &c.Slug, &c.Description, &c.Position, &c.ParentID,
+			&c.ThreadCount, &c.MessageCount,
+			&t.ID, &t.Title, &threadDate, &messageDate,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("scanning category row: %w", err)
+		}
+		if t.ID != 0 {
+			t.DateAdded = threadDate.Time
+			c.LatestThread = &t
+			c.LatestActivity = threadDate.Time
+			if messageDate.Valid && messageDate.Time.After(c.LatestActivity) {
+				c.LatestActivity = messageDate.Time
+			}
+		}
+
+		byID[c.ID] = &c
+		if c.ParentID == 0 {
+			top = append(top, &c)
+		} else {
+			children = append(children, &c)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over category rows: %w", err)
+	}
+
+	for _, c := range children {
+		if parent, ok := byID[c.ParentID]; ok {
+			parent.Children = append(parent.Children, c)
+		}
+	}
+	return top, nil
+}
@@ -0,0 +1,343 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+)
+
+// Conversation holds data about a private conversation between a few users.
+type Conversation struct {
+	ID           int
+	Subject      string
+	Participants []*User
+	Messages     []*PrivateMessage
+	DateAdded    time.Time
+	DateUpdated  time.Time // date of the latest message
+
+	// The fields below are relative to the user the conversation was
+	// loaded for. LatestMessage and Unread are only set by Inbox.
+	LastReadID    int // id of the last message the user has read
+	LatestMessage *PrivateMessage
+	Unread        int // number of messages by others the user has not read
+}
+
+// PrivateMessage holds data about a single message in a Conversation. Body
+// holds the Markdown source; BodyHTML is filled in by the web layer.
+type PrivateMessage struct {
+	ID             int
+	ConversationID int
+	Author         User
+	Body           string
+	BodyHTML       template.HTML
+	DateAdded      time.Time
+}
+
+// ConversationModel holds a database handle for manipulating private
+// conversations. Only participants can read or reply to a conversation.
+type ConversationModel struct {
+	DB *sql.DB
+}
+
+// Insert starts a conversation between its author and the users with the
+// given ids, with body as its first message, and returns its id.
+func (m *ConversationModel) Insert(subject string, authorID int, participantIDs []int, body string) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt := `
+		INSERT INTO conversations (subject, author_id, date_added, date_updated)
+		VALUES (?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	`
+	result, err := tx.Exec(stmt, subject, authorID)
+	if err != nil {
+		return 0, fmt.Errorf("inserting conversation: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("getting last insert id: %w", err)
+	}
+
+	stmt = `
+		INSERT OR IGNORE INTO conversation_participants (conversation_id, user_id, last_read_message_id)
+		VALUES (?, ?, 0)
+	`
+	for _, userID := range append([]int{authorID}, participantIDs...) {
+		_, err = tx.Exec(stmt, id, userID)
+		if err != nil {
+			return 0, fmt.Errorf("inserting participant: %w", err)
+		}
+	}
+
+	_, err = insertPrivateMessage(tx, int(id), authorID, body)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("committing transaction: %w", err)
+	}
+	return int(id), nil
+}
+
+// Reply adds a message to a conversation and returns its id.
+func (m *ConversationModel) Reply(conversationID, authorID int, body string) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	id, err := insertPrivateMessage(tx, conversationID, authorID, body)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("committing transaction: %w", err)
+	}
+	return id, nil
+}
+
+// insertPrivateMessage adds a message to a conversation, bumps its update
+// date and marks it as read by its author.
+func insertPrivateMessage(tx *sql.Tx, conversationID, authorID int, body string) (int, error) {
+	stmt := `
+		INSERT INTO private_messages (conversation_id, author_id, body, date_added)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	`
+	result, err := tx.Exec(stmt, conversationID, authorID, body)
+	if err != nil {
+		return 0, fmt.Errorf("inserting private message: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("getting last insert id: %w", err)
+	}
+
+	stmt = `UPDATE conversations SET date_updated = CURRENT_TIMESTAMP WHERE id = ?`
+	_, err = tx.Exec(stmt, conversationID)
+	if err != nil {
+		return 0, fmt.Errorf("updating conversation: %w", err)
+	}
+
+	stmt = `
+		UPDATE conversation_participants SET last_read_message_id = ?
+		WHERE conversation_id = ? AND user_id = ?
+	`
+	_, err = tx.Exec(stmt, id, conversationID, authorID)
+	if err != nil {
+		return 0, fmt.Errorf("marking private message as read: %w", err)
+	}
+	return int(id), nil
+}
+
+// Get retrieves a conversation with its participants and messages, oldest
+// first. It returns ErrNoRecord if the user with the given id does not
+// participate in it, so that its existence is not disclosed.
+func (m *ConversationModel) Get(id, userID int) (*Conversation, error) {
+	stmt := `
+		SELECT c.id, c.subject, c.date_added, c.date_updated, p.last_read_message_id
+		FROM conversations c
+		JOIN conversation_participants p ON p.conversation_id = c.id AND p.user_id = ?
+		WHERE c.id = ?
+	`
+	var c Conversation
+	err := m.DB.QueryRow(stmt, userID, id).Scan(&c.ID, &c.Subject, &c.DateAdded, &c.DateUpdated, &c.LastReadID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, fmt.Errorf("querying database: %w", err)
+	}
+
+	err = m.loadParticipants([]*Conversation{&c})
+	if err != nil {
+		return nil, err
+	}
+
+	stmt = `
+		SELECT pm.id, pm.body, pm.date_added, u.id, u.username, u.slug, u.email, u.avatar_version
+		FROM private_messages pm, users u
+		WHERE pm.author_id = u.id AND pm.conversation_id = ?
+		ORDER BY pm.id
+	`
+	rows, err := m.DB.Query(stmt, c.ID)
+	if err != nil {
+		return nil, fmt.Errorf("getting private messages: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		pm := PrivateMessage{ConversationID: c.ID}
+		err := rows.Scan(
+			&pm.ID, &pm.Body, &pm.DateAdded,
+			&pm.Author.ID, &pm.Author.Username, &pm.Author.Slug, &pm.Author.Email,
+			&pm.Author.AvatarVersion,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("scanning private message row: %w", err)
+		}
+		c.Messages = append(c.Messages, &pm)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over private message rows: %w", err)
+	}
+
+	return &c, nil
+}
+
+// Inbox retrieves a page of the conversations of a user, most recently
+// updated first, along with their latest message and unread count.
+func (m *ConversationModel) Inbox(userID, limit, offset int) ([]*Conversation, error) {
+	stmt := `
+		SELECT c.id, c.subject, c.date_added, c.date_updated, p.last_read_message_id,
+		       lm.id, lm.body, lm.date_added, lu.id, lu.username, lu.slug, lu.email,
+		       (
+		           SELECT count(*) FROM private_messages um
+		           WHERE um.conversation_id = c.id AND um.author_id != p.user_id
+		             AND um.id > p.last_read_message_id
+		       )
+		FROM conversations c
+		JOIN conversation_participants p ON p.conversation_id = c.id AND p.user_id = ?
+		JOIN private_messages lm ON lm.id = (
+		    SELECT max(id) FROM private_messages WHERE conversation_id = c.id
+		)
+		JOIN users lu ON lu.id = lm.author_id
+		ORDER BY c.date_updated DESC, c.id DESC
+		LIMIT ? OFFSET ?
+	`
+	rows, err := m.DB.Query(stmt, userID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("getting conversations: %w", err)
+	}
+	defer rows.Close()
+
+	var conversations []*Conversation
+	for rows.Next() {
+		var (
+			c  Conversation
+			lm PrivateMessage
+		)
+		err := rows.Scan(
+			&c.ID, &c.Subject, &c.DateAdded, &c.DateUpdated, &c.LastReadID,
+			&lm.ID, &lm.Body, &lm.DateAdded,
+			&lm.Author.ID, &lm.Author.Username, &lm.Author.Slug, &lm.Author.Email,
+			&c.Unread,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("scanning conversation row: %w", err)
+		}
+		lm.ConversationID = c.ID
+		c.LatestMessage = &lm
+		conversations = append(conversations, &c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over conversation rows: %w", err)
+	}
+
+	err = m.loadParticipants(conversations)
+	if err != nil {
+		return nil, err
+	}
+	return conversations, nil
+}
+
+// CountInbox returns the number of conversations Inbox pages through.
+func (m *ConversationModel) CountInbox(userID int) (int, error) {
+	stmt := `SELECT count(*) FROM conversation_participants WHERE user_id = ?`
+	var n int
+	err := m.DB.QueryRow(stmt, userID).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("counting conversations: %w", err)
+	}
+	return n, nil
+}
+
+// CountUnread returns the number of conversations of a user with messages
+// by others the user has not read.
+func (m *ConversationModel) CountUnread(userID int) (int, error) {
+	stmt := `
+		SELECT count(*) FROM conversation_participants p
+		WHERE p.user_id = ? AND EXISTS (
+		    SELECT 1 FROM private_messages um
+		    WHERE um.conversation_id = p.conversation_id AND um.author_id != p.user_id
+		      AND um.id > p.last_read_message_id
+		)
+	`
+	var n int
+	err := m.DB.QueryRow(stmt, userID).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("counting unread conversations: %w", err)
+	}
+	return n, nil
+}
+
+// MarkRead records that a user has read a conversation up to the given
+// message. The marker never moves backwards.
+func (m *ConversationModel) MarkRead(conversationID, userID, messageID int) error {
+	stmt := `
+		UPDATE conversation_participants
+		SET last_read_message_id = max(last_read_message_id, ?)
+		WHERE conversation_id = ? AND user_id = ?
+	`
+	_, err := m.DB.Exec(stmt, messageID, conversationID, userID)
+	if err != nil {
+		return fmt.Errorf("marking conversation as read: %w", err)
+	}
+	return nil
+}
+
+// loadParticipants fills in the participants of conversations with a single
+// query.
+func (m *ConversationModel) loadParticipants(conversations []*Conversation) error {
+	if len(conversations) == 0 {
+		return nil
+	}
+	byID := make(map[int]*Conversation, len(conversations))
+	args := make([]any, 0, len(conversations))
+	for _, c := range conversations {
+		byID[c.ID] = c
+		args = append(args, c.ID)
+	}
+
+	stmt := fmt.Sprintf(
+		`
+			SELECT p.conversation_id, u.id, u.username, u.slug, u.email, u.avatar_version
+			FROM conversation_participants p, users u
+			WHERE p.user_id = u.id AND p.conversation_id IN (%s)
+			ORDER BY u.username
+		`,
+		strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", "),
+	)
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return fmt.Errorf("getting participants: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			conversationID int
+			u              User
+		)
+		err := rows.Scan(&conversationID, &u.ID, &u.Username, &u.Slug, &u.Email, &u.AvatarVersion)
+		if err != nil {
+			return fmt.Errorf("scanning participant row: %w", err)
+		}
+		c := byID[conversationID]
+		c.Participants = append(c.Participants, &u)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("iterating over participant rows: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Draft holds the text of a thread or message a user has not posted yet.
+// ThreadID is zero for the draft of a new thread, which is the only kind
+// with a Title.
+type Draft struct {
+	ThreadID    int
+	Title       string
+	Body        string
+	DateUpdated time.Time
+}
+
+// DraftModel holds a database handle for manipulating drafts. A user has at
+// most one draft per thread, plus one for a new thread.
+type DraftModel struct {
+	DB *sql.DB
+}
+
+// Save stores the draft of a user for the given thread, replacing the
+// previous one.
+func (m *DraftModel) Save(userID, threadID int, title, body string) error {
+	stmt := `
+		INSERT INTO drafts (user_id, thread_id, title, body, date_updated)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id, thread_id) DO UPDATE
+		SET title = excluded.title, body = excluded.body, date_updated = excluded.date_updated
+	`
+	_, err := m.DB.Exec(stmt, userID, threadID, title, body)
+	if err != nil {
+		return fmt.Errorf("saving draft: %w", err)
+	}
+	return nil
+}
+
+// Get retrieves the draft of a user for the given thread. It returns
+// ErrNoRecord if there is none.
+func (m *DraftModel) Get(userID, threadID int) (*Draft, error) {
+	stmt := `
+		SELECT thread_id, title, body, date_updated FROM drafts
+		WHERE user_id = ? AND thread_id = ?
+	`
+	var d Draft
+	err := m.DB.QueryRow(stmt, userID, threadID).Scan(&d.ThreadID, &d.Title, &d.Body, &d.DateUpdated)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, fmt.Errorf("querying draft: %w", err)
+	}
+	return &d, nil
+}
+
+// Delete deletes the draft of a user for the given thread, if any.
+func (m *DraftModel) Delete(userID, threadID int) error {
+	_, err := m.DB.Exec(`DELETE FROM drafts WHERE user_id = ? AND thread_id = ?`, userID, threadID)
+	if err != nil {
+		return fmt.Errorf("deleting draft: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpired deletes the drafts last saved before the given time and
+// returns how many were deleted.
+func (m *DraftModel) DeleteExpired(before time.Time) (int64, error) {
+	result, err := m.DB.Exec(`DELETE FROM drafts WHERE date_updated < ?`, sqlTime(before))
+	if err != nil {
+		return 0, fmt.Errorf("deleting expired drafts: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("getting affected rows: %w", err)
+	}
+	return n, nil
+}
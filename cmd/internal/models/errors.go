@@ -6,4 +6,10 @@ var (
 	ErrNoRecord           = errors.New("models: no matching record found")
 	ErrInvalidCredentials = errors.New("models: invalid credentials")
 	ErrDuplicateEmail     = errors.New("models: duplicate email")
+	ErrDuplicateSlug      = errors.New("models: duplicate slug")
+	ErrCategoryInUse      = errors.New("models: category still has threads or sub-categories")
+	ErrGroupInUse         = errors.New("models: group still has threads")
+	ErrAlreadyVoted       = errors.New("models: user already voted in the poll")
+	ErrPollClosed         = errors.New("models: poll is closed")
+	ErrQuotaExceeded      = errors.New("models: upload quota exceeded")
 )
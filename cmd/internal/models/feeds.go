@@ -0,0 +1,82 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// FeedModel holds a database handle for loading the messages syndicated in
+// feeds. Feed readers are anonymous, so feeds only ever contain messages of
+// threads anonymous users can read.
+type FeedModel struct {
+	DB *sql.DB
+}
+
+// Threads retrieves the opening posts of the latest threads.
+func (m *FeedModel) Threads(limit int) ([]*Message, error) {
+	return m.messages("m.is_opening", limit)
+}
+
+// Thread retrieves the latest messages of a thread, including its opening
+// post.
+func (m *FeedModel) Thread(threadID, limit int) ([]*Message, error) {
+	return m.messages("m.thread_id = ?", limit, threadID)
+}
+
+// Category retrieves the opening posts of the latest threads of a category.
+func (m *FeedModel) Category(categoryID, limit int) ([]*Message, error) {
+	return m.messages("m.is_opening AND t.category_id = ?", limit, categoryID)
+}
+
+// Tag retrieves the opening posts of the latest threads with a tag.
+func (m *FeedModel) Tag(tagID, limit int) ([]*Message, error) {
+	filter := "m.is_opening AND t.id IN (SELECT thread_id FROM thread_tags WHERE tag_id = ?)"
+	return m.messages(filter, limit, tagID)
+}
+
+// User retrieves the latest messages posted by a user, opening posts
+// included.
+func (m *FeedModel) User(userID, limit int) ([]*Message, error) {
+	return m.messages("m.author_id = ?", limit, userID)
+}
+
+// messages retrieves the latest messages matching filter, a SQL condition on
+// the messages table m and threads table t using args, newest first, along
+// with their authors and the titles of their threads.
+func (m *FeedModel) messages(filter string, limit int, args ...any) ([]*Message, error) {
+	visible, visibleArgs := visibleTo(0)
+	stmt := `
+		SELECT m.id, m.body, m.revision, m.is_opening, m.date_added,
+		       t.id, t.title, u.id, u.username, u.slug
+		FROM messages m
+		JOIN threads t ON t.id = m.thread_id
+		JOIN users u ON u.id = m.author_id
+		WHERE ` + filter + ` AND ` + visible + `
+		ORDER BY m.date_added DESC, m.id DESC
+		LIMIT ?
+	`
+	args = append(args, visibleArgs...)
+	rows, err := m.DB.Query(stmt, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("querying feed messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []*Message
+	for rows.Next() {
+		var msg Message
+		err := rows.Scan(
+			&msg.ID, &msg.Body, &msg.Revision, &msg.IsOpening, &msg.DateAdded,
+			&msg.ThreadID, &msg.ThreadTitle,
+			&msg.Author.ID, &msg.Author.Username, &msg.Author.Slug,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("scanning feed message row: %w", err)
+		}
+		messages = append(messages, &msg)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over feed message rows: %w", err)
+	}
+	return messages, nil
+}
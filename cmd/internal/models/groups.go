@@ -0,0 +1,196 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Group holds data about a group of users. Threads can be restricted to the
+// members of a group.
+type Group struct {
+	ID          int
+	Name        string
+	Slug        string
+	Description string
+	MemberCount int // only set by All
+}
+
+// GroupModel holds a database handle for manipulating groups and their
+// members.
+type GroupModel struct {
+	DB *sql.DB
+}
+
+// Insert inserts a new group in the database and returns its id.
+func (m *GroupModel) Insert(name, description string) (int, error) {
+	stmt := `
+		INSERT INTO groups (name, slug, description, date_added)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	`
+	result, err := m.DB.Exec(stmt, name, Slugify(name), description)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, ErrDuplicateSlug
+		}
+		return 0, fmt.Errorf("inserting group in db: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("getting last group id: %w", err)
+	}
+	return int(id), nil
+}
+
+// Delete deletes a group along with its memberships. Groups that threads are
+// still restricted to cannot be deleted.
+func (m *GroupModel) Delete(id int) error {
+	_, err := m.Get(id)
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var inUse bool
+	stmt := `SELECT EXISTS(SELECT 1 FROM threads WHERE group_id = ?)`
+	err = tx.QueryRow(stmt, id).Scan(&inUse)
+	if err != nil {
+		return fmt.Errorf("querying database: %w", err)
+	}
+	if inUse {
+		return ErrGroupInUse
+	}
+
+	_, err = tx.Exec(`DELETE FROM group_members WHERE group_id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("deleting group members: %w", err)
+	}
+	_, err = tx.Exec(`DELETE FROM groups WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("deleting group: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+	return nil
+}
+
+// Get retrieves the group with the given id.
+func (m *GroupModel) Get(id int) (*Group, error) {
+	stmt := `SELECT id, name, slug, description FROM groups WHERE id = ?`
+	var g Group
+	err := m.DB.QueryRow(stmt, id).Scan(&g.ID, &g.Name, &g.Slug, &g.Description)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, fmt.Errorf("querying database: %w", err)
+	}
+	return &g, nil
+}
+
+// All retrieves every group by name, along with their number of members.
+func (m *GroupModel) All() ([]*Group, error) {
+	stmt := `
+		SELECT g.id, g.name, g.slug, g.description,
+		       (SELECT count(*) FROM group_members WHERE group_id = g.id)
+		FROM groups g
+		ORDER BY g.name
+	`
+	return m.listGroups(stmt)
+}
+
+// ForUser retrieves the groups a user is a member of, by name.
+func (m *GroupModel) ForUser(userID int) ([]*Group, error) {
+	stmt := `
+		SELECT g.id, g.name, g.slug, g.description, 0
+		FROM groups g, group_members gm
+		WHERE gm.group_id = g.id AND gm.user_id = ?
+		ORDER BY g.name
+	`
+	return m.listGroups(stmt, userID)
+}
+
+// listGroups runs a query selecting groups and scans the results.
+func (m *GroupModel) listGroups(stmt string, args ...any) ([]*Group, error) {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("getting groups: %w", err)
+	}
+	defer rows.Close()
+
+	var groups []*Group
+	for rows.Next() {
+		var g Group
+		err := rows.Scan(&g.ID, &g.Name, &g.Slug, &g.Description, &g.MemberCount)
+		if err != nil {
+			return nil, fmt.Errorf("scanning group row: %w", err)
+		}
+		groups = append(groups, &g)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over group rows: %w", err)
+	}
+
+	return groups, nil
+}
+
+// Members retrieves the members of a group, by username.
+func (m *GroupModel) Members(groupID int) ([]*User, error) {
+	stmt := `
+		SELECT u.id, u.username, u.slug, u.email, u.avatar_version
+		FROM users u, group_members gm
+		WHERE gm.user_id = u.id AND gm.group_id = ?
+		ORDER BY u.username
+	`
+	rows, err := m.DB.Query(stmt, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("getting group members: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		var u User
+		err := rows.Scan(&u.ID, &u.Username, &u.Slug, &u.Email, &u.AvatarVersion)
+		if err != nil {
+			return nil, fmt.Errorf("scanning user row: %w", err)
+		}
+		users = append(users, &u)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over user rows: %w", err)
+	}
+
+	return users, nil
+}
+
+// AddMember adds a user to a group.
+func (m *GroupModel) AddMember(groupID, userID int) error {
+	stmt := `
+		INSERT OR IGNORE INTO group_members (group_id, user_id, date_added)
+		VALUES (?, ?, CURRENT_TIMESTAMP)
+	`
+	_, err := m.DB.Exec(stmt, groupID, userID)
+	if err != nil {
+		return fmt.Errorf("adding group member: %w", err)
+	}
+	return nil
+}
+
+// RemoveMember removes a user from a group.
+func (m *GroupModel) RemoveMember(groupID, userID int) error {
+	stmt := `DELETE FROM group_members WHERE group_id = ? AND user_id = ?`
+	_, err := m.DB.Exec(stmt, groupID, userID)
+	if err != nil {
+		return fmt.Errorf("removing group member: %w", err)
+	}
+	return nil
+}
@@ -2,6 +2,11 @@ package models
 
 import (
 	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
 )
 
 // NewModels creates all models necessary for the application.
@@ -20,3 +25,27 @@ func NewModels(db *sql.DB) (*ThreadModel, *UserModel, *MessageModel, error) {
 	}
 	return threadModel, userModel, postModel, nil
 }
+
+// sqlTime formats t like SQLite's CURRENT_TIMESTAMP, so that it compares
+// correctly with the timestamps stored by the database.
+func sqlTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+// nullID converts an optional foreign key, zero meaning none, to a value
+// that is stored as NULL when missing.
+func nullID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+// isUniqueViolation reports whether err is caused by a UNIQUE constraint.
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// escapeLike escapes the wildcards of s for a LIKE pattern using \ as its
+// ESCAPE character.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,154 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Mention holds data about a user being @mentioned in a Message.
+type Mention struct {
+	ID          int
+	Name        string
+	UserID      int
+	MessageID   int
+	ThreadID    int
+	ThreadTitle string
+	Author      User
+	Body        string
+	IsRead      bool
+	DateAdded   time.Time
+}
+
+// MentionModel holds a database handle for manipulating mentions.
+type MentionModel struct {
+	DB *sql.DB
+}
+
+// Insert records that the user with the given id was mentioned as name in a
+// message. Mentioning the same user twice in a message is recorded once.
+func (m *MentionModel) Insert(messageID, userID int, name string) error {
+	stmt := `
+		INSERT OR IGNORE INTO mentions (message_id, user_id, name, is_read, date_added)
+		VALUES (?, ?, ?, FALSE, CURRENT_TIMESTAMP)
+	`
+	_, err := m.DB.Exec(stmt, messageID, userID, strings.ToLower(name))
+	if err != nil {
+		return fmt.Errorf("inserting new mention in db: %w", err)
+	}
+	return nil
+}
+
+// ForThread retrieves the resolved mentions of every message in a thread. The
+// result maps message ids to the lower-cased mentioned names and the profile
+// slugs they resolved to.
+func (m *MentionModel) ForThread(threadID int) (map[int]map[string]string, error) {
+	stmt := `
+		SELECT mn.message_id, mn.name, u.slug
+		FROM mentions mn, messages m, users u
+		WHERE mn.message_id = m.id AND mn.user_id = u.id AND m.thread_id = ?
+	`
+	rows, err := m.DB.Query(stmt, threadID)
+	if err != nil {
+		return nil, fmt.Errorf("getting mentions: %w", err)
+	}
+	defer rows.Close()
+
+	mentions := map[int]map[string]string{}
+	for rows.Next() {
+		var (
+			messageID  int
+			name, slug string
+		)
+		err := rows.Scan(&messageID, &name, &slug)
+		if err != nil {
+			return nil, fmt.Errorf("scanning mention row: %w", err)
+		}
+		if mentions[messageID] == nil {
+			mentions[messageID] = map[string]string{}
+		}
+		mentions[messageID][name] = slug
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over mention rows: %w", err)
+	}
+
+	return mentions, nil
+}
+
+// ForUser retrieves the latest mentions of a user, newest first. Mentions in
+// threads the user can no longer read are left out.
+func (m *MentionModel) ForUser(userID, limit int) ([]*Mention, error) {
+	visible, args := visibleTo(userID)
+	stmt := `
+		SELECT mn.id, mn.name, mn.user_id, mn.message_id, mn.is_read, mn.date_added,
+		       t.id, t.title, m.body, u.id, u.username, u.slug, u.email
+		FROM mentions mn, messages m, threads t, users u
+		WHERE mn.message_id = m.id AND m.thread_id = t.id AND m.author_id = u.id
+		  AND mn.user_id = ? AND ` + visible + `
+		ORDER BY mn.date_added DESC, mn.id DESC
+		LIMIT ?
+	`
+	args = append([]any{userID}, args...)
+	rows, err := m.DB.Query(stmt, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("getting mentions of user: %w", err)
+	}
+	defer rows.Close()
+
+	var mentions []*Mention
+	for rows.Next() {
+		var mn Mention
+		err := rows.Scan(
+			&mn.ID, &mn.Name, &mn.UserID, &mn.MessageID, &mn.IsRead, &mn.DateAdded,
+			&mn.ThreadID, &mn.ThreadTitle, &mn.Body,
+			&mn.Author.ID, &mn.Author.Username, &mn.Author.Slug, &mn.Author.Email,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("scanning mention row: %w", err)
+		}
+		mentions = append(mentions, &mn)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over mention rows: %w", err)
+	}
+
+	return mentions, nil
+}
+
+// CountUnread returns the number of unread mentions of a user in threads
+// they can read.
+func (m *MentionModel) CountUnread(userID int) (int, error) {
+	visible, args := visibleTo(userID)
+	stmt := `
+		SELECT COUNT(*) FROM mentions mn, messages m, threads t
+		WHERE mn.message_id = m.id AND m.thread_id = t.id
+		  AND mn.user_id = ? AND NOT mn.is_read AND ` + visible
+	var n int
+	err := m.DB.QueryRow(stmt, append([]any{userID}, args...)...).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("counting unread mentions: %w", err)
+	}
+	return n, nil
+}
+
+// MarkRead marks the mentions of a user with the given ids as read.
+func (m *MentionModel) MarkRead(userID int, ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := []any{userID}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	stmt := fmt.Sprintf(
+		`UPDATE mentions SET is_read = TRUE WHERE user_id = ? AND NOT is_read AND id IN (%s)`,
+		strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", "),
+	)
+	_, err := m.DB.Exec(stmt, args...)
+	if err != nil {
+		return fmt.Errorf("marking mentions as read: %w", err)
+	}
+	return nil
+}
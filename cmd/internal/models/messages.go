@@ -2,16 +2,52 @@ package models
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"html/template"
 	"time"
 )
 
-// Message holds data about a single message in a Thread.
+// Message holds data about a single message in a Thread. Body holds the
+// Markdown source; BodyHTML is filled in by the web layer with the sanitized
+// rendering of the current Revision.
 type Message struct {
-	ID      int
-	Body    string
-	Author  User
-	DateAdded time.Time
+	ID          int
+	Body        string
+	BodyHTML    template.HTML
+	Revision    int
+	IsOpening   bool // the message was posted with the thread
+	ReplyToID   int  // zero if the message does not reply to another one
+	Score       int
+	Author      User
+	ThreadID    int
+	ThreadTitle string
+	DateAdded   time.Time
+	Attachments []*Attachment
+
+	// Reactions holds the counts of the reactions to the message, in the
+	// order of the forum's emoji set. It is set by the web layer.
+	Reactions []*Reaction
+
+	// IsUnread is set by the web layer on messages the current user has
+	// not read yet.
+	IsUnread bool
+
+	// Vote is the current user's vote on the message and Bookmark their
+	// bookmark on it, if any. Both are set by the web layer.
+	Vote     int
+	Bookmark *Bookmark
+
+	// IsAccepted is set on the accepted answer of a question when a whole
+	// thread is loaded. CanAccept is set by the web layer when the current
+	// user can choose the accepted answer.
+	IsAccepted bool
+	CanAccept  bool
+
+	// ReplyTo is the message replied to, set when a whole thread is loaded.
+	// Replies is only set by the web layer when building the tree view.
+	ReplyTo *Message
+	Replies []*Message
 }
 
 // MessageModel holds a database handle for manipulating messages.
@@ -47,17 +83,30 @@ func (m *MessageModel) createTable() error {
 	return nil
 }
 
-// Insert inserts a new message in the Message table.
+// InsertMessage inserts a new message along with its attachments and returns
+// its id. replyToID is the message it answers, or 0 if it answers none in
+// particular. Either everything is saved or nothing is. ErrQuotaExceeded is
+// returned if the files uploaded by the author, these included, would take
+// more than quota bytes.
 func (m *MessageModel) InsertMessage(
-	body string, 
-	threadId, 
-	authorId int,
+	body string,
+	threadId,
+	authorId,
+	replyToID int,
+	attachments []*Attachment,
+	quota int64,
 ) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
 	stmt := `
-		INSERT INTO Messages (body, thread_id, author_id, date_added)
-		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		INSERT INTO Messages (body, thread_id, author_id, reply_to_id, date_added)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
 	`
-	result, err := m.DB.Exec(stmt, body, threadId, authorId)
+	result, err := tx.Exec(stmt, body, threadId, authorId, nullID(replyToID))
 	if err != nil {
 		return 0, fmt.Errorf("inserting new message in db: %w", err)
 	}
@@ -65,5 +114,143 @@ func (m *MessageModel) InsertMessage(
 	if err != nil {
 		return 0, fmt.Errorf("getting last insert id: %w", err)
 	}
+
+	if len(attachments) > 0 {
+		for _, a := range attachments {
+			err = insertAttachment(tx, int(id), a)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		// The quota is checked after the attachments are inserted: the
+		// insert holds the database write lock, so concurrent uploads are
+		// counted one after the other.
+		var used int64
+		err = tx.QueryRow(`SELECT coalesce(sum(size), 0) FROM attachments WHERE user_id = ?`, authorId).Scan(&used)
+		if err != nil {
+			return 0, fmt.Errorf("summing attachment sizes: %w", err)
+		}
+		if used > quota {
+			return 0, ErrQuotaExceeded
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("committing transaction: %w", err)
+	}
 	return int(id), nil
 }
+
+// Get retrieves the message with the given id, along with the title of the
+// thread it belongs to.
+func (m *MessageModel) Get(id int) (*Message, error) {
+	stmt := `
+		SELECT m.id, m.body, m.date_added, t.id, t.title, u.id, u.username, u.slug, u.email
+		FROM messages m, threads t, users u
+		WHERE m.thread_id = t.id AND m.author_id = u.id AND m.id = ?
+	`
+	var msg Message
+	err := m.DB.QueryRow(stmt, id).Scan(
+		&msg.ID, &msg.Body, &msg.DateAdded,
+		&msg.ThreadID, &msg.ThreadTitle,
+		&msg.Author.ID, &msg.Author.Username, &msg.Author.Slug, &msg.Author.Email,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, fmt.Errorf("querying database: %w", err)
+	}
+	return &msg, nil
+}
+
+// ByAuthor retrieves the latest messages posted by the given user in threads
+// the user with id viewerID can read, along with the title of the thread each
+// one belongs to.
+func (m *MessageModel) ByAuthor(authorID, viewerID, limit int) ([]*Message, error) {
+	visible, args := visibleTo(viewerID)
+	stmt := `
+		SELECT m.id, m.body, m.date_added, t.id, t.title, u.id, u.username, u.slug, u.email
+		FROM messages m, threads t, users u
+		WHERE m.thread_id = t.id AND m.author_id = u.id AND m.author_id = ?
+		  AND ` + visible + `
+		ORDER BY m.date_added DESC
+		LIMIT ?
+	`
+	args = append([]any{authorID}, args...)
+	rows, err := m.DB.Query(stmt, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("getting messages by author: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []*Message
+	for rows.Next() {
+		var msg Message
+		err := rows.Scan(
+			&msg.ID, &msg.Body, &msg.DateAdded,
+			&msg.ThreadID, &msg.ThreadTitle,
+			&msg.Author.ID, &msg.Author.Username, &msg.Author.Slug, &msg.Author.Email,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("scanning message row: %w", err)
+		}
+		messages = append(messages, &msg)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over message rows: %w", err)
+	}
+
+	return messages, nil
+}
+
+// After retrieves the replies to a thread with an id greater than afterID,
+// oldest first. The message each one replies to only has its id, author
+// name and opening flag filled in.
+func (m *MessageModel) After(threadID, afterID int) ([]*Message, error) {
+	stmt := `
+		SELECT m.id, m.body, m.revision, coalesce(m.reply_to_id, 0), m.score, m.date_added,
+		       u.id, u.username, u.slug, u.email, u.avatar_version,
+		       coalesce(r.is_opening, FALSE), coalesce(ru.username, '')
+		FROM messages m
+		JOIN users u ON u.id = m.author_id
+		LEFT JOIN messages r ON r.id = m.reply_to_id
+		LEFT JOIN users ru ON ru.id = r.author_id
+		WHERE m.thread_id = ? AND m.id > ? AND NOT m.is_opening
+		ORDER BY m.id
+	`
+	rows, err := m.DB.Query(stmt, threadID, afterID)
+	if err != nil {
+		return nil, fmt.Errorf("getting new messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []*Message
+	for rows.Next() {
+		var (
+			msg Message
+			r   Message
+		)
+		err := rows.Scan(
+			&msg.ID, &msg.Body, &msg.Revision, &msg.ReplyToID, &msg.Score, &msg.DateAdded,
+			&msg.Author.ID, &msg.Author.Username, &msg.Author.Slug, &msg.Author.Email, &msg.Author.AvatarVersion,
+			&r.IsOpening, &r.Author.Username,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("scanning message row: %w", err)
+		}
+		msg.ThreadID = threadID
+		if msg.ReplyToID != 0 {
+			r.ID = msg.ReplyToID
+			msg.ReplyTo = &r
+		}
+		messages = append(messages, &msg)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over message rows: %w", err)
+	}
+
+	return messages, nil
+}
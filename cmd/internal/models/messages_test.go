@@ -0,0 +1,55 @@
+package models
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestInsertMessageAttachments(t *testing.T) {
+	db := newTestDB(t)
+	messages := &MessageModel{DB: db}
+	attachments := &AttachmentModel{DB: db}
+
+	userID := insertTestUser(t, db, "alice")
+	threadID, _ := insertTestThread(t, db, userID)
+
+	files := []*Attachment{
+		{UserID: userID, StorageKey: "attachments/a", Filename: "a.txt", ContentType: "text/plain", Size: 60},
+		{UserID: userID, StorageKey: "attachments/b", Filename: "b.txt", ContentType: "text/plain", Size: 30},
+	}
+	messageID, err := messages.InsertMessage("With files", threadID, userID, 0, files, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range files {
+		if a.ID == 0 || a.MessageID != messageID {
+			t.Errorf("attachment %s: got id %d and message %d", a.Filename, a.ID, a.MessageID)
+		}
+	}
+
+	// 20 more bytes would take the user over quota: neither the message
+	// nor its attachment may be saved.
+	over := []*Attachment{
+		{UserID: userID, StorageKey: "attachments/c", Filename: "c.txt", ContentType: "text/plain", Size: 20},
+	}
+	_, err = messages.InsertMessage("Too much", threadID, userID, 0, over, 100)
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("got error %v; want ErrQuotaExceeded", err)
+	}
+
+	used, err := attachments.UsedBytes(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used != 90 {
+		t.Errorf("got %d used bytes; want 90", used)
+	}
+	var count int
+	err = db.QueryRow(`SELECT count(*) FROM messages WHERE thread_id = ?`, threadID).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("got %d messages; want the opening post and the first message", count)
+	}
+}
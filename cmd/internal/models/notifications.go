@@ -0,0 +1,156 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Kinds of notifications.
+const (
+	NotificationReply    = "reply"
+	NotificationMention  = "mention"
+	NotificationAccepted = "accepted" // an answer of the user was accepted
+)
+
+// Notification holds data about an event a user is notified of. Repeated
+// events of the same kind in the same thread are grouped into a single
+// unread notification whose Count is incremented.
+type Notification struct {
+	ID          int
+	UserID      int
+	Kind        string
+	ThreadID    int
+	ThreadTitle string
+	Actor       User
+	Count       int
+	IsRead      bool
+	DateUpdated time.Time
+}
+
+// NotificationModel holds a database handle for manipulating notifications.
+type NotificationModel struct {
+	DB *sql.DB
+}
+
+// Add notifies a user of an event of the given kind caused by actorID in a
+// thread. If the user already has an unread notification of that kind for
+// the thread, it is bumped instead of creating a new one.
+func (m *NotificationModel) Add(userID int, kind string, threadID, actorID int) error {
+	stmt := `
+		INSERT INTO notifications (user_id, kind, thread_id, actor_id, count, is_read, date_updated)
+		VALUES (?, ?, ?, ?, 1, FALSE, CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id, kind, thread_id) WHERE NOT is_read
+		DO UPDATE SET
+		    count = count + 1,
+		    actor_id = excluded.actor_id,
+		    date_updated = excluded.date_updated
+	`
+	_, err := m.DB.Exec(stmt, userID, kind, threadID, actorID)
+	if err != nil {
+		return fmt.Errorf("inserting notification in db: %w", err)
+	}
+	return nil
+}
+
+// ForUser retrieves the latest notifications of a user, newest first.
+// Notifications about threads the user can no longer read are left out.
+func (m *NotificationModel) ForUser(userID, limit int) ([]*Notification, error) {
+	visible, args := visibleTo(userID)
+	stmt := `
+		SELECT n.id, n.user_id, n.kind, n.count, n.is_read, n.date_updated,
+		       t.id, t.title, u.id, u.username, u.slug, u.email
+		FROM notifications n, threads t, users u
+		WHERE n.thread_id = t.id AND n.actor_id = u.id AND n.user_id = ?
+		  AND ` + visible + `
+		ORDER BY n.date_updated DESC, n.id DESC
+		LIMIT ?
+	`
+	args = append([]any{userID}, args...)
+	rows, err := m.DB.Query(stmt, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("getting notifications: %w", err)
+	}
+	defer rows.Close()
+
+	var notifications []*Notification
+	for rows.Next() {
+		var n Notification
+		err := rows.Scan(
+			&n.ID, &n.UserID, &n.Kind, &n.Count, &n.IsRead, &n.DateUpdated,
+			&n.ThreadID, &n.ThreadTitle,
+			&n.Actor.ID, &n.Actor.Username, &n.Actor.Slug, &n.Actor.Email,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("scanning notification row: %w", err)
+		}
+		notifications = append(notifications, &n)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over notification rows: %w", err)
+	}
+
+	return notifications, nil
+}
+
+// CountUnread returns the number of unread notifications of a user about
+// threads they can read.
+func (m *NotificationModel) CountUnread(userID int) (int, error) {
+	visible, args := visibleTo(userID)
+	stmt := `
+		SELECT COUNT(*) FROM notifications n, threads t
+		WHERE n.thread_id = t.id AND n.user_id = ? AND NOT n.is_read AND ` + visible
+	var n int
+	err := m.DB.QueryRow(stmt, append([]any{userID}, args...)...).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("counting unread notifications: %w", err)
+	}
+	return n, nil
+}
+
+// MarkRead marks a notification of a user as read. It returns ErrNoRecord if
+// the user has no such notification.
+func (m *NotificationModel) MarkRead(userID, id int) error {
+	stmt := `UPDATE notifications SET is_read = TRUE WHERE id = ? AND user_id = ?`
+	result, err := m.DB.Exec(stmt, id, userID)
+	if err != nil {
+		return fmt.Errorf("marking notification as read: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("getting affected rows: %w", err)
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// MarkAllRead marks every notification of a user as read.
+func (m *NotificationModel) MarkAllRead(userID int) error {
+	stmt := `UPDATE notifications SET is_read = TRUE WHERE user_id = ? AND NOT is_read`
+	_, err := m.DB.Exec(stmt, userID)
+	if err != nil {
+		return fmt.Errorf("marking notifications as read: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpired removes read notifications last updated before readBefore
+// and unread ones last updated before unreadBefore. It returns the number of
+// notifications removed.
+func (m *NotificationModel) DeleteExpired(readBefore, unreadBefore time.Time) (int64, error) {
+	stmt := `
+		DELETE FROM notifications
+		WHERE (is_read AND date_updated < ?) OR date_updated < ?
+	`
+	result, err := m.DB.Exec(stmt, sqlTime(readBefore), sqlTime(unreadBefore))
+	if err != nil {
+		return 0, fmt.Errorf("deleting expired notifications: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("getting affected rows: %w", err)
+	}
+	return n, nil
+}
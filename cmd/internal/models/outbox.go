@@ -0,0 +1,133 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// OutboxEmail holds data about an email waiting to be sent. Emails are
+// stored before being sent so that none are lost if the server restarts or
+// the mail server is unavailable.
+type OutboxEmail struct {
+	ID       int
+	To       string
+	Subject  string
+	Body     string
+	Headers  map[string]string
+	Attempts int
+}
+
+// OutboxModel holds a database handle for manipulating the email outbox.
+type OutboxModel struct {
+	DB *sql.DB
+}
+
+// Enqueue stores an email to be sent as soon as possible.
+func (m *OutboxModel) Enqueue(to, subject, body string, headers map[string]string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = enqueueEmail(tx, &OutboxEmail{To: to, Subject: subject, Body: body, Headers: headers})
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+	return nil
+}
+
+// enqueueEmail stores e in the outbox as part of tx.
+func enqueueEmail(tx *sql.Tx, e *OutboxEmail) error {
+	encoded, err := json.Marshal(e.Headers)
+	if err != nil {
+		return fmt.Errorf("encoding headers: %w", err)
+	}
+	stmt := `
+		INSERT INTO outbox (recipient, subject, body, headers, status, attempts, next_attempt, date_added)
+		VALUES (?, ?, ?, ?, 'pending', 0, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	`
+	_, err = tx.Exec(stmt, e.To, e.Subject, e.Body, string(encoded))
+	if err != nil {
+		return fmt.Errorf("inserting email in outbox: %w", err)
+	}
+	return nil
+}
+
+// Due retrieves pending emails whose next attempt is due, oldest first.
+func (m *OutboxModel) Due(now time.Time, limit int) ([]*OutboxEmail, error) {
+	stmt := `
+		SELECT id, recipient, subject, body, headers, attempts
+		FROM outbox
+		WHERE status = 'pending' AND next_attempt <= ?
+		ORDER BY next_attempt, id
+		LIMIT ?
+	`
+	rows, err := m.DB.Query(stmt, sqlTime(now), limit)
+	if err != nil {
+		return nil, fmt.Errorf("getting due emails: %w", err)
+	}
+	defer rows.Close()
+
+	var emails []*OutboxEmail
+	for rows.Next() {
+		var (
+			e       OutboxEmail
+			headers string
+		)
+		err := rows.Scan(&e.ID, &e.To, &e.Subject, &e.Body, &headers, &e.Attempts)
+		if err != nil {
+			return nil, fmt.Errorf("scanning email row: %w", err)
+		}
+		err = json.Unmarshal([]byte(headers), &e.Headers)
+		if err != nil {
+			return nil, fmt.Errorf("decoding headers of email %d: %w", e.ID, err)
+		}
+		emails = append(emails, &e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over email rows: %w", err)
+	}
+
+	return emails, nil
+}
+
+// MarkSent records that an email was sent.
+func (m *OutboxModel) MarkSent(id int) error {
+	stmt := `
+		UPDATE outbox
+		SET status = 'sent', attempts = attempts + 1, date_sent = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := m.DB.Exec(stmt, id)
+	if err != nil {
+		return fmt.Errorf("marking email as sent: %w", err)
+	}
+	return nil
+}
+
+// MarkFailed records a failed attempt to send an email. The email is retried
+// at next unless giveUp is set, in which case it is abandoned.
+func (m *OutboxModel) MarkFailed(id int, sendErr error, next time.Time, giveUp bool) error {
+	status := "pending"
+	if giveUp {
+		status = "failed"
+	}
+	stmt := `
+		UPDATE outbox
+		SET status = ?, attempts = attempts + 1, last_error = ?, next_attempt = ?
+		WHERE id = ?
+	`
+	_, err := m.DB.Exec(stmt, status, sendErr.Error(), sqlTime(next), id)
+	if err != nil {
+		return fmt.Errorf("marking email as failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Poll holds data about the poll attached to a thread.
+type Poll struct {
+	ID        int
+	ThreadID  int
+	Question  string
+	Multiple  bool      // voters can choose several options
+	Anonymous bool      // who voted for what is never shown
+	ClosesAt  time.Time // zero if the poll stays open until closed by hand
+	Options   []*PollOption
+	Voters    int // number of users who voted
+
+	// Voted is set when the poll is loaded for a user who already voted.
+	Voted bool
+}
+
+// PollOption holds one of the answers of a Poll.
+type PollOption struct {
+	ID     int
+	Label  string
+	Votes  int
+	Chosen bool    // the user the poll was loaded for voted for it
+	Users  []*User // who voted for it, only loaded for public polls
+}
+
+// IsClosed reports whether the poll no longer accepts votes.
+func (p *Poll) IsClosed() bool {
+	return !p.ClosesAt.IsZero() && !time.Now().Before(p.ClosesAt)
+}
+
+// Percent returns the share of the voters who chose o, rounded down.
+func (p *Poll) Percent(o *PollOption) int {
+	if p.Voters == 0 {
+		return 0
+	}
+	return o.Votes * 100 / p.Voters
+}
+
+// PollModel holds a database handle for manipulating polls.
+type PollModel struct {
+	DB *sql.DB
+}
+
+// insertPoll saves p, attached to the given thread, as part of tx.
+func insertPoll(tx *sql.Tx, threadID int, p *Poll) error {
+	var closesAt sql.NullString
+	if !p.ClosesAt.IsZero() {
+		closesAt = sql.NullString{String: sqlTime(p.ClosesAt), Valid: true}
+	}
+	stmt := `
+		INSERT INTO polls (thread_id, question, multiple, anonymous, closes_at)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	result, err := tx.Exec(stmt, threadID, p.Question, p.Multiple, p.Anonymous, closesAt)
+	if err != nil {
+		return fmt.Errorf("inserting poll: %w", err)
+	}
+	pollID, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("getting last poll id: %w", err)
+	}
+
+	stmt = `INSERT INTO poll_options (poll_id, position, label) VALUES (?, ?, ?)`
+	for i, o := range p.Options {
+		_, err = tx.Exec(stmt, pollID, i, o.Label)
+		if err != nil {
+			return fmt.Errorf("inserting poll option: %w", err)
+		}
+	}
+	return nil
+}
+
+// ForThread retrieves the poll of a thread with its results, relative to the
+// user with the given id. It returns ErrNoRecord if the thread has no poll.
+func (m *PollModel) ForThread(threadID, userID int) (*Poll, error) {
+	stmt := `
+		SELECT p.id, p.thread_id, p.question, p.multiple, p.anonymous, p.closes_at,
+		       (SELECT count(DISTINCT user_id) FROM poll_votes WHERE poll_id = p.id),
+		       EXISTS (SELECT 1 FROM poll_votes WHERE poll_id = p.id AND user_id = ?)
+		FROM polls p
+		WHERE p.thread_id = ?
+	`
+	var (
+		p        Poll
+		closesAt sql.NullTime
+	)
+	err := m.DB.QueryRow(stmt, userID, threadID).Scan(
+		&p.ID, &p.ThreadID, &p.Question, &p.Multiple, &p.Anonymous, &closesAt,
+		&p.Voters, &p.Voted,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, fmt.Errorf("querying poll: %w", err)
+	}
+	p.ClosesAt = closesAt.Time
+
+	stmt = `
+		SELECT o.id, o.label,
+		       (SELECT count(*) FROM poll_votes v WHERE v.option_id = o.id),
+		       EXISTS (SELECT 1 FROM poll_votes v WHERE v.option_id = o.id AND v.user_id = ?)
+		FROM poll_options o
+		WHERE o.poll_id = ?
+		ORDER BY o.position
+	`
+	rows, err := m.DB.Query(stmt, userID, p.ID)
+	if err != nil {
+		return nil, fmt.Errorf("querying poll options: %w", err)
+	}
+	defer rows.Close()
+
+	options := make(map[int]*PollOption)
+	for rows.Next() {
+		var o PollOption
+		err := rows.Scan(&o.ID, &o.Label, &o.Votes, &o.Chosen)
+		if err != nil {
+			return nil, fmt.Errorf("scanning poll option row: %w", err)
+		}
+		p.Options = append(p.Options, &o)
+		options[o.ID] = &o
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over poll option rows: %w", err)
+	}
+
+	if !p.Anonymous {
+		err = m.loadVoters(p.ID, options)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &p, nil
+}
+
+// loadVoters fills in the users who voted for each of the given options of
+// a poll.
+func (m *PollModel) loadVoters(pollID int, options map[int]*PollOption) error {
+	stmt := `
+		SELECT v.option_id, u.id, u.username, u.slug
+		FROM poll_votes v, users u
+		WHERE v.user_id = u.id AND v.poll_id = ?
+		ORDER BY u.username
+	`
+	rows, err := m.DB.Query(stmt, pollID)
+	if err != nil {
+		return fmt.Errorf("querying poll voters: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			optionID int
+			u        User
+		)
+		err := rows.Scan(&optionID, &u.ID, &u.Username, &u.Slug)
+		if err != nil {
+			return fmt.Errorf("scanning poll voter row: %w", err)
+		}
+		if o := options[optionID]; o != nil {
+			o.Users = append(o.Users, &u)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("iterating over poll voter rows: %w", err)
+	}
+	return nil
+}
+
+// Vote records the choice of a user, which must be options of the poll. A
+// user votes once: ErrAlreadyVoted is returned if they voted before, and
+// ErrPollClosed if the poll no longer accepts votes.
+func (m *PollModel) Vote(pollID, userID int, optionIDs []int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var open bool
+	stmt := `SELECT closes_at IS NULL OR closes_at > ? FROM polls WHERE id = ?`
+	err = tx.QueryRow(stmt, sqlTime(time.Now()), pollID).Scan(&open)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return fmt.Errorf("querying poll: %w", err)
+	}
+	if !open {
+		return ErrPollClosed
+	}
+
+	_, err = tx.Exec(`INSERT INTO poll_ballots (poll_id, user_id) VALUES (?, ?)`, pollID, userID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrAlreadyVoted
+		}
+		return fmt.Errorf("inserting poll ballot: %w", err)
+	}
+
+	stmt = `
+		INSERT INTO poll_votes (poll_id, option_id, user_id)
+		SELECT poll_id, id, ? FROM poll_options WHERE poll_id = ? AND id = ?
+	`
+	for _, id := range optionIDs {
+		result, err := tx.Exec(stmt, userID, pollID, id)
+		if err != nil {
+			return fmt.Errorf("inserting poll vote: %w", err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("getting affected rows: %w", err)
+		}
+		if n == 0 {
+			return ErrNoRecord
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Close stops a poll from accepting votes. Closing a closed poll keeps the
+// time it closed at.
+func (m *PollModel) Close(pollID int) error {
+	now := sqlTime(time.Now())
+	stmt := `
+		UPDATE polls SET closes_at = ?
+		WHERE id = ? AND (closes_at IS NULL OR closes_at > ?)
+	`
+	_, err := m.DB.Exec(stmt, now, pollID, now)
+	if err != nil {
+		return fmt.Errorf("closing poll: %w", err)
+	}
+	return nil
+}
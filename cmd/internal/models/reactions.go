@@ -0,0 +1,135 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Reaction holds the number of users who reacted to a message with an emoji,
+// and whether the current user is one of them.
+type Reaction struct {
+	Emoji   string
+	Count   int
+	Reacted bool
+}
+
+// ReactionModel holds a database handle for manipulating emoji reactions on
+// messages. A user reacts at most once with each emoji to a message.
+type ReactionModel struct {
+	DB *sql.DB
+}
+
+// Toggle adds the user's reaction to a message, or removes it if it was
+// already there. It returns whether the user now reacts with the emoji and
+// the resulting number of reactions with it.
+func (m *ReactionModel) Toggle(messageID, userID int, emoji string) (reacted bool, count int, err error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return false, 0, fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM reactions WHERE message_id = ? AND user_id = ? AND emoji = ?`, messageID, userID, emoji)
+	if err != nil {
+		return false, 0, fmt.Errorf("deleting reaction: %w", err)
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return false, 0, fmt.Errorf("getting affected rows: %w", err)
+	}
+
+	if removed == 0 {
+		stmt := `
+			INSERT OR IGNORE INTO reactions (message_id, user_id, emoji, date_added)
+			VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		`
+		_, err = tx.Exec(stmt, messageID, userID, emoji)
+		if err != nil {
+			return false, 0, fmt.Errorf("inserting reaction: %w", err)
+		}
+	}
+
+	stmt := `SELECT count(*) FROM reactions WHERE message_id = ? AND emoji = ?`
+	err = tx.QueryRow(stmt, messageID, emoji).Scan(&count)
+	if err != nil {
+		return false, 0, fmt.Errorf("counting reactions: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, 0, fmt.Errorf("committing transaction: %w", err)
+	}
+	return removed == 0, count, nil
+}
+
+// ForThread counts the reactions to every message in a thread, keyed by
+// message id then emoji, so a whole thread can be rendered with a single
+// query. Reacted is set on the reactions of the user with the given id.
+func (m *ReactionModel) ForThread(threadID, userID int) (map[int]map[string]*Reaction, error) {
+	stmt := `
+		SELECT r.message_id, r.emoji, count(*), max(r.user_id = ?)
+		FROM reactions r, messages m
+		WHERE r.message_id = m.id AND m.thread_id = ?
+		GROUP BY r.message_id, r.emoji
+	`
+	rows, err := m.DB.Query(stmt, userID, threadID)
+	if err != nil {
+		return nil, fmt.Errorf("getting reactions: %w", err)
+	}
+	defer rows.Close()
+
+	reactions := map[int]map[string]*Reaction{}
+	for rows.Next() {
+		var (
+			messageID int
+			r         Reaction
+		)
+		err := rows.Scan(&messageID, &r.Emoji, &r.Count, &r.Reacted)
+		if err != nil {
+			return nil, fmt.Errorf("scanning reaction row: %w", err)
+		}
+		if reactions[messageID] == nil {
+			reactions[messageID] = map[string]*Reaction{}
+		}
+		reactions[messageID][r.Emoji] = &r
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over reaction rows: %w", err)
+	}
+
+	return reactions, nil
+}
+
+// Users retrieves the users who reacted to a message, keyed by emoji, in the
+// order they reacted.
+func (m *ReactionModel) Users(messageID int) (map[string][]*User, error) {
+	stmt := `
+		SELECT r.emoji, u.id, u.username, u.slug
+		FROM reactions r, users u
+		WHERE r.user_id = u.id AND r.message_id = ?
+		ORDER BY r.date_added, r.rowid
+	`
+	rows, err := m.DB.Query(stmt, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("getting reaction users: %w", err)
+	}
+	defer rows.Close()
+
+	users := map[string][]*User{}
+	for rows.Next() {
+		var (
+			emoji string
+			u     User
+		)
+		err := rows.Scan(&emoji, &u.ID, &u.Username, &u.Slug)
+		if err != nil {
+			return nil, fmt.Errorf("scanning reaction user row: %w", err)
+		}
+		users[emoji] = append(users[emoji], &u)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over reaction user rows: %w", err)
+	}
+
+	return users, nil
+}
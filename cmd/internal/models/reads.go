@@ -0,0 +1,63 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ReadModel holds a database handle for manipulating the per user read
+// markers of threads. A marker records the id of the last message a user has
+// seen in a thread; later messages are unread.
+type ReadModel struct {
+	DB *sql.DB
+}
+
+// LastRead returns the id of the last message the user has read in a thread.
+// seen is false if the user never opened the thread.
+func (m *ReadModel) LastRead(userID, threadID int) (lastRead int, seen bool, err error) {
+	stmt := `SELECT last_read_message_id FROM thread_reads WHERE user_id = ? AND thread_id = ?`
+	err = m.DB.QueryRow(stmt, userID, threadID).Scan(&lastRead)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("querying database: %w", err)
+	}
+	return lastRead, true, nil
+}
+
+// MarkRead records that a user has read a thread up to the given message.
+// The marker never moves backwards.
+func (m *ReadModel) MarkRead(userID, threadID, messageID int) error {
+	stmt := `
+		INSERT INTO thread_reads (user_id, thread_id, last_read_message_id, date_read)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id, thread_id) DO UPDATE
+		SET last_read_message_id = max(last_read_message_id, excluded.last_read_message_id),
+		    date_read = excluded.date_read
+	`
+	_, err := m.DB.Exec(stmt, userID, threadID, messageID)
+	if err != nil {
+		return fmt.Errorf("updating read marker: %w", err)
+	}
+	return nil
+}
+
+// MarkAllRead records that a user has read every message of every thread.
+func (m *ReadModel) MarkAllRead(userID int) error {
+	stmt := `
+		INSERT INTO thread_reads (user_id, thread_id, last_read_message_id, date_read)
+		SELECT ?, t.id, coalesce(max(m.id), 0), CURRENT_TIMESTAMP
+		FROM threads t LEFT JOIN messages m ON m.thread_id = t.id
+		GROUP BY t.id
+		ON CONFLICT (user_id, thread_id) DO UPDATE
+		SET last_read_message_id = max(last_read_message_id, excluded.last_read_message_id),
+		    date_read = excluded.date_read
+	`
+	_, err := m.DB.Exec(stmt, userID)
+	if err != nil {
+		return fmt.Errorf("marking all threads as read: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Email frequencies a user can choose for thread subscription emails.
+const (
+	EmailImmediate = "immediate"
+	EmailDaily     = "daily"
+	EmailWeekly    = "weekly"
+	EmailNever     = "never"
+)
+
+// DigestPeriods maps the digest email frequencies to their period.
+var DigestPeriods = map[string]time.Duration{
+	EmailDaily:  24 * time.Hour,
+	EmailWeekly: 7 * 24 * time.Hour,
+}
+
+// Subscriber holds data about a user watching a thread.
+type Subscriber struct {
+	UserID         int
+	Username       string
+	Email          string
+	EmailFrequency string
+	LastDigest     sql.NullTime
+}
+
+// SubscriptionModel holds a database handle for manipulating thread
+// subscriptions.
+type SubscriptionModel struct {
+	DB *sql.DB
+}
+
+// Subscribe makes a user watch a thread. Subscribing twice is not an error.
+func (m *SubscriptionModel) Subscribe(userID, threadID int) error {
+	stmt := `
+		INSERT OR IGNORE INTO subscriptions (user_id, thread_id, date_added)
+		VALUES (?, ?, CURRENT_TIMESTAMP)
+	`
+	_, err := m.DB.Exec(stmt, userID, threadID)
+	if err != nil {
+		return fmt.Errorf("inserting subscription in db: %w", err)
+	}
+	return nil
+}
+
+// Unsubscribe makes a user stop watching a thread.
+func (m *SubscriptionModel) Unsubscribe(userID, threadID int) error {
+	stmt := `DELETE FROM subscriptions WHERE user_id = ? AND thread_id = ?`
+	_, err := m.DB.Exec(stmt, userID, threadID)
+	if err != nil {
+		return fmt.Errorf("deleting subscription: %w", err)
+	}
+	return nil
+}
+
+// IsSubscribed reports whether a user watches a thread.
+func (m *SubscriptionModel) IsSubscribed(userID, threadID int) (bool, error) {
+	stmt := `SELECT EXISTS(SELECT 1 FROM subscriptions WHERE user_id = ? AND thread_id = ?)`
+	var exists bool
+	err := m.DB.QueryRow(stmt, userID, threadID).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("querying database: %w", err)
+	}
+	return exists, nil
+}
+
+// Subscribers retrieves the users watching a thread.
+func (m *SubscriptionModel) Subscribers(threadID int) ([]*Subscriber, error) {
+	stmt := `
+		SELECT u.id, u.username, u.email, u.email_frequency
+		FROM subscriptions s, users u
+		WHERE s.user_id = u.id AND s.thread_id = ?
+	`
+	rows, err := m.DB.Query(stmt, threadID)
+	if err != nil {
+		return nil, fmt.Errorf("getting subscribers: %w", err)
+	}
+	defer rows.Close()
+
+	var subscribers []*Subscriber
+	for rows.Next() {
+		var s Subscriber
+		err := rows.Scan(&s.UserID, &s.Username, &s.Email, &s.EmailFrequency)
+		if err != nil {
+			return nil, fmt.Errorf("scanning subscriber row: %w", err)
+		}
+		subscribers = append(subscribers, &s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over subscriber rows: %w", err)
+	}
+
+	return subscribers, nil
+}
+
+// DueDigests retrieves the users receiving digests at the given frequency
+// whose last digest is older than the digest period.
+func (m *SubscriptionModel) DueDigests(frequency string, now time.Time) ([]*Subscriber, error) {
+	stmt := `
+		SELECT id, username, email, email_frequency, last_digest
+		FROM users
+		WHERE email_frequency = ? AND (last_digest IS NULL OR last_digest <= ?)
+	`
+	rows, err := m.DB.Query(stmt, frequency, sqlTime(now.Add(-DigestPeriods[frequency])))
+	if err != nil {
+		return nil, fmt.Errorf("getting due digests: %w", err)
+	}
+	defer rows.Close()
+
+	var subscribers []*Subscriber
+	for rows.Next() {
+		var s Subscriber
+		err := rows.Scan(&s.UserID, &s.Username, &s.Email, &s.EmailFrequency, &s.LastDigest)
+		if err != nil {
+			return nil, fmt.Errorf("scanning subscriber row: %w", err)
+		}
+		subscribers = append(subscribers, &s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over subscriber rows: %w", err)
+	}
+
+	return subscribers, nil
+}
+
+// DigestMessages retrieves the messages posted by others since the given
+// time in the threads a user watches and can still read, oldest first.
+func (m *SubscriptionModel) DigestMessages(userID int, since time.Time) ([]*Message, error) {
+	visible, args := visibleTo(userID)
+	stmt := `
+		SELECT m.id, m.body, m.date_added, t.id, t.title, u.id, u.username, u.slug, u.email
+		FROM subscriptions s, messages m, threads t, users u
+		WHERE s.thread_id = t.id AND m.thread_id = t.id AND m.author_id = u.id
+		  AND s.user_id = ? AND m.author_id != s.user_id AND m.date_added > ?
+		  AND ` + visible + `
+		ORDER BY t.id, m.date_added
+	`
+	args = append([]any{userID, sqlTime(since)}, args...)
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("getting digest messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []*Message
+	for rows.Next() {
+		var msg Message
+		err := rows.Scan(
+			&msg.ID, &msg.Body, &msg.DateAdded,
+			&msg.ThreadID, &msg.ThreadTitle,
+			&msg.Author.ID, &msg.Author.Username, &msg.Author.Slug, &msg.Author.Email,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("scanning message row: %w", err)
+		}
+		messages = append(messages, &msg)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over message rows: %w", err)
+	}
+
+	return messages, nil
+}
+
+// RecordDigest records when a user was last sent a digest and queues its
+// email, unless nil, in the same transaction, so that a digest is neither
+// sent twice nor skipped.
+func (m *SubscriptionModel) RecordDigest(userID int, t time.Time, email *OutboxEmail) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if email != nil {
+		err = enqueueEmail(tx, email)
+		if err != nil {
+			return err
+		}
+	}
+
+	stmt := `UPDATE users SET last_digest = ? WHERE id = ?`
+	_, err = tx.Exec(stmt, sqlTime(t), userID)
+	if err != nil {
+		return fmt.Errorf("updating last digest: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+	return nil
+}
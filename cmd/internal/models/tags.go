@@ -0,0 +1,221 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Tag holds data about a free-form label threads can be tagged with.
+type Tag struct {
+	ID   int
+	Name string
+	Slug string
+
+	// ThreadCount is only set by All and Search.
+	ThreadCount int
+}
+
+// TagModel holds a database handle for manipulating tags.
+type TagModel struct {
+	DB *sql.DB
+}
+
+// SetForThread replaces the tags of a thread with the tags named in names,
+// creating the tags that do not exist yet. Names are expected to be
+// normalized already.
+func (m *TagModel) SetForThread(threadID int, names []string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = setThreadTags(tx, threadID, names)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+	return nil
+}
+
+// setThreadTags replaces the tags of a thread within a transaction.
+func setThreadTags(tx *sql.Tx, threadID int, names []string) error {
+	_, err := tx.Exec(`DELETE FROM thread_tags WHERE thread_id = ?`, threadID)
+	if err != nil {
+		return fmt.Errorf("deleting thread tags: %w", err)
+	}
+
+	for _, name := range names {
+		_, err = tx.Exec(
+			`INSERT OR IGNORE INTO tags (name, slug, date_added) VALUES (?, ?, CURRENT_TIMESTAMP)`,
+			name, Slugify(name),
+		)
+		if err != nil {
+			return fmt.Errorf("inserting tag: %w", err)
+		}
+		_, err = tx.Exec(
+			`
+				INSERT OR IGNORE INTO thread_tags (thread_id, tag_id)
+				SELECT ?, id FROM tags WHERE slug = ?
+			`,
+			threadID, Slugify(name),
+		)
+		if err != nil {
+			return fmt.Errorf("tagging thread: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetBySlug retrieves the tag with the given slug.
+func (m *TagModel) GetBySlug(slug string) (*Tag, error) {
+	stmt := `SELECT id, name, slug FROM tags WHERE slug = ?`
+	var t Tag
+	err := m.DB.QueryRow(stmt, slug).Scan(&t.ID, &t.Name, &t.Slug)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, fmt.Errorf("querying database: %w", err)
+	}
+	return &t, nil
+}
+
+// Get retrieves the tag with the given id.
+func (m *TagModel) Get(id int) (*Tag, error) {
+	stmt := `SELECT id, name, slug FROM tags WHERE id = ?`
+	var t Tag
+	err := m.DB.QueryRow(stmt, id).Scan(&t.ID, &t.Name, &t.Slug)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, fmt.Errorf("querying database: %w", err)
+	}
+	return &t, nil
+}
+
+// All retrieves every tag with the number of threads using it that the user
+// with the given id can read, by name. Tags only used by threads the user
+// cannot read are left out; unused tags are kept.
+func (m *TagModel) All(userID int) ([]*Tag, error) {
+	visible, args := visibleTo(userID)
+	stmt := `
+		SELECT tg.id, tg.name, tg.slug, count(CASE WHEN ` + visible + ` THEN 1 END) AS n
+		FROM tags tg
+		LEFT JOIN thread_tags tt ON tt.tag_id = tg.id
+		LEFT JOIN threads t ON t.id = tt.thread_id
+		GROUP BY tg.id
+		HAVING n > 0 OR count(t.id) = 0
+		ORDER BY tg.name
+	`
+	return m.listTags(stmt, args...)
+}
+
+// Search retrieves the tags whose name starts with prefix that are most used
+// by threads the user with the given id can read. Tags only used by threads
+// the user cannot read are left out.
+func (m *TagModel) Search(prefix string, userID, limit int) ([]*Tag, error) {
+	prefix = escapeLike(strings.ToLower(prefix))
+	visible, args := visibleTo(userID)
+	stmt := `
+		SELECT tg.id, tg.name, tg.slug, count(CASE WHEN ` + visible + ` THEN 1 END) AS n
+		FROM tags tg
+		LEFT JOIN thread_tags tt ON tt.tag_id = tg.id
+		LEFT JOIN threads t ON t.id = tt.thread_id
+		WHERE tg.name LIKE ? ESCAPE '\'
+		GROUP BY tg.id
+		HAVING n > 0 OR count(t.id) = 0
+		ORDER BY n DESC, tg.name
+		LIMIT ?
+	`
+	return m.listTags(stmt, append(args, prefix+"%", limit)...)
+}
+
+// listTags runs a query selecting tags with their thread counts and scans
+// the results.
+func (m *TagModel) listTags(stmt string, args ...any) ([]*Tag, error) {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("getting tags: %w", err)
+	}
+	defer rows.Close()
+
+	var tags []*Tag
+	for rows.Next() {
+		var t Tag
+		err := rows.Scan(&t.ID, &t.Name, &t.Slug, &t.ThreadCount)
+		if err != nil {
+			return nil, fmt.Errorf("scanning tag row: %w", err)
+		}
+		tags = append(tags, &t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over tag rows: %w", err)
+	}
+
+	return tags, nil
+}
+
+// Rename changes the name, and so the slug, of a tag. It returns
+// ErrDuplicateSlug if another tag already has that slug; the tags should be
+// merged instead.
+func (m *TagModel) Rename(id int, name string) error {
+	stmt := `UPDATE tags SET name = ?, slug = ? WHERE id = ?`
+	result, err := m.DB.Exec(stmt, name, Slugify(name), id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrDuplicateSlug
+		}
+		return fmt.Errorf("renaming tag: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("getting affected rows: %w", err)
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// Merge moves every thread tagged with the tag fromID to the tag intoID,
+// then deletes the tag fromID.
+func (m *TagModel) Merge(fromID, intoID int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`
+			INSERT OR IGNORE INTO thread_tags (thread_id, tag_id)
+			SELECT thread_id, ? FROM thread_tags WHERE tag_id = ?
+		`,
+		intoID, fromID,
+	)
+	if err != nil {
+		return fmt.Errorf("retagging threads: %w", err)
+	}
+	_, err = tx.Exec(`DELETE FROM thread_tags WHERE tag_id = ?`, fromID)
+	if err != nil {
+		return fmt.Errorf("deleting thread tags: %w", err)
+	}
+	_, err = tx.Exec(`DELETE FROM tags WHERE id = ?`, fromID)
+	if err != nil {
+		return fmt.Errorf("deleting tag: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+	return nil
+}
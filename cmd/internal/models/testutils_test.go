@@ -0,0 +1,65 @@
+package models
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newTestDB returns a new database with the forum schema, deleted at the end
+// of the test.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	schema, err := os.ReadFile("../schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(string(schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// insertTestUser inserts a user without going through the slow password
+// hashing of UserModel.InsertUser and returns its id.
+func insertTestUser(t *testing.T, db *sql.DB, username string) int {
+	t.Helper()
+
+	stmt := `INSERT INTO users (username, slug, email, password) VALUES (?, ?, ?, '')`
+	result, err := db.Exec(stmt, username, Slugify(username), username+"@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int(id)
+}
+
+// insertTestThread inserts a public thread with an opening post and returns
+// the ids of both.
+func insertTestThread(t *testing.T, db *sql.DB, authorID int) (int, int) {
+	t.Helper()
+
+	threads := &ThreadModel{DB: db}
+	threadID, openingID, err := threads.Insert(
+		"Thread", "Opening post", authorID, 0, KindDiscussion, VisibilityPublic, 0, nil, nil, time.Time{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return threadID, openingID
+}
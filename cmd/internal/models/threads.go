@@ -2,17 +2,102 @@ package models
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 )
 
 // Thread holds data about a thread.
 type Thread struct {
-	ID        int
-	Title     string
-	Author    *User
+	ID       int
+	Title    string
+	Author   *User
+	Category *Category // nil for uncategorized threads
+	Tags     []*Tag
+	Score    int
+
+	// Visibility is one of the Visibility constants. Group is only set
+	// for threads restricted to a group.
+	Visibility string
+	Group      *Group
+
+	// Kind is one of the Kind constants. AcceptedID is the id of the
+	// accepted answer of a question, zero if there is none. Accepted is
+	// only set when a whole thread is loaded.
+	Kind       string
+	AcceptedID int
+	Accepted   *Message
+
+	// ScheduledAt is when a thread created for later is to be published. It
+	// is zero once the thread is published.
+	ScheduledAt time.Time
+
 	DateAdded time.Time
 	Messages  []*Message
+
+	// Vote is the current user's vote on the thread and Bookmark their
+	// bookmark on it, if any. Both are set by the web layer.
+	Vote     int
+	Bookmark *Bookmark
+
+	// The fields below are only set by thread listings, which load the
+	// latest message instead of every message. The unread fields are
+	// relative to the user the listing was loaded for.
+	MessageCount  int
+	LatestMessage *Message
+	IsNew         bool // the user never opened the thread
+	Unread        int  // number of messages by others the user has not read
+	FirstUnreadID int  // id of the first of them
+}
+
+// IsScheduled reports whether the thread is waiting to be published.
+func (t *Thread) IsScheduled() bool {
+	return !t.ScheduledAt.IsZero()
+}
+
+// Thread visibilities.
+const (
+	VisibilityPublic  = "public"  // anyone, including anonymous visitors
+	VisibilityMembers = "members" // logged in users
+	VisibilityGroup   = "group"   // members of the thread's group
+)
+
+// Thread kinds.
+const (
+	KindDiscussion = "discussion"
+	KindQuestion   = "question"
+)
+
+// unansweredFilter is the SQL condition on the threads table t selecting the
+// questions without an accepted answer.
+const unansweredFilter = "t.kind = 'question' AND t.accepted_message_id IS NULL"
+
+// visibleTo returns the SQL condition on the threads table t selecting the
+// published threads the user with the given id can read, along with its args.
+// userID is zero for anonymous users. Authors can always read their own
+// threads. Every query returning threads or their messages must use it.
+func visibleTo(userID int) (string, []any) {
+	cond := `(
+		t.scheduled_at IS NULL AND (
+		    t.visibility = 'public'
+		    OR (t.visibility = 'members' AND ? != 0)
+		    OR t.author_id = ?
+		    OR (
+		        t.visibility = 'group'
+		        AND t.group_id IN (SELECT group_id FROM group_members WHERE user_id = ?)
+		    )
+		)
+	)`
+	return cond, []any{userID, userID, userID}
+}
+
+// readableBy is like visibleTo, but also lets authors read their scheduled
+// threads before they are published. It is only used to load single threads;
+// listings use visibleTo so that scheduled threads stay out of them.
+func readableBy(userID int) (string, []any) {
+	visible, args := visibleTo(userID)
+	return "(" + visible + " OR t.author_id = ?)", append(args, userID)
 }
 
 // ThreadModel holds a database handle to manipulate a Thread.
@@ -47,68 +132,717 @@ func (m *ThreadModel) createTable() error {
 	return nil
 }
 
-// Insert inserts a new thread in the database and returns its id.
-func (m *ThreadModel) Insert(title string, authorId int) (int, error) {
+// Insert inserts a new thread in the database along with its opening post
+// and tags, and returns the ids of the thread and of the opening post. Either
+// everything is saved or nothing is. A zero categoryID leaves the thread
+// uncategorized; groupID is only used by group restricted threads. kind is
+// one of the Kind constants. poll is nil for threads without a poll. A
+// non-zero scheduledAt keeps the thread unpublished until then.
+func (m *ThreadModel) Insert(
+	title string,
+	body string,
+	authorId int,
+	categoryID int,
+	kind string,
+	visibility string,
+	groupID int,
+	tags []string,
+	poll *Poll,
+	scheduledAt time.Time,
+) (int, int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, 0, fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
 	stmt := `
-		INSERT INTO threads (title, author_id, date_added)
-		VALUES (?, ?, CURRENT_TIMESTAMP)
+		INSERT INTO threads (title, author_id, category_id, kind, visibility, group_id, scheduled_at, date_added)
+		VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`
+	if visibility != VisibilityGroup {
+		groupID = 0
+	}
+	var scheduled sql.NullString
+	if !scheduledAt.IsZero() {
+		scheduled = sql.NullString{String: sqlTime(scheduledAt), Valid: true}
+	}
+	result, err := tx.Exec(
+		stmt, title, authorId, nullID(categoryID), kind, visibility, nullID(groupID), scheduled,
+	)
+	if err != nil {
+		return 0, 0, fmt.Errorf("inserting new thread in db: %w", err)
+	}
+	threadID, err := result.LastInsertId()
+	if err != nil {
+		return 0, 0, fmt.Errorf("getting last thread id: %w", err)
+	}
+
+	stmt = `
+		INSERT INTO messages (body, thread_id, author_id, is_opening, date_added)
+		VALUES (?, ?, ?, TRUE, CURRENT_TIMESTAMP)
 	`
-	result, err := m.DB.Exec(stmt, title, authorId)
+	result, err = tx.Exec(stmt, body, threadID, authorId)
+	if err != nil {
+		return 0, 0, fmt.Errorf("inserting opening post in db: %w", err)
+	}
+	messageID, err := result.LastInsertId()
+	if err != nil {
+		return 0, 0, fmt.Errorf("getting last message id: %w", err)
+	}
+
+	err = setThreadTags(tx, int(threadID), tags)
 	if err != nil {
-		return 0, fmt.Errorf("inserting new thread in db: %w", err)
+		return 0, 0, err
 	}
-	id, err := result.LastInsertId()
+
+	if poll != nil {
+		err = insertPoll(tx, int(threadID), poll)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	err = tx.Commit()
 	if err != nil {
-		return 0, fmt.Errorf("getting last thread id: %w", err)
+		return 0, 0, fmt.Errorf("committing transaction: %w", err)
 	}
-	return int(id), nil
+	return int(threadID), int(messageID), nil
 }
 
-// Get retrieves the thread with the given id from the database.
-func (m *ThreadModel) Get(id int) (*Thread, error) {
+// Get retrieves the thread with the given id from the database, if the user
+// with the given id can read it. It returns ErrNoRecord otherwise, so that
+// hidden threads cannot be told apart from missing ones. Authors can read
+// their scheduled threads.
+func (m *ThreadModel) Get(id, userID int) (*Thread, error) {
+	visible, args := readableBy(userID)
 	stmt := `
-		SELECT t.id, t.title, t.date_added, u.id, u.username, u.email
-		FROM threads T, users u
-		WHERE t.author_id = u.id AND t.id = ?
-	`
-	row := m.DB.QueryRow(stmt, id)
+		SELECT t.id, t.title, t.score, t.visibility, t.kind, coalesce(t.accepted_message_id, 0),
+		       t.scheduled_at, t.date_added,
+		       u.id, u.username, u.slug, u.email, u.avatar_version,
+		       coalesce(c.id, 0), coalesce(c.name, ''), coalesce(c.slug, ''),
+		       coalesce(g.id, 0), coalesce(g.name, ''), coalesce(g.slug, '')
+		FROM threads t
+		JOIN users u ON t.author_id = u.id
+		LEFT JOIN categories c ON c.id = t.category_id
+		LEFT JOIN groups g ON g.id = t.group_id
+		WHERE t.id = ? AND ` + visible
+	row := m.DB.QueryRow(stmt, append([]any{id}, args...)...)
 	t, err := m.newThread(row, "ASC")
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
 		return nil, fmt.Errorf("creating new thread: %w", err)
 	}
+	err = m.loadTags([]*Thread{t})
+	if err != nil {
+		return nil, err
+	}
 	return t, nil
 }
 
-// Latests retrieves the 10 latests threads from the database.
-func (m *ThreadModel) Latests() ([]*Thread, error) {
+// VisibleTo reports whether the user with the given id can read the thread
+// with the given id.
+func (m *ThreadModel) VisibleTo(threadID, userID int) (bool, error) {
+	visible, args := visibleTo(userID)
+	stmt := `SELECT EXISTS(SELECT 1 FROM threads t WHERE t.id = ? AND ` + visible + `)`
+	var ok bool
+	err := m.DB.QueryRow(stmt, append([]any{threadID}, args...)...).Scan(&ok)
+	if err != nil {
+		return false, fmt.Errorf("querying database: %w", err)
+	}
+	return ok, nil
+}
+
+// SetVisibility changes who can read a thread. groupID is only used by group
+// restricted threads.
+func (m *ThreadModel) SetVisibility(threadID int, visibility string, groupID int) error {
+	if visibility != VisibilityGroup {
+		groupID = 0
+	}
+	stmt := `UPDATE threads SET visibility = ?, group_id = ? WHERE id = ?`
+	_, err := m.DB.Exec(stmt, visibility, nullID(groupID), threadID)
+	if err != nil {
+		return fmt.Errorf("updating thread visibility: %w", err)
+	}
+	return nil
+}
+
+// count returns the number of threads matching filter, a SQL condition on
+// the threads table t using args, that the user with the given id can read.
+func (m *ThreadModel) count(userID int, filter string, args ...any) (int, error) {
+	visible, visibleArgs := visibleTo(userID)
+	stmt := `SELECT count(*) FROM threads t WHERE (` + filter + `) AND ` + visible
+	var n int
+	err := m.DB.QueryRow(stmt, append(args, visibleArgs...)...).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("counting threads: %w", err)
+	}
+	return n, nil
+}
+
+// Latests retrieves the 10 latests threads from the database, along with
+// their unread state for the given user. userID is zero for anonymous users.
+func (m *ThreadModel) Latests(userID int) ([]*Thread, error) {
+	threads, err := m.list(userID, "", orderLatest, 10, 0)
+	if err != nil {
+		return nil, fmt.Errorf("getting latests threads: %w", err)
+	}
+	return threads, nil
+}
+
+// Sort is an order thread listings can be sorted in.
+type Sort string
+
+const (
+	SortLatest Sort = "latest" // newest first
+	SortTop    Sort = "top"    // highest score first
+	SortHot    Sort = "hot"    // score decayed by age
+	SortActive Sort = "active" // most messages posted recently
+)
+
+// Windows maps the time windows the top and active sorts can look at to the
+// SQLite date modifier of their start. "all" has no start.
+var Windows = map[string]string{
+	"day":   "-1 day",
+	"week":  "-7 days",
+	"month": "-1 month",
+	"year":  "-1 year",
+	"all":   "",
+}
+
+// orderLatest sorts thread listings newest first.
+const orderLatest = "t.date_added DESC, t.id DESC"
+
+// hoursOld is the age of a thread in hours, as a SQL expression.
+const hoursOld = "((julianday('now') - julianday(t.date_added)) * 24)"
+
+// sortFilter returns the SQL condition and ORDER BY clause listing threads
+// in the given sort over the given window, which must be a key of Windows.
+// Top threads are the best scored ones created in the window, and active
+// threads the ones with the most messages posted in it. Hot threads are
+// ranked by score divided by the square of their age, so new threads get a
+// chance to rise before old popular ones.
+func sortFilter(sort Sort, window string) (filter, order string, err error) {
+	since, ok := Windows[window]
+	if !ok {
+		return "", "", fmt.Errorf("unknown window %q", window)
+	}
+	// recent is a condition on the date_added column of the table named by
+	// its argument.
+	recent := func(table string) string {
+		if since == "" {
+			return "1"
+		}
+		return fmt.Sprintf("%s.date_added >= datetime('now', '%s')", table, since)
+	}
+
+	switch sort {
+	case SortLatest:
+		return "1", orderLatest, nil
+	case SortTop:
+		return recent("t"), "t.score DESC, " + orderLatest, nil
+	case SortHot:
+		order = fmt.Sprintf("(t.score + 1.0) / (%[1]s + 2) / (%[1]s + 2) DESC, %[2]s", hoursOld, orderLatest)
+		return "1", order, nil
+	case SortActive:
+		count := fmt.Sprintf("(SELECT count(*) FROM messages rm WHERE rm.thread_id = t.id AND %s)", recent("rm"))
+		return count + " > 0", count + " DESC, " + orderLatest, nil
+	}
+	return "", "", fmt.Errorf("unknown sort %q", sort)
+}
+
+// Sorted retrieves a page of every thread in the given sort over the given
+// time window, along with their unread state for the given user. Only
+// questions without an accepted answer are listed if unanswered is set.
+func (m *ThreadModel) Sorted(sort Sort, window string, unanswered bool, userID, limit, offset int) ([]*Thread, error) {
+	filter, order, err := sortFilter(sort, window)
+	if err != nil {
+		return nil, err
+	}
+	if unanswered {
+		filter = "(" + filter + ") AND " + unansweredFilter
+	}
+	threads, err := m.list(userID, filter, order, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("getting %s threads: %w", sort, err)
+	}
+	return threads, nil
+}
+
+// CountSorted returns the number of threads Sorted pages through.
+func (m *ThreadModel) CountSorted(sort Sort, window string, unanswered bool, userID int) (int, error) {
+	filter, _, err := sortFilter(sort, window)
+	if err != nil {
+		return 0, err
+	}
+	if unanswered {
+		filter = "(" + filter + ") AND " + unansweredFilter
+	}
+	return m.count(userID, filter)
+}
+
+// ByCategory retrieves a page of the threads in a category, newest first,
+// along with their unread state for the given user. Only questions without an
+// accepted answer are listed if unanswered is set.
+func (m *ThreadModel) ByCategory(categoryID int, unanswered bool, userID, limit, offset int) ([]*Thread, error) {
+	threads, err := m.list(userID, categoryFilter(unanswered), orderLatest, limit, offset, categoryID)
+	if err != nil {
+		return nil, fmt.Errorf("getting threads by category: %w", err)
+	}
+	return threads, nil
+}
+
+// ByTags retrieves a page of the threads tagged with all of the tags with the
+// given slugs if matchAll is set, or with any of them otherwise, newest first.
+func (m *ThreadModel) ByTags(slugs []string, matchAll bool, userID, limit, offset int) ([]*Thread, error) {
+	filter, args := tagFilter(slugs, matchAll)
+	threads, err := m.list(userID, filter, orderLatest, limit, offset, args...)
+	if err != nil {
+		return nil, fmt.Errorf("getting threads by tags: %w", err)
+	}
+	return threads, nil
+}
+
+// CountByTags returns the number of threads ByTags pages through.
+func (m *ThreadModel) CountByTags(slugs []string, matchAll bool, userID int) (int, error) {
+	filter, args := tagFilter(slugs, matchAll)
+	return m.count(userID, filter, args...)
+}
+
+// tagFilter returns the SQL condition on the threads table t selecting the
+// threads tagged with all or any of the given tags, along with its args.
+func tagFilter(slugs []string, matchAll bool) (string, []any) {
+	args := make([]any, 0, len(slugs)+1)
+	for _, slug := range slugs {
+		args = append(args, slug)
+	}
+	filter := fmt.Sprintf(
+		`t.id IN (
+			SELECT tt.thread_id FROM thread_tags tt JOIN tags g ON g.id = tt.tag_id
+			WHERE g.slug IN (%s)
+			GROUP BY tt.thread_id
+			HAVING count(*) >= ?
+		)`,
+		strings.TrimSuffix(strings.Repeat("?, ", len(slugs)), ", "),
+	)
+	if matchAll {
+		args = append(args, len(slugs))
+	} else {
+		args = append(args, 1)
+	}
+	return filter, args
+}
+
+// CountByCategory returns the number of threads ByCategory pages through.
+func (m *ThreadModel) CountByCategory(categoryID int, unanswered bool, userID int) (int, error) {
+	return m.count(userID, categoryFilter(unanswered), categoryID)
+}
+
+// categoryFilter returns the SQL condition on the threads table t selecting
+// the threads of the category given as its only arg.
+func categoryFilter(unanswered bool) string {
+	if unanswered {
+		return "t.category_id = ? AND " + unansweredFilter
+	}
+	return "t.category_id = ?"
+}
+
+// SetAccepted marks the message with the given id as the accepted answer of
+// a question, or unmarks it if messageID is zero. The message must belong to
+// the thread.
+func (m *ThreadModel) SetAccepted(threadID, messageID int) error {
+	stmt := `UPDATE threads SET accepted_message_id = ? WHERE id = ? AND kind = 'question'`
+	_, err := m.DB.Exec(stmt, nullID(messageID), threadID)
+	if err != nil {
+		return fmt.Errorf("setting accepted answer: %w", err)
+	}
+	return nil
+}
+
+// Scheduled retrieves the threads of the given author that are still waiting
+// to be published, soonest first, with their opening post as Messages.
+func (m *ThreadModel) Scheduled(authorID int) ([]*Thread, error) {
 	stmt := `
-		SELECT t.id, t.title, t.date_added, u.id, u.username, u.email
-		FROM threads t, users u
-		WHERE t.author_id = u.id
-		ORDER BY t.date_added DESC
-		LIMIT 10
+		SELECT t.id, t.title, t.scheduled_at, msg.id, msg.body
+		FROM threads t
+		JOIN messages msg ON msg.thread_id = t.id AND msg.is_opening
+		WHERE t.author_id = ? AND t.scheduled_at IS NOT NULL
+		ORDER BY t.scheduled_at, t.id
 	`
+	rows, err := m.DB.Query(stmt, authorID)
+	if err != nil {
+		return nil, fmt.Errorf("querying scheduled threads: %w", err)
+	}
+	defer rows.Close()
+
+	var threads []*Thread
+	for rows.Next() {
+		var (
+			t   Thread
+			msg Message
+		)
+		err := rows.Scan(&t.ID, &t.Title, &t.ScheduledAt, &msg.ID, &msg.Body)
+		if err != nil {
+			return nil, fmt.Errorf("scanning scheduled thread row: %w", err)
+		}
+		msg.ThreadID, msg.ThreadTitle = t.ID, t.Title
+		t.Messages = []*Message{&msg}
+		threads = append(threads, &t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over scheduled thread rows: %w", err)
+	}
+	return threads, nil
+}
+
+// Reschedule changes the title, opening post and publish time of a scheduled
+// thread of the given author. It returns ErrNoRecord if there is no such
+// thread, for instance because it was published in the meantime.
+func (m *ThreadModel) Reschedule(threadID, authorID int, title, body string, at time.Time) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt := `
+		UPDATE threads SET title = ?, scheduled_at = ?
+		WHERE id = ? AND author_id = ? AND scheduled_at IS NOT NULL
+	`
+	result, err := tx.Exec(stmt, title, sqlTime(at), threadID, authorID)
+	if err != nil {
+		return fmt.Errorf("updating scheduled thread: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("getting affected rows: %w", err)
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	stmt = `
+		UPDATE messages SET body = ?, revision = revision + 1
+		WHERE thread_id = ? AND is_opening AND body != ?
+	`
+	_, err = tx.Exec(stmt, body, threadID, body)
+	if err != nil {
+		return fmt.Errorf("updating opening post: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// PublishDue publishes the scheduled threads whose publish time is not after
+// now, and returns their ids and authors. Published threads and their opening
+// posts are dated from now, so that they show up as new. Since the schedule
+// lives in the database, threads that came due while the server was down are
+// published on the next call. Published threads are also flagged as pending
+// until ClearPublishedPending is called, so that the work that follows their
+// publication is not lost if it fails.
+func (m *ThreadModel) PublishDue(now time.Time) ([]*Thread, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt := `
+		UPDATE threads SET scheduled_at = NULL, published_pending = TRUE, date_added = ?
+		WHERE scheduled_at <= ?
+		RETURNING id, author_id
+	`
+	rows, err := tx.Query(stmt, sqlTime(now), sqlTime(now))
+	if err != nil {
+		return nil, fmt.Errorf("publishing scheduled threads: %w", err)
+	}
+	defer rows.Close()
+
+	var threads []*Thread
+	for rows.Next() {
+		t := Thread{Author: &User{}}
+		err := rows.Scan(&t.ID, &t.Author.ID)
+		if err != nil {
+			return nil, fmt.Errorf("scanning published thread row: %w", err)
+		}
+		threads = append(threads, &t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over published thread rows: %w", err)
+	}
+	rows.Close()
+
+	stmt = `UPDATE messages SET date_added = ? WHERE thread_id = ? AND is_opening`
+	for _, t := range threads {
+		_, err = tx.Exec(stmt, sqlTime(now), t.ID)
+		if err != nil {
+			return nil, fmt.Errorf("dating opening post: %w", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("committing transaction: %w", err)
+	}
+	return threads, nil
+}
+
+// PublishedPending returns the ids and authors of the published threads
+// still flagged as pending, oldest first.
+func (m *ThreadModel) PublishedPending() ([]*Thread, error) {
+	stmt := `SELECT id, author_id FROM threads WHERE published_pending ORDER BY id`
 	rows, err := m.DB.Query(stmt)
 	if err != nil {
-		return nil, fmt.Errorf("getting latests threads: %w", err)
+		return nil, fmt.Errorf("querying pending threads: %w", err)
 	}
 	defer rows.Close()
 
 	var threads []*Thread
 	for rows.Next() {
-		t, err := m.newThread(rows, "DESC")
+		t := Thread{Author: &User{}}
+		err := rows.Scan(&t.ID, &t.Author.ID)
 		if err != nil {
-			return nil, fmt.Errorf("creating thread: %w", err)
+			return nil, fmt.Errorf("scanning pending thread row: %w", err)
 		}
-		threads = append(threads, t)
+		threads = append(threads, &t)
 	}
 	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("iterating over rows for latests threads: %w", err)
+		return nil, fmt.Errorf("iterating over pending thread rows: %w", err)
 	}
+	return threads, nil
+}
 
+// ClearPublishedPending records that the work following the publication of
+// a thread is done.
+func (m *ThreadModel) ClearPublishedPending(id int) error {
+	stmt := `UPDATE threads SET published_pending = FALSE WHERE id = ?`
+	_, err := m.DB.Exec(stmt, id)
+	if err != nil {
+		return fmt.Errorf("clearing pending flag: %w", err)
+	}
+	return nil
+}
+
+// DeleteScheduled deletes a scheduled thread of the given author along with
+// everything attached to it, and returns its title and opening post. It
+// returns ErrNoRecord if there is no such thread. Scheduled threads cannot be
+// replied to, so the opening post is their only message.
+func (m *ThreadModel) DeleteScheduled(threadID, authorID int) (string, string, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return "", "", fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var title, body string
+	stmt := `
+		SELECT t.title, msg.body
+		FROM threads t
+		JOIN messages msg ON msg.thread_id = t.id AND msg.is_opening
+		WHERE t.id = ? AND t.author_id = ? AND t.scheduled_at IS NOT NULL
+	`
+	err = tx.QueryRow(stmt, threadID, authorID).Scan(&title, &body)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", "", ErrNoRecord
+		}
+		return "", "", fmt.Errorf("querying scheduled thread: %w", err)
+	}
+
+	const messages = `(SELECT id FROM messages WHERE thread_id = ?)`
+	const polls = `(SELECT id FROM polls WHERE thread_id = ?)`
+	stmts := []string{
+		`DELETE FROM reactions WHERE message_id IN ` + messages,
+		`DELETE FROM message_votes WHERE message_id IN ` + messages,
+		`DELETE FROM mentions WHERE message_id IN ` + messages,
+		`DELETE FROM poll_votes WHERE poll_id IN ` + polls,
+		`DELETE FROM poll_ballots WHERE poll_id IN ` + polls,
+		`DELETE FROM poll_options WHERE poll_id IN ` + polls,
+		`DELETE FROM polls WHERE thread_id = ?`,
+		`DELETE FROM bookmarks WHERE thread_id = ?`,
+		`DELETE FROM drafts WHERE thread_id = ?`,
+		`DELETE FROM notifications WHERE thread_id = ?`,
+		`DELETE FROM subscriptions WHERE thread_id = ?`,
+		`DELETE FROM thread_reads WHERE thread_id = ?`,
+		`DELETE FROM thread_votes WHERE thread_id = ?`,
+		`DELETE FROM thread_tags WHERE thread_id = ?`,
+		`DELETE FROM messages WHERE thread_id = ?`,
+		`DELETE FROM threads WHERE id = ?`,
+	}
+	for _, stmt := range stmts {
+		_, err = tx.Exec(stmt, threadID)
+		if err != nil {
+			return "", "", fmt.Errorf("deleting scheduled thread: %w", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", "", fmt.Errorf("committing transaction: %w", err)
+	}
+	return title, body, nil
+}
+
+// ByAuthor retrieves the latest threads created by the given user that the
+// user with id viewerID can read.
+func (m *ThreadModel) ByAuthor(authorID, viewerID, limit int) ([]*Thread, error) {
+	threads, err := m.list(viewerID, "t.author_id = ?", orderLatest, limit, 0, authorID)
+	if err != nil {
+		return nil, fmt.Errorf("getting threads by author: %w", err)
+	}
 	return threads, nil
 }
 
+// list retrieves a page of the threads matching filter, a SQL condition on
+// the threads table t using args, sorted by order, an ORDER BY clause without
+// placeholders. Only threads the user with the given id can read are listed.
+// Categories, message counts, the latest message and the unread state of each
+// thread are loaded in the same query.
+func (m *ThreadModel) list(userID int, filter, order string, limit, offset int, args ...any) ([]*Thread, error) {
+	if filter == "" {
+		filter = "1"
+	}
+	visible, visibleArgs := visibleTo(userID)
+	stmt := fmt.Sprintf(
+		`
+			SELECT t.id, t.title, t.score, t.visibility, t.kind, coalesce(t.accepted_message_id, 0), t.date_added,
+			       u.id, u.username, u.slug, u.email, u.avatar_version,
+			       coalesce(c.id, 0), coalesce(c.name, ''), coalesce(c.slug, ''),
+			       (SELECT count(*) FROM messages WHERE thread_id = t.id),
+			       coalesce(lm.id, 0), coalesce(lm.body, ''),
+			       coalesce(lu.id, 0), coalesce(lu.username, ''), coalesce(lu.slug, ''), coalesce(lu.email, ''),
+			       coalesce(lu.avatar_version, 0),
+			       r.user_id IS NOT NULL,
+			       (
+			           SELECT count(*) FROM messages um
+			           WHERE um.thread_id = t.id AND um.author_id != ?
+			             AND um.id > coalesce(r.last_read_message_id, 0)
+			       ),
+			       (
+			           SELECT coalesce(min(um.id), 0) FROM messages um
+			           WHERE um.thread_id = t.id AND um.author_id != ?
+			             AND um.id > coalesce(r.last_read_message_id, 0)
+			       )
+			FROM threads t
+			JOIN users u ON u.id = t.author_id
+			LEFT JOIN categories c ON c.id = t.category_id
+			LEFT JOIN messages lm ON lm.id = (
+			    SELECT id FROM messages WHERE thread_id = t.id
+			    ORDER BY date_added DESC, id DESC LIMIT 1
+			)
+			LEFT JOIN users lu ON lu.id = lm.author_id
+			LEFT JOIN thread_reads r ON r.thread_id = t.id AND r.user_id = ?
+			WHERE (%s) AND %s
+			ORDER BY %s
+			LIMIT ? OFFSET ?
+		`,
+		filter, visible, order,
+	)
+	args = append([]any{userID, userID, userID}, args...)
+	args = append(args, visibleArgs...)
+	args = append(args, limit, offset)
+
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying database: %w", err)
+	}
+	defer rows.Close()
+
+	var threads []*Thread
+	for rows.Next() {
+		var (
+			t    Thread
+			u    User
+			c    Category
+			lm   Message
+			seen bool
+		)
+		err := rows.Scan(
+			&t.ID, &t.Title, &t.Score, &t.Visibility, &t.Kind, &t.AcceptedID, &t.DateAdded,
+			&u.ID, &u.Username, &u.Slug, &u.Email, &u.AvatarVersion,
+			&c.ID, &c.Name, &c.Slug,
+			&t.MessageCount,
+			&lm.ID, &lm.Body,
+			&lm.Author.ID, &lm.Author.Username, &lm.Author.Slug, &lm.Author.Email,
+			&lm.Author.AvatarVersion,
+			&seen, &t.Unread, &t.FirstUnreadID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("scanning thread row: %w", err)
+		}
+		t.Author = &u
+		if c.ID != 0 {
+			t.Category = &c
+		}
+		if lm.ID != 0 {
+			lm.ThreadID = t.ID
+			t.LatestMessage = &lm
+		}
+		if userID == 0 {
+			t.Unread, t.FirstUnreadID = 0, 0
+		} else {
+			t.IsNew = !seen
+		}
+		threads = append(threads, &t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over thread rows: %w", err)
+	}
+
+	err = m.loadTags(threads)
+	if err != nil {
+		return nil, err
+	}
+	return threads, nil
+}
+
+// loadTags sets the tags of the given threads with a single query.
+func (m *ThreadModel) loadTags(threads []*Thread) error {
+	if len(threads) == 0 {
+		return nil
+	}
+	byID := make(map[int]*Thread, len(threads))
+	args := make([]any, 0, len(threads))
+	for _, t := range threads {
+		byID[t.ID] = t
+		args = append(args, t.ID)
+	}
+
+	stmt := fmt.Sprintf(
+		`
+			SELECT tt.thread_id, g.id, g.name, g.slug
+			FROM thread_tags tt JOIN tags g ON g.id = tt.tag_id
+			WHERE tt.thread_id IN (%s)
+			ORDER BY g.name
+		`,
+		strings.TrimSuffix(strings.Repeat("?, ", len(threads)), ", "),
+	)
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return fmt.Errorf("getting thread tags: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			threadID int
+			tag      Tag
+		)
+		err := rows.Scan(&threadID, &tag.ID, &tag.Name, &tag.Slug)
+		if err != nil {
+			return fmt.Errorf("scanning tag row: %w", err)
+		}
+		t := byID[threadID]
+		t.Tags = append(t.Tags, &tag)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("iterating over tag rows: %w", err)
+	}
+	return nil
+}
+
 // scanner implements the Scan function.
 type scanner interface {
 	Scan(dest ...any) error
@@ -118,21 +852,39 @@ type scanner interface {
 // the Thread's author, and the Messages associated with that Thread.
 func (m *ThreadModel) newThread(s scanner, messageOrder string) (*Thread, error) {
 	var (
-		t Thread
-		u User
+		t         Thread
+		u         User
+		c         Category
+		g         Group
+		scheduled sql.NullTime
 	)
 	err := s.Scan(
-		&t.ID, &t.Title, &t.DateAdded,
-		&u.ID, &u.Username, &u.Email,
+		&t.ID, &t.Title, &t.Score, &t.Visibility, &t.Kind, &t.AcceptedID, &scheduled, &t.DateAdded,
+		&u.ID, &u.Username, &u.Slug, &u.Email, &u.AvatarVersion,
+		&c.ID, &c.Name, &c.Slug,
+		&g.ID, &g.Name, &g.Slug,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("scanning row: %w", err)
 	}
 	t.Author = &u
+	t.ScheduledAt = scheduled.Time
+	if c.ID != 0 {
+		t.Category = &c
+	}
+	if g.ID != 0 {
+		t.Group = &g
+	}
 	t.Messages, err = m.getMessages(t.ID, messageOrder)
 	if err != nil {
 		return nil, fmt.Errorf("getting messages with thread id %v: %w", t.ID, err)
 	}
+	for _, msg := range t.Messages {
+		if msg.ID == t.AcceptedID {
+			msg.IsAccepted = true
+			t.Accepted = msg
+		}
+	}
 	return &t, nil
 }
 
@@ -141,7 +893,8 @@ func (m *ThreadModel) newThread(s scanner, messageOrder string) (*Thread, error)
 func (m *ThreadModel) getMessages(threadID int, order string) ([]*Message, error) {
 	stmt := fmt.Sprintf(
 		`
-			SELECT m.id, m.body, m.date_added, u.id, u.username, u.email
+			SELECT m.id, m.body, m.revision, m.is_opening, coalesce(m.reply_to_id, 0), m.score, m.date_added,
+			       u.id, u.username, u.slug, u.email, u.avatar_version
 			FROM messages m, users u
 			WHERE m.author_id = u.id AND m.thread_id = ?
 			ORDER BY m.date_added %v
@@ -161,18 +914,26 @@ func (m *ThreadModel) getMessages(threadID int, order string) ([]*Message, error
 			u User
 		)
 		err := rows.Scan(
-			&m.ID, &m.Body, &m.DateAdded,
-			&u.ID, &u.Username, &u.Email,
+			&m.ID, &m.Body, &m.Revision, &m.IsOpening, &m.ReplyToID, &m.Score, &m.DateAdded,
+			&u.ID, &u.Username, &u.Slug, &u.Email, &u.AvatarVersion,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("scanning message row: %w", err)
 		}
 		m.Author = u
+		m.ThreadID = threadID
 		messages = append(messages, &m)
 	}
 	if err = rows.Err(); err != nil {
 		return nil, fmt.Errorf("iterating over message rows: %w", err)
 	}
 
+	byID := make(map[int]*Message, len(messages))
+	for _, m := range messages {
+		byID[m.ID] = m
+	}
+	for _, m := range messages {
+		m.ReplyTo = byID[m.ReplyToID]
+	}
 	return messages, nil
 }
@@ -4,16 +4,61 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Roles a user can have. Moderators can manage tags, administrators can also
+// manage categories.
+const (
+	RoleMember    = "member"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
 // User holds data about a user.
 type User struct {
-	ID       int
-	Username string
-	Email    string
-	Password []byte
+	ID           int
+	Username     string
+	Slug         string
+	Email        string
+	Password     []byte
+	Bio          string
+	DateJoined   time.Time
+	ShowEmail    bool
+	ShowActivity bool
+
+	// EmailMentions is set if the user wants an email when @mentioned.
+	EmailMentions bool
+
+	// EmailFrequency is how often the user is emailed about new messages in
+	// the threads they subscribed to: one of the Email* constants.
+	EmailFrequency string
+
+	// AvatarVersion is incremented on every avatar upload and is zero when
+	// the user has no uploaded avatar.
+	AvatarVersion int
+
+	// Role is one of the Role* constants.
+	Role string
+}
+
+// IsAdmin reports whether the user is an administrator.
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// IsModerator reports whether the user is a moderator or an administrator.
+func (u *User) IsModerator() bool {
+	return u.Role == RoleModerator || u.Role == RoleAdmin
+}
+
+// UserStats holds the post counts of a user.
+type UserStats struct {
+	Threads  int
+	Messages int
 }
 
 // UserModel holds a database handle for manipulating users.
@@ -66,11 +111,15 @@ func (m *UserModel) InsertUser(
 	if err != nil {
 		return 0, fmt.Errorf("generating hashed password: %w", err)
 	}
+	slug, err := m.uniqueSlug(username)
+	if err != nil {
+		return 0, fmt.Errorf("generating slug: %w", err)
+	}
 	stmt := `
-		INSERT INTO users (username, email, password) 
-		VALUES (?, ?, ?)
+		INSERT INTO users (username, slug, email, password, date_joined) 
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
 	`
-	result, err := m.DB.Exec(stmt, username, email, string(hashedPassword))
+	result, err := m.DB.Exec(stmt, username, slug, email, string(hashedPassword))
 	if err != nil {
 		return 0, fmt.Errorf("inserting row into database: %w", err)
 	}
@@ -127,13 +176,34 @@ func (m *UserModel) emailExists(address string) (bool, error) {
 // GetUser will return a user based on id.
 func (m *UserModel) GetUser(id int) (*User, error) {
 	stmt := `
-		SELECT id, username, email
+		SELECT id, username, slug, email, bio, date_joined, show_email, show_activity,
+		       email_mentions, email_frequency, avatar_version, role
 		FROM users
 		WHERE id = ?
 	`
-	row := m.DB.QueryRow(stmt, id)
+	return m.getUser(stmt, id)
+}
+
+// GetBySlug will return a user based on its profile slug.
+func (m *UserModel) GetBySlug(slug string) (*User, error) {
+	stmt := `
+		SELECT id, username, slug, email, bio, date_joined, show_email, show_activity,
+		       email_mentions, email_frequency, avatar_version, role
+		FROM users
+		WHERE slug = ?
+	`
+	return m.getUser(stmt, slug)
+}
+
+// getUser runs a query selecting a single user and scans the result.
+func (m *UserModel) getUser(stmt string, args ...any) (*User, error) {
+	row := m.DB.QueryRow(stmt, args...)
 	var u User
-	err := row.Scan(&u.ID, &u.Username, &u.Email)
+	err := row.Scan(
+		&u.ID, &u.Username, &u.Slug, &u.Email,
+		&u.Bio, &u.DateJoined, &u.ShowEmail, &u.ShowActivity,
+		&u.EmailMentions, &u.EmailFrequency, &u.AvatarVersion, &u.Role,
+	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNoRecord
@@ -143,3 +213,148 @@ func (m *UserModel) GetUser(id int) (*User, error) {
 	return &u, nil
 }
 
+// GetByNames returns the users whose username, compared case-insensitively,
+// or profile slug is one of names.
+func (m *UserModel) GetByNames(names []string) ([]*User, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	args := make([]any, 0, 2*len(names))
+	for _, name := range names {
+		args = append(args, strings.ToLower(name))
+	}
+	args = append(args, args...)
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")
+
+	stmt := fmt.Sprintf(
+		`
+			SELECT id, username, slug, email, email_mentions
+			FROM users
+			WHERE lower(username) IN (%s) OR slug IN (%s)
+		`,
+		placeholders, placeholders,
+	)
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying database: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		var u User
+		err := rows.Scan(&u.ID, &u.Username, &u.Slug, &u.Email, &u.EmailMentions)
+		if err != nil {
+			return nil, fmt.Errorf("scanning user row: %w", err)
+		}
+		users = append(users, &u)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over user rows: %w", err)
+	}
+
+	return users, nil
+}
+
+// UpdateProfile updates the public profile fields and notification
+// preferences of a user.
+func (m *UserModel) UpdateProfile(
+	id int,
+	bio string,
+	showEmail bool,
+	showActivity bool,
+	emailMentions bool,
+	emailFrequency string,
+) error {
+	stmt := `
+		UPDATE users
+		SET bio = ?, show_email = ?, show_activity = ?, email_mentions = ?, email_frequency = ?
+		WHERE id = ?
+	`
+	_, err := m.DB.Exec(stmt, bio, showEmail, showActivity, emailMentions, emailFrequency, id)
+	if err != nil {
+		return fmt.Errorf("updating profile: %w", err)
+	}
+	return nil
+}
+
+// SetAvatar records that a new avatar was uploaded for a user.
+func (m *UserModel) SetAvatar(id int) error {
+	stmt := `UPDATE users SET avatar_version = avatar_version + 1 WHERE id = ?`
+	_, err := m.DB.Exec(stmt, id)
+	if err != nil {
+		return fmt.Errorf("updating avatar version: %w", err)
+	}
+	return nil
+}
+
+// ClearAvatar records that a user removed their uploaded avatar.
+func (m *UserModel) ClearAvatar(id int) error {
+	stmt := `UPDATE users SET avatar_version = 0 WHERE id = ?`
+	_, err := m.DB.Exec(stmt, id)
+	if err != nil {
+		return fmt.Errorf("clearing avatar version: %w", err)
+	}
+	return nil
+}
+
+// Stats returns the number of threads and messages posted by a user that
+// the user with id viewerID can read, so that counts do not give away
+// restricted or scheduled threads.
+func (m *UserModel) Stats(id, viewerID int) (*UserStats, error) {
+	visible, visibleArgs := visibleTo(viewerID)
+	stmt := `
+		SELECT
+		    (SELECT COUNT(*) FROM threads t WHERE t.author_id = ? AND ` + visible + `),
+		    (
+		        SELECT COUNT(*) FROM messages m JOIN threads t ON t.id = m.thread_id
+		        WHERE m.author_id = ? AND ` + visible + `
+		    )
+	`
+	args := append([]any{id}, visibleArgs...)
+	args = append(append(args, id), visibleArgs...)
+	var s UserStats
+	err := m.DB.QueryRow(stmt, args...).Scan(&s.Threads, &s.Messages)
+	if err != nil {
+		return nil, fmt.Errorf("counting posts: %w", err)
+	}
+	return &s, nil
+}
+
+// uniqueSlug derives a profile slug from a username, appending a numeric
+// suffix if the slug is already taken by another user.
+func (m *UserModel) uniqueSlug(username string) (string, error) {
+	base := Slugify(username)
+	if base == "" {
+		base = "user"
+	}
+	slug := base
+	for i := 2; ; i++ {
+		var exists bool
+		err := m.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE slug = ?)`, slug).Scan(&exists)
+		if err != nil {
+			return "", fmt.Errorf("querying database: %w", err)
+		}
+		if !exists {
+			return slug, nil
+		}
+		slug = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// Slugify lowercases s and replaces every run of characters that are not
+// ASCII letters or digits with a single hyphen.
+func Slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
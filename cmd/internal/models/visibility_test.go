@@ -0,0 +1,357 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// visibilityFixture holds a thread of each visibility and a scheduled thread,
+// all posted by the same author with one reply each.
+type visibilityFixture struct {
+	db *sql.DB
+
+	author      int
+	member      int // logged in, but not in the group
+	groupMember int
+
+	categoryID int
+	tagID      int
+
+	public    int
+	members   int
+	group     int
+	scheduled int
+}
+
+// viewer is a user along with the threads they can see in listings.
+type viewer struct {
+	name    string
+	id      int
+	threads []int
+}
+
+func newVisibilityFixture(t *testing.T) *visibilityFixture {
+	t.Helper()
+
+	db := newTestDB(t)
+	f := &visibilityFixture{db: db}
+	f.author = insertTestUser(t, db, "author")
+	f.member = insertTestUser(t, db, "member")
+	f.groupMember = insertTestUser(t, db, "insider")
+
+	groups := &GroupModel{DB: db}
+	groupID, err := groups.Insert("Staff", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = groups.AddMember(groupID, f.groupMember)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.categoryID, err = (&CategoryModel{DB: db}).Insert(&Category{Name: "General", Slug: "general"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	threads := &ThreadModel{DB: db}
+	messages := &MessageModel{DB: db}
+	insert := func(visibility string, tags []string, scheduledAt time.Time) int {
+		t.Helper()
+		id, _, err := threads.Insert(
+			"Thread", "Opening post", f.author, f.categoryID, KindDiscussion,
+			visibility, groupID, tags, nil, scheduledAt,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = messages.InsertMessage("Reply", id, f.author, 0, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	f.public = insert(VisibilityPublic, []string{"go"}, time.Time{})
+	f.members = insert(VisibilityMembers, []string{"go"}, time.Time{})
+	f.group = insert(VisibilityGroup, []string{"go", "secret"}, time.Time{})
+	f.scheduled = insert(VisibilityPublic, []string{"go"}, time.Now().Add(time.Hour))
+
+	tag, err := (&TagModel{DB: db}).GetBySlug("go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.tagID = tag.ID
+	return f
+}
+
+// viewers returns the users of the fixture along with the threads they can
+// see. Scheduled threads are left out of every listing, even for their
+// author.
+func (f *visibilityFixture) viewers() []viewer {
+	return []viewer{
+		{"anonymous", 0, []int{f.public}},
+		{"member", f.member, []int{f.public, f.members}},
+		{"group member", f.groupMember, []int{f.public, f.members, f.group}},
+		{"author", f.author, []int{f.public, f.members, f.group}},
+	}
+}
+
+// threadIDs returns the sorted ids of threads.
+func threadIDs(threads []*Thread) []int {
+	var ids []int
+	for _, t := range threads {
+		ids = append(ids, t.ID)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// messageThreadIDs returns the sorted ids of the threads messages belong to,
+// each listed once.
+func messageThreadIDs(messages []*Message) []int {
+	var ids []int
+	for _, m := range messages {
+		ids = append(ids, m.ThreadID)
+	}
+	slices.Sort(ids)
+	return slices.Compact(ids)
+}
+
+// checkThreadIDs fails the test if got and want do not hold the same ids.
+func checkThreadIDs(t *testing.T, got, want []int) {
+	t.Helper()
+
+	want = slices.Clone(want)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("got threads %v; want %v", got, want)
+	}
+}
+
+func TestThreadListingsVisibility(t *testing.T) {
+	f := newVisibilityFixture(t)
+	threads := &ThreadModel{DB: f.db}
+	messages := &MessageModel{DB: f.db}
+
+	for _, v := range f.viewers() {
+		t.Run(v.name, func(t *testing.T) {
+			latests, err := threads.Latests(v.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkThreadIDs(t, threadIDs(latests), v.threads)
+
+			sorted, err := threads.Sorted(SortLatest, "all", false, v.id, 50, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkThreadIDs(t, threadIDs(sorted), v.threads)
+
+			tagged, err := threads.ByTags([]string{"go"}, false, v.id, 50, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkThreadIDs(t, threadIDs(tagged), v.threads)
+
+			byAuthor, err := threads.ByAuthor(f.author, v.id, 50)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkThreadIDs(t, threadIDs(byAuthor), v.threads)
+
+			posts, err := messages.ByAuthor(f.author, v.id, 50)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkThreadIDs(t, messageThreadIDs(posts), v.threads)
+		})
+	}
+}
+
+func TestThreadGetVisibility(t *testing.T) {
+	f := newVisibilityFixture(t)
+	threads := &ThreadModel{DB: f.db}
+
+	for _, v := range f.viewers() {
+		t.Run(v.name, func(t *testing.T) {
+			readable := v.threads
+			if v.id == f.author {
+				// Authors can open their scheduled threads.
+				readable = append(slices.Clone(readable), f.scheduled)
+			}
+
+			for _, id := range []int{f.public, f.members, f.group, f.scheduled} {
+				want := slices.Contains(readable, id)
+				thread, err := threads.Get(id, v.id)
+				switch {
+				case want && err != nil:
+					t.Errorf("thread %d: got error %v", id, err)
+				case want && len(thread.Messages) != 2:
+					t.Errorf("thread %d: got %d messages; want 2", id, len(thread.Messages))
+				case !want && !errors.Is(err, ErrNoRecord):
+					t.Errorf("thread %d: got error %v; want ErrNoRecord", id, err)
+				}
+
+				visible, err := threads.VisibleTo(id, v.id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if visible != slices.Contains(v.threads, id) {
+					t.Errorf("thread %d: VisibleTo returned %t", id, visible)
+				}
+			}
+		})
+	}
+}
+
+func TestFeedVisibility(t *testing.T) {
+	f := newVisibilityFixture(t)
+	feeds := &FeedModel{DB: f.db}
+
+	// Feeds are read anonymously, so they only hold public threads.
+	tests := map[string]struct {
+		fetch func() ([]*Message, error)
+		want  []int
+	}{
+		"threads":   {func() ([]*Message, error) { return feeds.Threads(50) }, []int{f.public}},
+		"category":  {func() ([]*Message, error) { return feeds.Category(f.categoryID, 50) }, []int{f.public}},
+		"tag":       {func() ([]*Message, error) { return feeds.Tag(f.tagID, 50) }, []int{f.public}},
+		"user":      {func() ([]*Message, error) { return feeds.User(f.author, 50) }, []int{f.public}},
+		"public":    {func() ([]*Message, error) { return feeds.Thread(f.public, 50) }, []int{f.public}},
+		"members":   {func() ([]*Message, error) { return feeds.Thread(f.members, 50) }, nil},
+		"group":     {func() ([]*Message, error) { return feeds.Thread(f.group, 50) }, nil},
+		"scheduled": {func() ([]*Message, error) { return feeds.Thread(f.scheduled, 50) }, nil},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			messages, err := tt.fetch()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkThreadIDs(t, messageThreadIDs(messages), tt.want)
+		})
+	}
+}
+
+func TestBookmarkVisibility(t *testing.T) {
+	f := newVisibilityFixture(t)
+	bookmarks := &BookmarkModel{DB: f.db}
+
+	for _, v := range f.viewers() {
+		if v.id == 0 {
+			continue
+		}
+		t.Run(v.name, func(t *testing.T) {
+			// Bookmarks can outlive access to their thread, for instance
+			// when it is restricted after being bookmarked.
+			for _, id := range []int{f.public, f.members, f.group, f.scheduled} {
+				err := bookmarks.Save(v.id, id, 0, "")
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			saved, err := bookmarks.ForUser(v.id, "", 50, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []int
+			for _, b := range saved {
+				got = append(got, b.Thread.ID)
+			}
+			slices.Sort(got)
+			checkThreadIDs(t, got, v.threads)
+
+			n, err := bookmarks.CountForUser(v.id, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != len(v.threads) {
+				t.Errorf("got a count of %d; want %d", n, len(v.threads))
+			}
+		})
+	}
+}
+
+func TestDigestVisibility(t *testing.T) {
+	f := newVisibilityFixture(t)
+	subscriptions := &SubscriptionModel{DB: f.db}
+
+	for _, v := range f.viewers() {
+		// Digests only hold messages by others, and need an account.
+		if v.id == 0 || v.id == f.author {
+			continue
+		}
+		t.Run(v.name, func(t *testing.T) {
+			for _, id := range []int{f.public, f.members, f.group, f.scheduled} {
+				err := subscriptions.Subscribe(v.id, id)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			messages, err := subscriptions.DigestMessages(v.id, time.Now().Add(-time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkThreadIDs(t, messageThreadIDs(messages), v.threads)
+		})
+	}
+}
+
+func TestTagVisibility(t *testing.T) {
+	f := newVisibilityFixture(t)
+	tags := &TagModel{DB: f.db}
+
+	for _, v := range f.viewers() {
+		t.Run(v.name, func(t *testing.T) {
+			all, err := tags.All(v.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			counts := make(map[string]int)
+			for _, tag := range all {
+				counts[tag.Name] = tag.ThreadCount
+			}
+			if counts["go"] != len(v.threads) {
+				t.Errorf("got %d threads tagged go; want %d", counts["go"], len(v.threads))
+			}
+			_, listed := counts["secret"]
+			if listed != slices.Contains(v.threads, f.group) {
+				t.Errorf("secret tag listed: %t", listed)
+			}
+
+			found, err := tags.Search("se", v.id, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (len(found) > 0) != slices.Contains(v.threads, f.group) {
+				t.Errorf("got %d tags searching for se", len(found))
+			}
+		})
+	}
+}
+
+func TestUserStatsVisibility(t *testing.T) {
+	f := newVisibilityFixture(t)
+	users := &UserModel{DB: f.db}
+
+	for _, v := range f.viewers() {
+		t.Run(v.name, func(t *testing.T) {
+			stats, err := users.Stats(f.author, v.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.Threads != len(v.threads) || stats.Messages != 2*len(v.threads) {
+				t.Errorf(
+					"got %d threads and %d messages; want %d and %d",
+					stats.Threads, stats.Messages, len(v.threads), 2*len(v.threads),
+				)
+			}
+		})
+	}
+}
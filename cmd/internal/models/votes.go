@@ -0,0 +1,126 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// VoteModel holds a database handle for manipulating the up and down votes
+// on threads and messages. A user has at most one vote, 1 or -1, on each of
+// them. The score of a thread or message is the sum of its votes; it is
+// stored alongside it so listings can be sorted by score cheaply.
+type VoteModel struct {
+	DB *sql.DB
+}
+
+// voteTables describes where the votes and score of something that can be
+// voted on are stored.
+type voteTables struct {
+	votes  string // table of the votes
+	column string // column of the votes table referencing the target
+	target string // table holding the score
+}
+
+var (
+	threadVotes  = voteTables{"thread_votes", "thread_id", "threads"}
+	messageVotes = voteTables{"message_votes", "message_id", "messages"}
+)
+
+// VoteThread sets the user's vote on a thread to value: 1 for an up vote, -1
+// for a down vote and 0 to withdraw it. It returns the new score.
+func (m *VoteModel) VoteThread(threadID, userID, value int) (int, error) {
+	return m.vote(threadVotes, threadID, userID, value)
+}
+
+// VoteMessage sets the user's vote on a message to value: 1 for an up vote,
+// -1 for a down vote and 0 to withdraw it. It returns the new score.
+func (m *VoteModel) VoteMessage(messageID, userID, value int) (int, error) {
+	return m.vote(messageVotes, messageID, userID, value)
+}
+
+// vote records a vote, then recomputes the stored score from the votes table
+// in the same transaction, so concurrent votes never leave it out of sync.
+func (m *VoteModel) vote(t voteTables, id, userID, value int) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if value == 0 {
+		stmt := fmt.Sprintf(`DELETE FROM %s WHERE %s = ? AND user_id = ?`, t.votes, t.column)
+		_, err = tx.Exec(stmt, id, userID)
+	} else {
+		stmt := fmt.Sprintf(
+			`
+				INSERT INTO %[1]s (%[2]s, user_id, value, date_added)
+				VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+				ON CONFLICT (%[2]s, user_id) DO UPDATE
+				SET value = excluded.value, date_added = excluded.date_added
+			`,
+			t.votes, t.column,
+		)
+		_, err = tx.Exec(stmt, id, userID, value)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("recording vote: %w", err)
+	}
+
+	stmt := fmt.Sprintf(
+		`
+			UPDATE %[3]s
+			SET score = (SELECT coalesce(sum(value), 0) FROM %[1]s WHERE %[2]s = ?)
+			WHERE id = ?
+			RETURNING score
+		`,
+		t.votes, t.column, t.target,
+	)
+	var score int
+	err = tx.QueryRow(stmt, id, id).Scan(&score)
+	if err != nil {
+		return 0, fmt.Errorf("updating score: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("committing transaction: %w", err)
+	}
+	return score, nil
+}
+
+// ForThread returns the votes of a user on a thread and on each of its
+// messages, keyed by message id. Missing votes are 0.
+func (m *VoteModel) ForThread(threadID, userID int) (int, map[int]int, error) {
+	var threadVote int
+	stmt := `SELECT coalesce(max(value), 0) FROM thread_votes WHERE thread_id = ? AND user_id = ?`
+	err := m.DB.QueryRow(stmt, threadID, userID).Scan(&threadVote)
+	if err != nil {
+		return 0, nil, fmt.Errorf("getting thread vote: %w", err)
+	}
+
+	stmt = `
+		SELECT v.message_id, v.value
+		FROM message_votes v, messages m
+		WHERE v.message_id = m.id AND m.thread_id = ? AND v.user_id = ?
+	`
+	rows, err := m.DB.Query(stmt, threadID, userID)
+	if err != nil {
+		return 0, nil, fmt.Errorf("getting message votes: %w", err)
+	}
+	defer rows.Close()
+
+	votes := map[int]int{}
+	for rows.Next() {
+		var messageID, value int
+		err := rows.Scan(&messageID, &value)
+		if err != nil {
+			return 0, nil, fmt.Errorf("scanning vote row: %w", err)
+		}
+		votes[messageID] = value
+	}
+	if err = rows.Err(); err != nil {
+		return 0, nil, fmt.Errorf("iterating over vote rows: %w", err)
+	}
+
+	return threadVote, votes, nil
+}
@@ -0,0 +1,330 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Webhook events.
+const (
+	EventThreadCreated  = "thread.created"
+	EventMessageCreated = "message.created"
+	EventUserCreated    = "user.created"
+	EventPing           = "ping" // test event, sent on demand only
+)
+
+// WebhookEvents lists the events webhooks can subscribe to.
+var WebhookEvents = []string{EventThreadCreated, EventMessageCreated, EventUserCreated}
+
+// Webhook holds data about an endpoint forum events are posted to. Secret
+// is the key the payloads are signed with.
+type Webhook struct {
+	ID        int
+	URL       string
+	Secret    string
+	Events    []string
+	Active    bool
+	DateAdded time.Time
+}
+
+// Subscribes reports whether the webhook receives the given event.
+func (w *Webhook) Subscribes(event string) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery holds data about an event posted, or to be posted, to a
+// webhook. Deliveries are stored before being sent so that none are lost if
+// the server restarts or the endpoint is down, and are kept afterwards as
+// the delivery log.
+type WebhookDelivery struct {
+	ID            int
+	WebhookID     int
+	Event         string
+	Payload       string
+	Status        string // pending, delivered or failed
+	Attempts      int
+	ResponseCode  int // of the latest attempt, zero if no response came
+	LastError     string
+	NextAttempt   time.Time
+	DateAdded     time.Time
+	DateDelivered time.Time
+
+	// URL and Secret are those of the webhook, only set by Due.
+	URL    string
+	Secret string
+}
+
+// WebhookModel holds a database handle for manipulating webhooks and their
+// deliveries.
+type WebhookModel struct {
+	DB *sql.DB
+}
+
+// Insert saves a new active webhook and returns its id.
+func (m *WebhookModel) Insert(url, secret string, events []string) (int, error) {
+	stmt := `
+		INSERT INTO webhooks (url, secret, events, active, date_added)
+		VALUES (?, ?, ?, TRUE, CURRENT_TIMESTAMP)
+	`
+	result, err := m.DB.Exec(stmt, url, secret, strings.Join(events, ","))
+	if err != nil {
+		return 0, fmt.Errorf("inserting webhook: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("getting last webhook id: %w", err)
+	}
+	return int(id), nil
+}
+
+// Update changes the endpoint, events and state of a webhook.
+func (m *WebhookModel) Update(id int, url string, events []string, active bool) error {
+	stmt := `UPDATE webhooks SET url = ?, events = ?, active = ? WHERE id = ?`
+	_, err := m.DB.Exec(stmt, url, strings.Join(events, ","), active, id)
+	if err != nil {
+		return fmt.Errorf("updating webhook: %w", err)
+	}
+	return nil
+}
+
+// Delete deletes a webhook along with its deliveries.
+func (m *WebhookModel) Delete(id int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("deleting webhook deliveries: %w", err)
+	}
+	_, err = tx.Exec(`DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("deleting webhook: %w", err)
+	}
+	return tx.Commit()
+}
+
+// Get retrieves the webhook with the given id.
+func (m *WebhookModel) Get(id int) (*Webhook, error) {
+	stmt := `SELECT id, url, secret, events, active, date_added FROM webhooks WHERE id = ?`
+	w, err := scanWebhook(m.DB.QueryRow(stmt, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, fmt.Errorf("querying webhook: %w", err)
+	}
+	return w, nil
+}
+
+// All retrieves every webhook, oldest first.
+func (m *WebhookModel) All() ([]*Webhook, error) {
+	stmt := `SELECT id, url, secret, events, active, date_added FROM webhooks ORDER BY id`
+	rows, err := m.DB.Query(stmt)
+	if err != nil {
+		return nil, fmt.Errorf("querying webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	var webhooks []*Webhook
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning webhook row: %w", err)
+		}
+		webhooks = append(webhooks, w)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over webhook rows: %w", err)
+	}
+	return webhooks, nil
+}
+
+// scanWebhook scans a row of the webhooks table.
+func scanWebhook(s scanner) (*Webhook, error) {
+	var (
+		w      Webhook
+		events string
+	)
+	err := s.Scan(&w.ID, &w.URL, &w.Secret, &events, &w.Active, &w.DateAdded)
+	if err != nil {
+		return nil, err
+	}
+	if events != "" {
+		w.Events = strings.Split(events, ",")
+	}
+	return &w, nil
+}
+
+// Enqueue stores a delivery of the given event and payload for every active
+// webhook subscribed to the event, and returns how many were stored.
+func (m *WebhookModel) Enqueue(event, payload string) (int, error) {
+	stmt := `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload, status, next_attempt, date_added)
+		SELECT id, ?, ?, 'pending', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+		FROM webhooks
+		WHERE active AND ',' || events || ',' LIKE '%,' || ? || ',%'
+	`
+	result, err := m.DB.Exec(stmt, event, payload, event)
+	if err != nil {
+		return 0, fmt.Errorf("inserting webhook deliveries: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("getting affected rows: %w", err)
+	}
+	return int(n), nil
+}
+
+// EnqueueFor stores a delivery of the given event and payload for a single
+// webhook, whatever its events.
+func (m *WebhookModel) EnqueueFor(webhookID int, event, payload string) error {
+	stmt := `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload, status, next_attempt, date_added)
+		VALUES (?, ?, ?, 'pending', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	`
+	_, err := m.DB.Exec(stmt, webhookID, event, payload)
+	if err != nil {
+		return fmt.Errorf("inserting webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// Due retrieves the pending deliveries of active webhooks whose next attempt
+// is due, oldest first.
+func (m *WebhookModel) Due(now time.Time, limit int) ([]*WebhookDelivery, error) {
+	stmt := `
+		SELECT d.id, d.webhook_id, d.event, d.payload, d.attempts, w.url, w.secret
+		FROM webhook_deliveries d
+		JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.status = 'pending' AND d.next_attempt <= ? AND w.active
+		ORDER BY d.next_attempt, d.id
+		LIMIT ?
+	`
+	rows, err := m.DB.Query(stmt, sqlTime(now), limit)
+	if err != nil {
+		return nil, fmt.Errorf("getting due webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []*WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Attempts, &d.URL, &d.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("scanning webhook delivery row: %w", err)
+		}
+		deliveries = append(deliveries, &d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over webhook delivery rows: %w", err)
+	}
+	return deliveries, nil
+}
+
+// MarkDelivered records that a delivery was accepted with the given response
+// code.
+func (m *WebhookModel) MarkDelivered(id, code int) error {
+	stmt := `
+		UPDATE webhook_deliveries
+		SET status = 'delivered', attempts = attempts + 1, response_code = ?,
+		    last_error = NULL, date_delivered = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := m.DB.Exec(stmt, code, id)
+	if err != nil {
+		return fmt.Errorf("marking webhook delivery as delivered: %w", err)
+	}
+	return nil
+}
+
+// MarkFailed records a failed attempt at a delivery, with the response code
+// if a response came. The delivery is retried at next unless giveUp is set,
+// in which case it is abandoned.
+func (m *WebhookModel) MarkFailed(id, code int, sendErr error, next time.Time, giveUp bool) error {
+	status := "pending"
+	if giveUp {
+		status = "failed"
+	}
+	stmt := `
+		UPDATE webhook_deliveries
+		SET status = ?, attempts = attempts + 1, response_code = ?, last_error = ?, next_attempt = ?
+		WHERE id = ?
+	`
+	_, err := m.DB.Exec(stmt, status, code, sendErr.Error(), sqlTime(next), id)
+	if err != nil {
+		return fmt.Errorf("marking webhook delivery as failed: %w", err)
+	}
+	return nil
+}
+
+// Redeliver queues a delivery of the given webhook to be sent again as soon
+// as possible, with a fresh set of attempts. It returns ErrNoRecord if the
+// webhook has no such delivery.
+func (m *WebhookModel) Redeliver(webhookID, deliveryID int) error {
+	stmt := `
+		UPDATE webhook_deliveries
+		SET status = 'pending', attempts = 0, next_attempt = CURRENT_TIMESTAMP
+		WHERE id = ? AND webhook_id = ?
+	`
+	result, err := m.DB.Exec(stmt, deliveryID, webhookID)
+	if err != nil {
+		return fmt.Errorf("queuing webhook redelivery: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("getting affected rows: %w", err)
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// Deliveries retrieves the latest deliveries of a webhook, newest first.
+func (m *WebhookModel) Deliveries(webhookID, limit int) ([]*WebhookDelivery, error) {
+	stmt := `
+		SELECT id, webhook_id, event, payload, status, attempts, response_code,
+		       coalesce(last_error, ''), next_attempt, date_added, date_delivered
+		FROM webhook_deliveries
+		WHERE webhook_id = ?
+		ORDER BY id DESC
+		LIMIT ?
+	`
+	rows, err := m.DB.Query(stmt, webhookID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("querying webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []*WebhookDelivery
+	for rows.Next() {
+		var (
+			d         WebhookDelivery
+			delivered sql.NullTime
+		)
+		err := rows.Scan(
+			&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Status, &d.Attempts, &d.ResponseCode,
+			&d.LastError, &d.NextAttempt, &d.DateAdded, &delivered,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("scanning webhook delivery row: %w", err)
+		}
+		d.DateDelivered = delivered.Time
+		deliveries = append(deliveries, &d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over webhook delivery rows: %w", err)
+	}
+	return deliveries, nil
+}
@@ -1,30 +1,373 @@
 CREATE TABLE users (
     id INTEGER NOT NULL PRIMARY KEY,
     username VARCHAR(100) UNIQUE NOT NULL,
+    slug VARCHAR(100) UNIQUE NOT NULL,
     email VARCHAR(100) UNIQUE NOT NULL,
-    password VARCHAR(100) NOT NULL
+    password VARCHAR(100) NOT NULL,
+    bio VARCHAR(500) NOT NULL DEFAULT '',
+    date_joined DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    show_email BOOLEAN NOT NULL DEFAULT FALSE,
+    show_activity BOOLEAN NOT NULL DEFAULT TRUE,
+    email_mentions BOOLEAN NOT NULL DEFAULT FALSE,
+    email_frequency VARCHAR(20) NOT NULL DEFAULT 'immediate',
+    last_digest DATETIME,
+    avatar_version INTEGER NOT NULL DEFAULT 0,
+    role VARCHAR(20) NOT NULL DEFAULT 'member'
+);
+
+CREATE TABLE categories (
+    id INTEGER NOT NULL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    slug VARCHAR(100) UNIQUE NOT NULL,
+    description VARCHAR(500) NOT NULL DEFAULT '',
+    position INTEGER NOT NULL DEFAULT 0,
+    parent_id INTEGER,
+    date_added DATETIME NOT NULL,
+
+    FOREIGN KEY(parent_id) REFERENCES categories(id)
 );
 
 CREATE TABLE threads (
     id INTEGER NOT NULL PRIMARY KEY,
     title VARCHAR(100) NOT NULL,
     author_id INTEGER NOT NULL,
+    category_id INTEGER,
+    score INTEGER NOT NULL DEFAULT 0,
+    visibility VARCHAR(10) NOT NULL DEFAULT 'public',
+    group_id INTEGER,
+    kind VARCHAR(10) NOT NULL DEFAULT 'discussion',
+    accepted_message_id INTEGER,
+    scheduled_at DATETIME,
+    published_pending BOOLEAN NOT NULL DEFAULT FALSE,
     date_added DATETIME NOT NULL,
 
-    FOREIGN KEY(author_id) REFERENCES users(id)
+    FOREIGN KEY(author_id) REFERENCES users(id),
+    FOREIGN KEY(category_id) REFERENCES categories(id),
+    FOREIGN KEY(group_id) REFERENCES groups(id),
+    FOREIGN KEY(accepted_message_id) REFERENCES messages(id)
 );
 
 CREATE INDEX idx_threads_date ON threads(date_added);
+CREATE INDEX idx_threads_category ON threads(category_id, date_added);
+CREATE INDEX idx_threads_scheduled ON threads(scheduled_at) WHERE scheduled_at IS NOT NULL;
+CREATE INDEX idx_threads_published_pending ON threads(id) WHERE published_pending;
 
 CREATE TABLE messages (
     id INTEGER NOT NULL PRIMARY KEY,
     body TEXT NOT NULL,
+    revision INTEGER NOT NULL DEFAULT 1,
+    is_opening BOOLEAN NOT NULL DEFAULT FALSE,
     author_id INTEGER NOT NULL,
     thread_id INTEGER NOT NULL,
+    reply_to_id INTEGER,
+    score INTEGER NOT NULL DEFAULT 0,
     date_added DATETIME NOT NULL,
     
-    FOREIGN KEY(author_id) REFERENCES users(id)
+    FOREIGN KEY(author_id) REFERENCES users(id),
+    FOREIGN KEY(reply_to_id) REFERENCES messages(id),
+    FOREIGN KEY(thread_id) REFERENCES threads(id)
+);
+
+CREATE INDEX idx_messages_date ON messages(date_added);
+CREATE INDEX idx_messages_thread ON messages(thread_id, id);
+
+CREATE TABLE attachments (
+    id INTEGER NOT NULL PRIMARY KEY,
+    message_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    storage_key VARCHAR(100) UNIQUE NOT NULL,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size INTEGER NOT NULL,
+    date_added DATETIME NOT NULL,
+
+    FOREIGN KEY(message_id) REFERENCES messages(id),
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_attachments_message ON attachments(message_id);
+CREATE INDEX idx_attachments_user ON attachments(user_id);
+
+CREATE TABLE mentions (
+    id INTEGER NOT NULL PRIMARY KEY,
+    message_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    is_read BOOLEAN NOT NULL DEFAULT FALSE,
+    date_added DATETIME NOT NULL,
+
+    UNIQUE(message_id, user_id),
+    FOREIGN KEY(message_id) REFERENCES messages(id),
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_mentions_user ON mentions(user_id, date_added);
+
+CREATE TABLE notifications (
+    id INTEGER NOT NULL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    kind VARCHAR(20) NOT NULL,
+    thread_id INTEGER NOT NULL,
+    actor_id INTEGER NOT NULL,
+    count INTEGER NOT NULL DEFAULT 1,
+    is_read BOOLEAN NOT NULL DEFAULT FALSE,
+    date_updated DATETIME NOT NULL,
+
+    FOREIGN KEY(user_id) REFERENCES users(id),
+    FOREIGN KEY(thread_id) REFERENCES threads(id),
+    FOREIGN KEY(actor_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_notifications_user ON notifications(user_id, date_updated);
+CREATE UNIQUE INDEX idx_notifications_unread ON notifications(user_id, kind, thread_id) WHERE NOT is_read;
+
+CREATE TABLE subscriptions (
+    user_id INTEGER NOT NULL,
+    thread_id INTEGER NOT NULL,
+    date_added DATETIME NOT NULL,
+
+    PRIMARY KEY(user_id, thread_id),
+    FOREIGN KEY(user_id) REFERENCES users(id),
+    FOREIGN KEY(thread_id) REFERENCES threads(id)
+);
+
+CREATE INDEX idx_subscriptions_thread ON subscriptions(thread_id);
+
+CREATE TABLE outbox (
+    id INTEGER NOT NULL PRIMARY KEY,
+    recipient VARCHAR(100) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    body TEXT NOT NULL,
+    headers TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt DATETIME NOT NULL,
+    date_added DATETIME NOT NULL,
+    date_sent DATETIME
+);
+
+CREATE INDEX idx_outbox_due ON outbox(status, next_attempt);
+
+CREATE TABLE thread_reads (
+    user_id INTEGER NOT NULL,
+    thread_id INTEGER NOT NULL,
+    last_read_message_id INTEGER NOT NULL,
+    date_read DATETIME NOT NULL,
+
+    PRIMARY KEY(user_id, thread_id),
+    FOREIGN KEY(user_id) REFERENCES users(id),
+    FOREIGN KEY(thread_id) REFERENCES threads(id)
+);
+
+CREATE TABLE tags (
+    id INTEGER NOT NULL PRIMARY KEY,
+    name VARCHAR(30) NOT NULL,
+    slug VARCHAR(30) UNIQUE NOT NULL,
+    date_added DATETIME NOT NULL
+);
+
+CREATE TABLE thread_tags (
+    thread_id INTEGER NOT NULL,
+    tag_id INTEGER NOT NULL,
+
+    PRIMARY KEY(thread_id, tag_id),
     FOREIGN KEY(thread_id) REFERENCES threads(id),
+    FOREIGN KEY(tag_id) REFERENCES tags(id)
+);
+
+CREATE INDEX idx_thread_tags_tag ON thread_tags(tag_id);
+
+CREATE TABLE reactions (
+    message_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    emoji VARCHAR(16) NOT NULL,
+    date_added DATETIME NOT NULL,
+
+    PRIMARY KEY(message_id, user_id, emoji),
+    FOREIGN KEY(message_id) REFERENCES messages(id),
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE thread_votes (
+    thread_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    value INTEGER NOT NULL CHECK(value IN (-1, 1)),
+    date_added DATETIME NOT NULL,
+
+    PRIMARY KEY(thread_id, user_id),
+    FOREIGN KEY(thread_id) REFERENCES threads(id),
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE message_votes (
+    message_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    value INTEGER NOT NULL CHECK(value IN (-1, 1)),
+    date_added DATETIME NOT NULL,
+
+    PRIMARY KEY(message_id, user_id),
+    FOREIGN KEY(message_id) REFERENCES messages(id),
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE conversations (
+    id INTEGER NOT NULL PRIMARY KEY,
+    subject VARCHAR(100) NOT NULL,
+    author_id INTEGER NOT NULL,
+    date_added DATETIME NOT NULL,
+    date_updated DATETIME NOT NULL,
+
+    FOREIGN KEY(author_id) REFERENCES users(id)
+);
+
+CREATE TABLE conversation_participants (
+    conversation_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    last_read_message_id INTEGER NOT NULL DEFAULT 0,
+
+    PRIMARY KEY(conversation_id, user_id),
+    FOREIGN KEY(conversation_id) REFERENCES conversations(id),
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_conversation_participants_user ON conversation_participants(user_id);
+
+CREATE TABLE private_messages (
+    id INTEGER NOT NULL PRIMARY KEY,
+    conversation_id INTEGER NOT NULL,
+    author_id INTEGER NOT NULL,
+    body TEXT NOT NULL,
+    date_added DATETIME NOT NULL,
+
+    FOREIGN KEY(conversation_id) REFERENCES conversations(id),
+    FOREIGN KEY(author_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_private_messages_conversation ON private_messages(conversation_id, id);
+
+CREATE TABLE user_blocks (
+    blocker_id INTEGER NOT NULL,
+    blocked_id INTEGER NOT NULL,
+    date_added DATETIME NOT NULL,
+
+    PRIMARY KEY(blocker_id, blocked_id),
+    FOREIGN KEY(blocker_id) REFERENCES users(id),
+    FOREIGN KEY(blocked_id) REFERENCES users(id)
+);
+
+CREATE TABLE groups (
+    id INTEGER NOT NULL PRIMARY KEY,
+    name VARCHAR(50) NOT NULL,
+    slug VARCHAR(50) UNIQUE NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    date_added DATETIME NOT NULL
+);
+
+CREATE TABLE group_members (
+    group_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    date_added DATETIME NOT NULL,
+
+    PRIMARY KEY(group_id, user_id),
+    FOREIGN KEY(group_id) REFERENCES groups(id),
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_group_members_user ON group_members(user_id);
+
+CREATE TABLE polls (
+    id INTEGER NOT NULL PRIMARY KEY,
+    thread_id INTEGER UNIQUE NOT NULL,
+    question VARCHAR(200) NOT NULL,
+    multiple BOOLEAN NOT NULL DEFAULT FALSE,
+    anonymous BOOLEAN NOT NULL DEFAULT FALSE,
+    closes_at DATETIME,
+
+    FOREIGN KEY(thread_id) REFERENCES threads(id)
+);
+
+CREATE TABLE poll_options (
+    id INTEGER NOT NULL PRIMARY KEY,
+    poll_id INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+    label VARCHAR(100) NOT NULL,
+
+    FOREIGN KEY(poll_id) REFERENCES polls(id)
+);
+
+CREATE INDEX idx_poll_options_poll ON poll_options(poll_id, position);
+
+CREATE TABLE poll_ballots (
+    poll_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+
+    UNIQUE(poll_id, user_id),
+    FOREIGN KEY(poll_id) REFERENCES polls(id),
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE poll_votes (
+    poll_id INTEGER NOT NULL,
+    option_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+
+    PRIMARY KEY(option_id, user_id),
+    FOREIGN KEY(poll_id) REFERENCES polls(id),
+    FOREIGN KEY(option_id) REFERENCES poll_options(id),
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_poll_votes_poll ON poll_votes(poll_id);
+
+CREATE TABLE bookmarks (
+    id INTEGER NOT NULL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    thread_id INTEGER NOT NULL,
+    message_id INTEGER NOT NULL DEFAULT 0,
+    note TEXT NOT NULL DEFAULT '',
+    date_added DATETIME NOT NULL,
+
+    UNIQUE(user_id, thread_id, message_id),
+    FOREIGN KEY(user_id) REFERENCES users(id),
+    FOREIGN KEY(thread_id) REFERENCES threads(id)
+);
+
+CREATE TABLE drafts (
+    user_id INTEGER NOT NULL,
+    thread_id INTEGER NOT NULL DEFAULT 0,
+    title VARCHAR(100) NOT NULL DEFAULT '',
+    body TEXT NOT NULL,
+    date_updated DATETIME NOT NULL,
+
+    PRIMARY KEY(user_id, thread_id),
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE webhooks (
+    id INTEGER NOT NULL PRIMARY KEY,
+    url VARCHAR(500) NOT NULL,
+    secret VARCHAR(100) NOT NULL,
+    events TEXT NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    date_added DATETIME NOT NULL
+);
+
+CREATE TABLE webhook_deliveries (
+    id INTEGER NOT NULL PRIMARY KEY,
+    webhook_id INTEGER NOT NULL,
+    event VARCHAR(50) NOT NULL,
+    payload TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    response_code INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt DATETIME NOT NULL,
+    date_added DATETIME NOT NULL,
+    date_delivered DATETIME,
+
+    FOREIGN KEY(webhook_id) REFERENCES webhooks(id)
 );
 
-CREATE INDEX idx_messages_date ON messages(date_added);
\ No newline at end of file
+CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt);
+CREATE INDEX idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id, id);
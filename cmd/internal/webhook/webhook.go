@@ -0,0 +1,70 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// Headers sent along with every payload. SignatureHeader holds "sha256="
+// followed by the hex encoded HMAC-SHA256 of the request body, keyed with
+// the webhook secret.
+const (
+	EventHeader     = "X-Forum-Event"
+	DeliveryHeader  = "X-Forum-Delivery"
+	SignatureHeader = "X-Forum-Signature"
+)
+
+// Request is a payload to post to a webhook.
+type Request struct {
+	URL        string
+	Secret     string
+	Event      string
+	DeliveryID int
+	Body       []byte // JSON
+}
+
+// Sign returns the value of the signature header for body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature, the value of the signature header, is
+// valid for body. It is meant for receivers.
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// Send posts req with client and returns the response code, zero if no
+// response came. Any other response than a 2xx is an error.
+func Send(client *http.Client, req Request) (int, error) {
+	r, err := http.NewRequest(http.MethodPost, req.URL, bytes.NewReader(req.Body))
+	if err != nil {
+		return 0, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("User-Agent", "Forum-Webhooks")
+	r.Header.Set(EventHeader, req.Event)
+	r.Header.Set(DeliveryHeader, strconv.Itoa(req.DeliveryID))
+	r.Header.Set(SignatureHeader, Sign(req.Secret, req.Body))
+
+	resp, err := client.Do(r)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain a bit of the body so that the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,85 @@
+package webhook
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSend(t *testing.T) {
+	var (
+		got  *http.Request
+		body []byte
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	req := Request{
+		URL:        ts.URL,
+		Secret:     "s3cret",
+		Event:      "thread.created",
+		DeliveryID: 42,
+		Body:       []byte(`{"event":"thread.created"}`),
+	}
+	code, err := Send(ts.Client(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != http.StatusNoContent {
+		t.Errorf("got code %d; want %d", code, http.StatusNoContent)
+	}
+
+	if string(body) != string(req.Body) {
+		t.Errorf("got body %q; want %q", body, req.Body)
+	}
+	if h := got.Header.Get(EventHeader); h != req.Event {
+		t.Errorf("got event header %q; want %q", h, req.Event)
+	}
+	if h := got.Header.Get(DeliveryHeader); h != "42" {
+		t.Errorf("got delivery header %q; want %q", h, "42")
+	}
+	signature := got.Header.Get(SignatureHeader)
+	if !Verify(req.Secret, body, signature) {
+		t.Errorf("signature %q does not verify", signature)
+	}
+	if Verify("other", body, signature) {
+		t.Error("signature verifies with another secret")
+	}
+	if Verify(req.Secret, []byte(`{"event":"tampered"}`), signature) {
+		t.Error("signature verifies with another body")
+	}
+}
+
+func TestSendErrorStatus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	code, err := Send(ts.Client(), Request{URL: ts.URL, Secret: "s3cret", Body: []byte(`{}`)})
+	if err == nil {
+		t.Fatal("got no error for a 503 response")
+	}
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("got code %d; want %d", code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestSendNoResponse(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	url := ts.URL
+	ts.Close()
+
+	code, err := Send(http.DefaultClient, Request{URL: url, Secret: "s3cret", Body: []byte(`{}`)})
+	if err == nil {
+		t.Fatal("got no error for a closed server")
+	}
+	if code != 0 {
+		t.Errorf("got code %d; want 0", code)
+	}
+}
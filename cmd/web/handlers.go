@@ -1,25 +1,113 @@
 package main
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"forum/cmd/internal/avatar"
+	"forum/cmd/internal/broker"
+	"forum/cmd/internal/feed"
+	"forum/cmd/internal/mailer"
+	"forum/cmd/internal/markdown"
 	"forum/cmd/internal/models"
+	"forum/cmd/internal/storage"
 	"forum/cmd/internal/validator"
 )
 
 // home displays the 10 latest threads.
 func (app *application) home(w http.ResponseWriter, r *http.Request) {
-	threads, err := app.threads.Latests()
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	data := app.newTemplateData(r)
+	data.TagFilter = newTagFilter(r)
+
+	// Filtering by tags pages through every matching thread instead of
+	// showing the latest ones.
+	if len(data.TagFilter.Slugs) > 0 {
+		count, err := app.threads.CountByTags(data.TagFilter.Slugs, data.TagFilter.MatchAll, userSessionID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		page, ok := newPagination(r, count, threadsPerPage)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		data.Threads, err = app.threads.ByTags(
+			data.TagFilter.Slugs, data.TagFilter.MatchAll,
+			userSessionID, threadsPerPage, page.Offset(),
+		)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data.Pagination = page
+
+		app.render(w, r, http.StatusOK, "home.tmpl", data)
+		return
+	}
+
+	// Other sorts than the latest threads and unanswered questions page
+	// through every thread as well, without the category overview.
+	data.Sort = newThreadSort(r)
+	if data.Sort.Sort != models.SortLatest || data.Sort.Unanswered {
+		count, err := app.threads.CountSorted(data.Sort.Sort, data.Sort.Window, data.Sort.Unanswered, userSessionID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		page, ok := newPagination(r, count, threadsPerPage)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		data.Threads, err = app.threads.Sorted(
+			data.Sort.Sort, data.Sort.Window, data.Sort.Unanswered,
+			userSessionID, threadsPerPage, page.Offset(),
+		)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data.Pagination = page
+
+		app.render(w, r, http.StatusOK, "home.tmpl", data)
+		return
+	}
+
+	threads, err := app.threads.Latests(userSessionID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	categories, err := app.categories.Overview(userSessionID)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
-	data := app.newTemplateData(r)
 	data.Threads = threads
+	data.Categories = categories
 
 	app.render(w, r, http.StatusOK, "home.tmpl", data)
 }
@@ -81,6 +169,14 @@ func (app *application) accountCreatePost(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// The account exists from here on: errors are logged rather than
+	// reported, so that the user does not try to sign up again.
+	user, err := app.users.GetUser(id)
+	if err == nil {
+		err = app.fireEvent(models.EventUserCreated, map[string]any{"user": app.webhookUser(user)})
+	}
+	app.logError(r, err)
+
 	app.sessionManager.Put(r.Context(), "flash", "Account created successfully!")
 	http.Redirect(w, r, fmt.Sprintf("/account/view/%d", id), http.StatusSeeOther)
 }
@@ -112,145 +208,200 @@ func (app *application) accountView(w http.ResponseWriter, r *http.Request) {
 
 	data := app.newTemplateData(r)
 	data.User = user
+	data.Form = accountProfileForm{
+		Bio:            user.Bio,
+		ShowEmail:      user.ShowEmail,
+		ShowActivity:   user.ShowActivity,
+		EmailMentions:  user.EmailMentions,
+		EmailFrequency: user.EmailFrequency,
+	}
 
 	app.render(w, r, http.StatusOK, "account-view.tmpl", data)
 }
 
-// accountLoginForm holds the data for the account login form.
-type accountLoginForm struct {
-	Username string
-	Email    string
-	Password string
+// accountProfileForm holds the data for the public profile settings form.
+type accountProfileForm struct {
+	Bio            string
+	ShowEmail      bool
+	ShowActivity   bool
+	EmailMentions  bool
+	EmailFrequency string
 	validator.Validator
 }
 
-// accountLogin displays the login form.
-func (app *application) accountLogin(w http.ResponseWriter, r *http.Request) {
-	data := app.newTemplateData(r)
-	data.Form = accountLoginForm{}
-	app.render(w, r, http.StatusOK, "account-login.tmpl", data)
-}
-
-// accountLoginPost logs in the user.
-func (app *application) accountLoginPost(w http.ResponseWriter, r *http.Request) {
+// accountProfilePost updates the public profile settings of the logged in user.
+func (app *application) accountProfilePost(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
 
-	form := accountLoginForm{
-		Email:    r.PostForm.Get("email"),
-		Password: r.PostForm.Get("password"),
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	user, err := app.users.GetUser(userSessionID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
 	}
 
-	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank.")
-	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field is not a valid email address.")
-	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank.")
+	form := accountProfileForm{
+		Bio:            r.PostForm.Get("bio"),
+		ShowEmail:      r.PostForm.Get("show_email") == "on",
+		ShowActivity:   r.PostForm.Get("show_activity") == "on",
+		EmailMentions:  r.PostForm.Get("email_mentions") == "on",
+		EmailFrequency: r.PostForm.Get("email_frequency"),
+	}
+
+	form.CheckField(validator.MaxChars(form.Bio, 500), "bio", "This field cannot be more than 500 characters.")
+	form.CheckField(
+		validator.PermittedValue(
+			form.EmailFrequency,
+			models.EmailImmediate, models.EmailDaily, models.EmailWeekly, models.EmailNever,
+		),
+		"email_frequency",
+		"This field must be one of the listed options.",
+	)
 
 	if !form.Valid() {
 		data := app.newTemplateData(r)
+		data.User = user
 		data.Form = form
-		app.render(w, r, http.StatusUnprocessableEntity, "account-login.tmpl", data)
-		return
-	}
-
-	id, err := app.users.Authenticate(form.Email, form.Password)
-	if err != nil {
-		if errors.Is(err, models.ErrInvalidCredentials) {
-			form.AddNonFieldError("Email or password incorrect")
-			data := app.newTemplateData(r)
-			data.Form = form
-			app.render(w, r, http.StatusUnprocessableEntity, "account-login.tmpl", data)
-		} else {
-			app.serverError(w, r, err)
-		}
+		app.render(w, r, http.StatusUnprocessableEntity, "account-view.tmpl", data)
 		return
 	}
 
-	err = app.sessionManager.RenewToken(r.Context())
+	err = app.users.UpdateProfile(
+		user.ID,
+		form.Bio,
+		form.ShowEmail,
+		form.ShowActivity,
+		form.EmailMentions,
+		form.EmailFrequency,
+	)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
-	app.sessionManager.Put(r.Context(), "authenticatedUserID", id)
-	http.Redirect(w, r, "/thread/create", http.StatusSeeOther)
+	app.sessionManager.Put(r.Context(), "flash", "Profile updated successfully!")
+	http.Redirect(w, r, fmt.Sprintf("/account/view/%d", user.ID), http.StatusSeeOther)
 }
 
-// userLogoutPost logs out the user.
-func (app application) accountLogoutPost(w http.ResponseWriter, r *http.Request) {
-	err := app.sessionManager.RenewToken(r.Context())
+// maxAvatarSize is the largest avatar upload accepted, in bytes.
+const maxAvatarSize = 2 << 20
+
+// accountAvatarPost stores a new avatar for the logged in user. The upload is
+// validated, cropped to a square and saved as PNG thumbnails of every size.
+func (app *application) accountAvatarPost(w http.ResponseWriter, r *http.Request) {
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	user, err := app.users.GetUser(userSessionID)
 	if err != nil {
-		app.serverError(w, r, err)
+		if errors.Is(err, models.ErrNoRecord) {
+			http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
 		return
 	}
 
-	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
-	app.sessionManager.Put(r.Context(), "flash", "You've been logged out successfully!")
-	http.Redirect(w, r, "/", http.StatusSeeOther)
-}
-
-// createThreadForm holds the data for the thread creation form.
-type createThreadForm struct {
-	Title string
-	validator.Validator
-}
-
-// threadCreate displays the thread creation form.
-func (app *application) threadCreate(w http.ResponseWriter, r *http.Request) {
-	data := app.newTemplateData(r)
-	data.Form = createThreadForm{}
-	app.render(w, r, http.StatusOK, "thread-create.tmpl", data)
-}
+	form := accountProfileForm{
+		Bio:            user.Bio,
+		ShowEmail:      user.ShowEmail,
+		ShowActivity:   user.ShowActivity,
+		EmailMentions:  user.EmailMentions,
+		EmailFrequency: user.EmailFrequency,
+	}
 
-// threadCreatePost creates a thread.
-func (app *application) threadCreatePost(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseForm()
+	r.Body = http.MaxBytesReader(w, r.Body, maxAvatarSize+4096)
+	file, _, err := r.FormFile("avatar")
 	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
-		return
+		form.AddFieldError("avatar", "Please choose an image of at most 2 MB.")
+	} else {
+		defer file.Close()
 	}
 
-	form := createThreadForm{
-		Title: r.PostForm.Get("title"),
+	var thumbs map[int][]byte
+	if form.Valid() {
+		thumbs, err = avatar.Process(file)
+		switch {
+		case errors.Is(err, avatar.ErrUnsupportedFormat):
+			form.AddFieldError("avatar", "The image must be a PNG, JPEG or GIF file.")
+		case errors.Is(err, avatar.ErrTooLarge):
+			form.AddFieldError("avatar", fmt.Sprintf("The image cannot be larger than %[1]dx%[1]d pixels.", avatar.MaxDimension))
+		case err != nil:
+			form.AddFieldError("avatar", "The image could not be read.")
+		}
 	}
 
-	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank.")
-	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters).")
-
 	if !form.Valid() {
 		data := app.newTemplateData(r)
+		data.User = user
 		data.Form = form
-		app.render(w, r, http.StatusUnprocessableEntity, "thread-create.tmpl", data)
+		app.render(w, r, http.StatusUnprocessableEntity, "account-view.tmpl", data)
 		return
 	}
 
-	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
-	if userSessionID == 0 {
-		http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+	for size, thumb := range thumbs {
+		err = app.storage.Put(avatarKey(user.ID, size), bytes.NewReader(thumb))
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	err = app.users.SetAvatar(user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
 		return
 	}
 
-	threadID, err := app.threads.Insert(form.Title, userSessionID)
+	app.sessionManager.Put(r.Context(), "flash", "Avatar updated successfully!")
+	http.Redirect(w, r, fmt.Sprintf("/account/view/%d", user.ID), http.StatusSeeOther)
+}
+
+// accountAvatarDeletePost removes the uploaded avatar of the logged in user,
+// who then falls back to their generated identicon.
+func (app *application) accountAvatarDeletePost(w http.ResponseWriter, r *http.Request) {
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	err := app.users.ClearAvatar(userSessionID)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
-	app.sessionManager.Put(r.Context(), "flash", "Thread created successfully!")
-	http.Redirect(w, r, fmt.Sprintf("/thread/view/%d", threadID), http.StatusSeeOther)
+	for _, size := range avatar.Sizes {
+		err = app.storage.Delete(avatarKey(userSessionID, size))
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Avatar removed successfully!")
+	http.Redirect(w, r, fmt.Sprintf("/account/view/%d", userSessionID), http.StatusSeeOther)
 }
 
-// threadView displays a single thread.
-func (app *application) threadView(w http.ResponseWriter, r *http.Request) {
+// avatarView serves the avatar of a user at one of the thumbnail sizes, or a
+// generated identicon if the user has not uploaded one.
+func (app *application) avatarView(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil || id < 1 {
 		http.NotFound(w, r)
 		return
 	}
 
-	thread, err := app.threads.Get(id)
+	size, err := strconv.Atoi(r.PathValue("size"))
+	if err != nil || !avatar.ValidSize(size) {
+		http.NotFound(w, r)
+		return
+	}
+
+	user, err := app.users.GetUser(id)
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
 			http.NotFound(w, r)
@@ -260,27 +411,47 @@ func (app *application) threadView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	data := app.newTemplateData(r)
-	data.Thread = thread
+	img, err := app.avatarImage(user, size)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
 
-	app.render(w, r, http.StatusOK, "thread-view.tmpl", data)
+	// Versions start over when an avatar is removed, so the ETag is a hash
+	// of the image rather than its version.
+	sum := sha256.Sum256(img)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(img))
 }
 
-// createMessageForm holds the data for the message creation form.
-type createMessageForm struct {
-	Message string
-	validator.Validator
+// avatarImage returns the avatar of user at the given size as a PNG: the
+// uploaded one if any, or else their identicon.
+func (app *application) avatarImage(user *models.User, size int) ([]byte, error) {
+	if user.AvatarVersion > 0 {
+		f, err := app.storage.Open(avatarKey(user.ID, size))
+		if err == nil {
+			defer f.Close()
+			return io.ReadAll(f)
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			return nil, err
+		}
+	}
+	return avatar.Identicon(user.Username, size)
 }
 
-// messageCreate displays the message creation form for a specific thread.
-func (app *application) messageCreate(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.Atoi(r.PathValue("id"))
-	if err != nil || id < 1 {
-		http.NotFound(w, r)
-		return
-	}
+// avatarKey returns the storage key of a user's avatar thumbnail.
+func avatarKey(userID, size int) string {
+	return fmt.Sprintf("avatars/%d/%d.png", userID, size)
+}
 
-	thread, err := app.threads.Get(id)
+// userProfile displays the public profile of a user. The email address and
+// recent activity are only shown if the user allows it, or to the user
+// themselves.
+func (app *application) userProfile(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.GetBySlug(r.PathValue("slug"))
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
 			http.NotFound(w, r)
@@ -290,29 +461,105 @@ func (app *application) messageCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	stats, err := app.users.Stats(user.ID, userSessionID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
 	data := app.newTemplateData(r)
-	data.Thread = thread
-	data.Form = createMessageForm{}
+	data.User = user
+	data.Stats = stats
+	if user.ShowActivity {
+		data.Feeds = append(data.Feeds, feedLink{Title: "Posts by " + user.Username, Path: "/user/" + user.Slug + "/feed"})
+	}
+	data.IsOwner = userSessionID == user.ID
+	if userSessionID != 0 && !data.IsOwner {
+		data.IsBlocked, err = app.blocks.IsBlocked(userSessionID, user.ID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
 
-	app.render(w, r, http.StatusOK, "message-create.tmpl", data)
+	if user.ShowActivity || data.IsOwner {
+		data.Threads, err = app.threads.ByAuthor(user.ID, userSessionID, 5)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data.Messages, err = app.messages.ByAuthor(user.ID, userSessionID, 5)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	app.render(w, r, http.StatusOK, "user-profile.tmpl", data)
 }
 
-// messageCreatePost creates a message and redirects to updated thead.
-func (app *application) messageCreatePost(w http.ResponseWriter, r *http.Request) {
+// accountMentions displays the latest messages the logged in user was
+// mentioned in, then marks the mentions shown as read.
+func (app *application) accountMentions(w http.ResponseWriter, r *http.Request) {
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
 
-	err := r.ParseForm()
+	mentions, err := app.mentions.ForUser(userSessionID, 50)
 	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
+		app.serverError(w, r, err)
 		return
 	}
 
-	threadID, err := strconv.Atoi(r.PathValue("id"))
-	if err != nil || threadID < 1 {
+	data := app.newTemplateData(r)
+	data.Mentions = mentions
+
+	app.render(w, r, http.StatusOK, "account-mentions.tmpl", data)
+
+	// Only the mentions shown are marked as read, so that older unread ones
+	// still count until they come up.
+	ids := make([]int, len(mentions))
+	for i, mn := range mentions {
+		ids[i] = mn.ID
+	}
+	err = app.mentions.MarkRead(userSessionID, ids)
+	if err != nil {
+		app.logger.Error(err.Error())
+	}
+}
+
+// notificationsView displays the latest notifications of the logged in user.
+func (app *application) notificationsView(w http.ResponseWriter, r *http.Request) {
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	notifications, err := app.notifications.ForUser(userSessionID, 50)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Notifications = notifications
+
+	app.render(w, r, http.StatusOK, "notifications.tmpl", data)
+}
+
+// notificationReadPost marks a notification as read and redirects to the
+// thread it is about.
+func (app *application) notificationReadPost(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
 		http.NotFound(w, r)
 		return
 	}
 
-	thread, err := app.threads.Get(threadID)
+	err = r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	err = app.notifications.MarkRead(userSessionID, id)
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
 			http.NotFound(w, r)
@@ -322,33 +569,3565 @@ func (app *application) messageCreatePost(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	form := createMessageForm{
-		Message: r.PostForm.Get("message"),
-	}
-
-	form.CheckField(validator.NotBlank(form.Message), "message", "This field cannot be blank.")
-	form.CheckField(validator.MaxChars(form.Message, 1000), "message", "This field cannot be more than 1000 characters).")
-
-	if !form.Valid() {
-		data := app.newTemplateData(r)
-		data.Thread = thread
-		data.Form = form
-		app.render(w, r, http.StatusUnprocessableEntity, "message-create.tmpl", data)
+	threadID, err := strconv.Atoi(r.PostForm.Get("thread_id"))
+	if err != nil || threadID < 1 {
+		http.Redirect(w, r, "/notifications", http.StatusSeeOther)
 		return
 	}
+	http.Redirect(w, r, fmt.Sprintf("/thread/view/%d", threadID), http.StatusSeeOther)
+}
 
+// notificationsReadAllPost marks every notification of the logged in user as
+// read.
+func (app *application) notificationsReadAllPost(w http.ResponseWriter, r *http.Request) {
 	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
-	if userSessionID == 0 {
-		http.Redirect(w, r, "/account/login", http.StatusSeeOther)
-		return
-	}
 
-	_, err = app.messages.InsertMessage(form.Message, threadID, userSessionID)
+	err := app.notifications.MarkAllRead(userSessionID)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
-	app.sessionManager.Put(r.Context(), "flash", "Message created successfully!")
-	http.Redirect(w, r, fmt.Sprintf("/thread/view/%d", threadID), http.StatusSeeOther)
+	http.Redirect(w, r, "/notifications", http.StatusSeeOther)
+}
+
+// accountLoginForm holds the data for the account login form.
+type accountLoginForm struct {
+	Username string
+	Email    string
+	Password string
+	validator.Validator
+}
+
+// accountLogin displays the login form.
+func (app *application) accountLogin(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = accountLoginForm{}
+	app.render(w, r, http.StatusOK, "account-login.tmpl", data)
+}
+
+// accountLoginPost logs in the user.
+func (app *application) accountLoginPost(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := accountLoginForm{
+		Email:    r.PostForm.Get("email"),
+		Password: r.PostForm.Get("password"),
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank.")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field is not a valid email address.")
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank.")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "account-login.tmpl", data)
+		return
+	}
+
+	id, err := app.users.Authenticate(form.Email, form.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			form.AddNonFieldError("Email or password incorrect")
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusUnprocessableEntity, "account-login.tmpl", data)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "authenticatedUserID", id)
+
+	redirect, err := app.saveStashedDraft(r, id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if redirect == "" {
+		redirect = "/thread/create"
+	} else {
+		app.sessionManager.Put(r.Context(), "flash", "Your session had expired: what you wrote was saved as a draft.")
+	}
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// userLogoutPost logs out the user.
+func (app application) accountLogoutPost(w http.ResponseWriter, r *http.Request) {
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+	app.sessionManager.Put(r.Context(), "flash", "You've been logged out successfully!")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// createThreadForm holds the data for the thread creation form.
+type createThreadForm struct {
+	Title      string
+	Message    string
+	CategoryID int
+	Tags       string
+	Kind       string
+	Visibility string
+	GroupID    int
+
+	// PublishAt holds the value of a datetime-local input, blank to publish
+	// the thread right away.
+	PublishAt string
+
+	// The poll fields are left blank for threads without a poll.
+	// PollOptions holds one option per line and PollCloses the value of a
+	// datetime-local input.
+	PollQuestion  string
+	PollOptions   string
+	PollMultiple  bool
+	PollAnonymous bool
+	PollCloses    string
+	validator.Validator
+}
+
+// threadCreate displays the thread creation form. The category given by the
+// category query parameter, a slug, is preselected.
+func (app *application) threadCreate(w http.ResponseWriter, r *http.Request) {
+	categories, err := app.categories.All()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	groups, err := app.groups.ForUser(userSessionID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	form := createThreadForm{Kind: models.KindDiscussion, Visibility: models.VisibilityPublic}
+	if slug := r.URL.Query().Get("category"); slug != "" {
+		for _, c := range categories {
+			if c.Slug == slug {
+				form.CategoryID = c.ID
+			}
+		}
+	}
+
+	draft, err := app.drafts.Get(userSessionID, 0)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+	if draft != nil {
+		form.Title, form.Message = draft.Title, draft.Body
+	}
+
+	data := app.newTemplateData(r)
+	data.Draft = draft
+	data.Categories = categories
+	data.Groups = groups
+	data.Form = form
+	app.render(w, r, http.StatusOK, "thread-create.tmpl", data)
+}
+
+// threadCreatePost creates a thread.
+func (app *application) threadCreatePost(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	categories, err := app.categories.All()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	groups, err := app.groups.ForUser(userSessionID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	form := createThreadForm{
+		Title:      r.PostForm.Get("title"),
+		Message:    r.PostForm.Get("message"),
+		Tags:       r.PostForm.Get("tags"),
+		Kind:       r.PostForm.Get("kind"),
+		Visibility: r.PostForm.Get("visibility"),
+		PublishAt:  r.PostForm.Get("publish_at"),
+
+		PollQuestion:  strings.TrimSpace(r.PostForm.Get("poll_question")),
+		PollOptions:   r.PostForm.Get("poll_options"),
+		PollMultiple:  r.PostForm.Get("poll_multiple") != "",
+		PollAnonymous: r.PostForm.Get("poll_anonymous") != "",
+		PollCloses:    r.PostForm.Get("poll_closes"),
+	}
+	form.CategoryID, _ = strconv.Atoi(r.PostForm.Get("category_id"))
+	form.GroupID, _ = strconv.Atoi(r.PostForm.Get("group_id"))
+	tags := parseTags(form.Tags)
+	checkVisibility(&form.Validator, form.Visibility, form.GroupID, groups)
+	form.CheckField(
+		validator.PermittedValue(form.Kind, models.KindDiscussion, models.KindQuestion),
+		"kind", "Please choose a thread type.",
+	)
+	poll := newPoll(&form)
+
+	var scheduledAt time.Time
+	if form.PublishAt != "" {
+		scheduledAt = parseFutureTime(&form.Validator, "publish_at", form.PublishAt, "publish time")
+		form.CheckField(
+			poll == nil || poll.ClosesAt.IsZero() || poll.ClosesAt.After(scheduledAt),
+			"poll_closes", "The poll must close after the thread is published.",
+		)
+	}
+
+	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank.")
+	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters).")
+	checkMessage(&form.Validator, form.Message)
+	checkTags(&form.Validator, tags)
+
+	// A category must be chosen once any exist.
+	if len(categories) > 0 {
+		valid := false
+		for _, c := range categories {
+			valid = valid || c.ID == form.CategoryID
+		}
+		form.CheckField(valid, "category_id", "Please choose a category.")
+	} else {
+		form.CategoryID = 0
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Categories = categories
+		data.Groups = groups
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "thread-create.tmpl", data)
+		return
+	}
+
+	if userSessionID == 0 {
+		http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+		return
+	}
+
+	threadID, messageID, err := app.threads.Insert(
+		form.Title,
+		form.Message,
+		userSessionID,
+		form.CategoryID,
+		form.Kind,
+		form.Visibility,
+		form.GroupID,
+		tags,
+		poll,
+		scheduledAt,
+	)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// The thread is saved: from here on errors are logged rather than
+	// reported, so that the user does not post it again.
+	app.logError(r, app.subscriptions.Subscribe(userSessionID, threadID))
+	app.logError(r, app.drafts.Delete(userSessionID, 0))
+
+	flash := "Thread created successfully!"
+	thread, err := app.threads.Get(threadID, userSessionID)
+	if err == nil {
+		// Mentions in scheduled threads are recorded when they are published.
+		if thread.IsScheduled() {
+			flash = "Thread scheduled for " + humanDate(thread.ScheduledAt) + "."
+		} else {
+			app.logError(r, app.recordMentions(thread, messageID, userSessionID, form.Message))
+		}
+		err = app.fireThreadEvent(models.EventThreadCreated, thread, openingPost(thread))
+	}
+	app.logError(r, err)
+
+	app.sessionManager.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, fmt.Sprintf("/thread/view/%d", threadID), http.StatusSeeOther)
+}
+
+// threadView displays a single thread.
+func (app *application) threadView(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	thread, err := app.threads.Get(id, userSessionID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	data := app.newTemplateData(r)
+	err = app.loadMessages(thread, thread.Messages, userSessionID, data.IsModerator)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data.Thread = thread
+	// Feeds are read anonymously, so restricted threads have none.
+	if thread.Visibility == models.VisibilityPublic && !thread.IsScheduled() {
+		data.Feeds = append(data.Feeds, feedLink{Title: thread.Title, Path: fmt.Sprintf("/thread/view/%d/feed", thread.ID)})
+	}
+	if r.URL.Query().Get("view") == "tree" {
+		data.TreeView = true
+		data.Messages = messageTree(thread.Messages)
+	}
+
+	data.Poll, err = app.polls.ForThread(thread.ID, userSessionID)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data.IsOwner = userSessionID != 0 && userSessionID == thread.Author.ID
+	if data.IsOwner || data.IsModerator {
+		data.Groups, err = app.groups.ForUser(thread.Author.ID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+	data.Form = threadTagsForm{Tags: joinTags(thread.Tags)}
+	if userSessionID != 0 {
+		data.IsSubscribed, err = app.subscriptions.IsSubscribed(userSessionID, thread.ID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		data.FirstUnreadID, err = app.trackRead(thread, userSessionID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	for _, m := range thread.Messages {
+		data.LatestMessageID = max(data.LatestMessageID, m.ID)
+	}
+
+	app.render(w, r, http.StatusOK, "thread-view.tmpl", data)
+}
+
+// loadMessages fills in everything the given messages of a thread show
+// besides their own data, as seen by the user with the given id:
+// attachments, rendered Markdown, reactions, and the user's votes and
+// bookmarks on the thread and messages.
+func (app *application) loadMessages(
+	thread *models.Thread,
+	messages []*models.Message,
+	userID int,
+	isModerator bool,
+) error {
+	attachments, err := app.attachments.ForThread(thread.ID)
+	if err != nil {
+		return err
+	}
+	for _, m := range messages {
+		m.Attachments = attachments[m.ID]
+	}
+
+	mentions, err := app.mentions.ForThread(thread.ID)
+	if err != nil {
+		return err
+	}
+	app.renderMarkdown(messages, mentions)
+
+	reactions, err := app.reactions.ForThread(thread.ID, userID)
+	if err != nil {
+		return err
+	}
+	app.setReactions(messages, reactions)
+
+	if thread.Kind == models.KindQuestion && ((userID != 0 && userID == thread.Author.ID) || isModerator) {
+		for _, m := range messages {
+			m.CanAccept = !m.IsOpening
+		}
+	}
+
+	if userID == 0 {
+		return nil
+	}
+
+	var votes map[int]int
+	thread.Vote, votes, err = app.votes.ForThread(thread.ID, userID)
+	if err != nil {
+		return err
+	}
+	bookmarks, err := app.bookmarks.ForThread(userID, thread.ID)
+	if err != nil {
+		return err
+	}
+	thread.Bookmark = bookmarks[0]
+	for _, m := range messages {
+		m.Vote = votes[m.ID]
+		m.Bookmark = bookmarks[m.ID]
+	}
+	return nil
+}
+
+// trackRead flags the messages of a thread the user has not read yet and
+// returns the id of the first one, then marks the whole thread as read.
+// Nothing is flagged the first time a user opens a thread.
+func (app *application) trackRead(thread *models.Thread, userID int) (int, error) {
+	lastRead, seen, err := app.reads.LastRead(userID, thread.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	firstUnread, latest := 0, lastRead
+	for _, m := range thread.Messages {
+		if seen && m.ID > lastRead && m.Author.ID != userID {
+			m.IsUnread = true
+			if firstUnread == 0 {
+				firstUnread = m.ID
+			}
+		}
+		latest = max(latest, m.ID)
+	}
+	return firstUnread, app.reads.MarkRead(userID, thread.ID, latest)
+}
+
+// Timings of the live thread streams. Browsers reconnect
+// streamRetryInterval after losing a stream; a comment is sent every
+// streamHeartbeatInterval so that proxies do not close idle streams and
+// dead clients are noticed.
+const (
+	streamRetryInterval     = 5 * time.Second
+	streamHeartbeatInterval = 30 * time.Second
+)
+
+// threadTopic returns the broker topic new messages of a thread are
+// published to.
+func threadTopic(threadID int) string {
+	return fmt.Sprintf("thread:%d", threadID)
+}
+
+// threadEvents streams the messages posted to a thread as Server-Sent
+// Events, each holding the HTML of a message as rendered on the thread
+// page. Event ids are message ids: a client reconnecting with a
+// Last-Event-ID header, or connecting with ?after=, is first sent the
+// messages it missed.
+func (app *application) threadEvents(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	thread, err := app.threads.Get(id, userSessionID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	if thread.IsScheduled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	isModerator := false
+	if userSessionID != 0 {
+		user, err := app.users.GetUser(userSessionID)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, r, err)
+			return
+		}
+		isModerator = user != nil && user.IsModerator()
+	}
+
+	// Without a starting point only the messages posted from now on are sent.
+	lastID := 0
+	for _, m := range thread.Messages {
+		lastID = max(lastID, m.ID)
+	}
+	after := r.Header.Get("Last-Event-ID")
+	if after == "" {
+		after = r.URL.Query().Get("after")
+	}
+	if n, err := strconv.Atoi(after); err == nil && n >= 0 {
+		lastID = n
+	}
+
+	// Subscribe before catching up so that no message falls in between.
+	sub := app.broker.Subscribe(threadTopic(thread.ID))
+	defer sub.Close()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetryInterval.Milliseconds())
+
+	lastID, err = app.sendNewMessages(w, thread, userSessionID, isModerator, lastID)
+	if err != nil {
+		app.streamError(r, err)
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		err = rc.Flush()
+		if err != nil {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			_, err = io.WriteString(w, ": heartbeat\n\n")
+			if err != nil {
+				return
+			}
+		case _, ok := <-sub.C:
+			if !ok {
+				// Dropped by the broker for falling behind. The client
+				// reconnects and catches up from its last event id.
+				return
+			}
+			lastID, err = app.sendNewMessages(w, thread, userSessionID, isModerator, lastID)
+			if err != nil {
+				app.streamError(r, err)
+				return
+			}
+		}
+	}
+}
+
+// streamError logs an error that ended a live thread stream. Once streaming
+// has started no error page can be sent; a thread that was deleted or hidden
+// from the user simply ends the stream, and reconnecting gets a 404.
+func (app *application) streamError(r *http.Request, err error) {
+	if errors.Is(err, models.ErrNoRecord) {
+		return
+	}
+	app.logger.Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+}
+
+// sendNewMessages writes an event for each reply to a thread posted after
+// lastID, rendered for the user with the given id, and returns the id of
+// the latest message sent. It returns ErrNoRecord if the thread was hidden
+// from the user or deleted. Messages sent live count as read.
+func (app *application) sendNewMessages(
+	w io.Writer,
+	thread *models.Thread,
+	userID int,
+	isModerator bool,
+	lastID int,
+) (int, error) {
+	visible, err := app.threads.VisibleTo(thread.ID, userID)
+	if err != nil {
+		return lastID, err
+	}
+	if !visible {
+		return lastID, models.ErrNoRecord
+	}
+
+	messages, err := app.messages.After(thread.ID, lastID)
+	if err != nil {
+		return lastID, err
+	}
+	if len(messages) == 0 {
+		return lastID, nil
+	}
+
+	err = app.loadMessages(thread, messages, userID, isModerator)
+	if err != nil {
+		return lastID, err
+	}
+
+	ts := app.templateCache["thread-view.tmpl"]
+	buf := new(bytes.Buffer)
+	for _, m := range messages {
+		m.IsUnread = m.Author.ID != userID
+		buf.Reset()
+		err = ts.ExecuteTemplate(buf, "message", m)
+		if err != nil {
+			return lastID, err
+		}
+		err = writeEvent(w, m.ID, "message", strings.TrimSpace(buf.String()))
+		if err != nil {
+			return lastID, err
+		}
+		lastID = m.ID
+	}
+
+	if userID != 0 {
+		err = app.reads.MarkRead(userID, thread.ID, lastID)
+		if err != nil {
+			return lastID, err
+		}
+	}
+	return lastID, nil
+}
+
+// threadsReadAllPost marks every thread as read for the logged in user.
+func (app *application) threadsReadAllPost(w http.ResponseWriter, r *http.Request) {
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	err := app.reads.MarkAllRead(userSessionID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "All threads marked as read.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// createMessageForm holds the data for the message creation form.
+type createMessageForm struct {
+	Message   string
+	ReplyToID int
+	validator.Validator
+}
+
+// messageCreate displays the message creation form for a specific thread.
+func (app *application) messageCreate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	thread, err := app.threads.Get(id, userSessionID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	if thread.IsScheduled() {
+		// Nobody can reply to a thread before it is published.
+		http.NotFound(w, r)
+		return
+	}
+
+	// The reply_to query parameter is the id of the message replied to. If
+	// quote is set as well, the form is pre-filled with a quote of it.
+	form := createMessageForm{}
+	replyToID, _ := strconv.Atoi(r.URL.Query().Get("reply_to"))
+	replyTo := findMessage(thread, replyToID)
+	if replyTo != nil {
+		form.ReplyToID = replyTo.ID
+		if r.URL.Query().Has("quote") {
+			form.Message = quoteMessage(replyTo)
+		}
+	}
+
+	// A saved draft takes precedence over the quote.
+	draft, err := app.drafts.Get(userSessionID, thread.ID)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+	if draft != nil {
+		form.Message = draft.Body
+	}
+
+	data := app.newTemplateData(r)
+	data.Thread = thread
+	data.ReplyTo = replyTo
+	data.Draft = draft
+	data.Form = form
+
+	app.render(w, r, http.StatusOK, "message-create.tmpl", data)
+}
+
+// messageCreatePreview renders the message creation form along with a preview
+// of the submitted Markdown, exactly as it will be displayed once posted.
+func (app *application) messageCreatePreview(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	threadID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || threadID < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	thread, err := app.threads.Get(threadID, userSessionID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	if thread.IsScheduled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	form := createMessageForm{
+		Message: r.PostForm.Get("message"),
+	}
+	form.ReplyToID, _ = strconv.Atoi(r.PostForm.Get("reply_to_id"))
+
+	data := app.newTemplateData(r)
+	data.Thread = thread
+	data.ReplyTo = findMessage(thread, form.ReplyToID)
+	data.Form = form
+	data.Preview = markdown.Render(form.Message, markdown.Options{})
+
+	app.render(w, r, http.StatusOK, "message-create.tmpl", data)
+}
+
+// Limits on the files that can be attached to messages.
+const (
+	maxAttachments     = 5
+	maxAttachmentSize  = 5 << 20
+	maxAttachmentQuota = 50 << 20
+)
+
+// messageCreatePost creates a message and redirects to updated thead.
+func (app *application) messageCreatePost(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachments*maxAttachmentSize+1<<20)
+	err := r.ParseMultipartForm(1 << 20)
+	if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			app.clientError(w, http.StatusRequestEntityTooLarge)
+		} else {
+			app.clientError(w, http.StatusBadRequest)
+		}
+		return
+	}
+
+	threadID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || threadID < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	thread, err := app.threads.Get(threadID, userSessionID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	if thread.IsScheduled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	if userSessionID == 0 {
+		http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+		return
+	}
+
+	form := createMessageForm{
+		Message: r.PostForm.Get("message"),
+	}
+	form.ReplyToID, _ = strconv.Atoi(r.PostForm.Get("reply_to_id"))
+	replyTo := findMessage(thread, form.ReplyToID)
+
+	form.CheckField(form.ReplyToID == 0 || replyTo != nil, "message", "The message you are replying to does not exist.")
+	checkMessage(&form.Validator, form.Message)
+
+	var files []*multipart.FileHeader
+	if r.MultipartForm != nil {
+		files = r.MultipartForm.File["attachments"]
+	}
+	quotaExceeded := fmt.Sprintf("These files would exceed your %s upload quota.", humanSize(maxAttachmentQuota))
+	if len(files) > 0 {
+		used, err := app.attachments.UsedBytes(userSessionID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		form.CheckField(len(files) <= maxAttachments, "attachments", fmt.Sprintf("You cannot attach more than %d files.", maxAttachments))
+		for _, fh := range files {
+			form.CheckField(fh.Size <= maxAttachmentSize, "attachments", fmt.Sprintf("%s is larger than %s.", fh.Filename, humanSize(maxAttachmentSize)))
+			used += fh.Size
+		}
+		form.CheckField(used <= maxAttachmentQuota, "attachments", quotaExceeded)
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Thread = thread
+		data.ReplyTo = replyTo
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "message-create.tmpl", data)
+		return
+	}
+
+	attachments, err := app.storeAttachments(files, userSessionID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	messageID, err := app.messages.InsertMessage(form.Message, threadID, userSessionID, form.ReplyToID, attachments, maxAttachmentQuota)
+	if err != nil {
+		app.deleteAttachmentBlobs(attachments)
+		if !errors.Is(err, models.ErrQuotaExceeded) {
+			app.serverError(w, r, err)
+			return
+		}
+
+		// Concurrent uploads got past the check above.
+		form.AddFieldError("attachments", quotaExceeded)
+		data := app.newTemplateData(r)
+		data.Thread = thread
+		data.ReplyTo = replyTo
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "message-create.tmpl", data)
+		return
+	}
+
+	// The message is saved: from here on errors are logged rather than
+	// reported, so that the user does not post it again.
+	app.logError(r, app.recordMentions(thread, messageID, userSessionID, form.Message))
+	app.logError(r, app.notify(models.NotificationReply, thread.Author.ID, thread, userSessionID))
+	if replyTo != nil && replyTo.Author.ID != thread.Author.ID {
+		app.logError(r, app.notify(models.NotificationReply, replyTo.Author.ID, thread, userSessionID))
+	}
+	app.logError(r, app.subscriptions.Subscribe(userSessionID, thread.ID))
+	app.logError(r, app.emailSubscribers(thread, userSessionID, form.Message))
+	app.logError(r, app.drafts.Delete(userSessionID, thread.ID))
+
+	message, err := app.messages.Get(messageID)
+	if err == nil {
+		message.ReplyToID = form.ReplyToID
+		err = app.fireThreadEvent(models.EventMessageCreated, thread, message)
+	}
+	app.logError(r, err)
+	app.broker.Publish(threadTopic(thread.ID), broker.Event{Name: "message", ID: messageID})
+
+	app.sessionManager.Put(r.Context(), "flash", "Message created successfully!")
+	http.Redirect(w, r, fmt.Sprintf("/thread/view/%d", threadID), http.StatusSeeOther)
+}
+
+// recordMentions resolves the @mentions in a new message against the known
+// users and records them. Users who cannot see the thread, and the author
+// mentioning themselves, are skipped. Users who opted in are also emailed.
+func (app *application) recordMentions(
+	thread *models.Thread,
+	messageID int,
+	authorID int,
+	body string,
+) error {
+	names := markdown.Mentions(body)
+	users, err := app.users.GetByNames(names)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		for _, u := range users {
+			if !strings.EqualFold(u.Username, name) && u.Slug != strings.ToLower(name) {
+				continue
+			}
+			if u.ID == authorID || !app.threadVisibleTo(thread, u.ID) {
+				break
+			}
+
+			err = app.mentions.Insert(messageID, u.ID, name)
+			if err != nil {
+				return err
+			}
+
+			err = app.notify(models.NotificationMention, u.ID, thread, authorID)
+			if err != nil {
+				return err
+			}
+
+			if u.EmailMentions {
+				err = app.sendMentionEmail(u, thread)
+				if err != nil {
+					return err
+				}
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// sendMentionEmail emails a user about being mentioned in a thread.
+func (app *application) sendMentionEmail(user *models.User, thread *models.Thread) error {
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: fmt.Sprintf("You were mentioned in %q", thread.Title),
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nYou were mentioned in the thread %q:\n%s/thread/view/%d\n\n"+
+				"You can turn off these emails in your account settings:\n%s/account/view/%d\n",
+			user.Username, thread.Title, app.baseURL, thread.ID, app.baseURL, user.ID,
+		),
+	}
+
+	return app.queueEmail(msg)
+}
+
+// emailSubscribers emails the users subscribed to a thread who want to hear
+// about new messages immediately. Other subscribers get the message in their
+// next digest.
+func (app *application) emailSubscribers(thread *models.Thread, authorID int, body string) error {
+	author, err := app.users.GetUser(authorID)
+	if err != nil {
+		return err
+	}
+
+	subscribers, err := app.subscriptions.Subscribers(thread.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range subscribers {
+		if s.UserID == authorID || s.EmailFrequency != models.EmailImmediate {
+			continue
+		}
+		if !app.threadVisibleTo(thread, s.UserID) {
+			continue
+		}
+
+		unsubscribe := app.unsubscribeURL(s.UserID, thread.ID)
+		err = app.queueEmail(mailer.Message{
+			To:      s.Email,
+			Subject: fmt.Sprintf("New message in %q", thread.Title),
+			Body: fmt.Sprintf(
+				"Hi %s,\n\n%s wrote in the thread %q:\n\n%s\n\n%s/thread/view/%d\n\n"+
+					"Unsubscribe from this thread:\n%s\n",
+				s.Username, author.Username, thread.Title, body,
+				app.baseURL, thread.ID, unsubscribe,
+			),
+			Headers: map[string]string{
+				"List-Unsubscribe":      "<" + unsubscribe + ">",
+				"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// threadSubscribePost subscribes the logged in user to a thread.
+func (app *application) threadSubscribePost(w http.ResponseWriter, r *http.Request) {
+	app.setSubscription(w, r, true)
+}
+
+// threadUnsubscribePost unsubscribes the logged in user from a thread.
+func (app *application) threadUnsubscribePost(w http.ResponseWriter, r *http.Request) {
+	app.setSubscription(w, r, false)
+}
+
+// setSubscription subscribes or unsubscribes the logged in user to the
+// thread in the request path.
+func (app *application) setSubscription(w http.ResponseWriter, r *http.Request, subscribe bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	thread, err := app.threads.Get(id, userSessionID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	flash := "You will be emailed about new messages in this thread."
+	if subscribe {
+		err = app.subscriptions.Subscribe(userSessionID, thread.ID)
+	} else {
+		err = app.subscriptions.Unsubscribe(userSessionID, thread.ID)
+		flash = "You will no longer be emailed about this thread."
+	}
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, fmt.Sprintf("/thread/view/%d", thread.ID), http.StatusSeeOther)
+}
+
+// unsubscribeLink parses the user and thread ids of a signed unsubscription
+// link. ok is false if the link is malformed or its signature is invalid.
+func (app *application) unsubscribeLink(r *http.Request) (userID, threadID int, ok bool) {
+	userID, err := strconv.Atoi(r.PathValue("user"))
+	if err != nil || userID < 1 {
+		return 0, 0, false
+	}
+	threadID, err = strconv.Atoi(r.PathValue("thread"))
+	if err != nil || threadID < 1 {
+		return 0, 0, false
+	}
+	if !app.validUnsubscribeToken(userID, threadID, r.PathValue("token")) {
+		return 0, 0, false
+	}
+	return userID, threadID, true
+}
+
+// unsubscribe displays the confirmation page of an unsubscription link from
+// an email. It does not require logging in; the link is signed instead.
+func (app *application) unsubscribe(w http.ResponseWriter, r *http.Request) {
+	userID, threadID, ok := app.unsubscribeLink(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	thread, err := app.threads.Get(threadID, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Thread = thread
+	app.render(w, r, http.StatusOK, "unsubscribe.tmpl", data)
+}
+
+// unsubscribePost unsubscribes a user from a thread through a signed link.
+// It also serves the one-click unsubscription of mail clients (RFC 8058),
+// which post to the link directly.
+func (app *application) unsubscribePost(w http.ResponseWriter, r *http.Request) {
+	userID, threadID, ok := app.unsubscribeLink(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	err := app.subscriptions.Unsubscribe(userID, threadID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "You will no longer be emailed about this thread.")
+	http.Redirect(w, r, fmt.Sprintf("/thread/view/%d", threadID), http.StatusSeeOther)
+}
+
+// storeAttachments saves uploaded files to the blob storage under random
+// keys. The content type of each file is sniffed from its content rather than
+// trusted from the client.
+func (app *application) storeAttachments(
+	files []*multipart.FileHeader,
+	userID int,
+) ([]*models.Attachment, error) {
+	var attachments []*models.Attachment
+	for _, fh := range files {
+		a, err := app.storeAttachment(fh, userID)
+		if err != nil {
+			app.deleteAttachmentBlobs(attachments)
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, nil
+}
+
+// storeAttachment saves a single uploaded file to the blob storage.
+func (app *application) storeAttachment(
+	fh *multipart.FileHeader,
+	userID int,
+) (*models.Attachment, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, fmt.Errorf("opening upload: %w", err)
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("reading upload: %w", err)
+	}
+	head = head[:n]
+
+	key := make([]byte, 16)
+	_, err = rand.Read(key)
+	if err != nil {
+		return nil, fmt.Errorf("generating storage key: %w", err)
+	}
+
+	a := &models.Attachment{
+		UserID:      userID,
+		StorageKey:  "attachments/" + hex.EncodeToString(key),
+		Filename:    sanitizeFilename(fh.Filename),
+		ContentType: http.DetectContentType(head),
+		Size:        fh.Size,
+	}
+
+	err = app.storage.Put(a.StorageKey, io.MultiReader(bytes.NewReader(head), f))
+	if err != nil {
+		return nil, fmt.Errorf("storing upload: %w", err)
+	}
+	return a, nil
+}
+
+// deleteAttachmentBlobs removes the stored files of attachments that could
+// not be saved to the database. Failures are only logged.
+func (app *application) deleteAttachmentBlobs(attachments []*models.Attachment) {
+	for _, a := range attachments {
+		err := app.storage.Delete(a.StorageKey)
+		if err != nil {
+			app.logger.Error(err.Error(), "key", a.StorageKey)
+		}
+	}
+}
+
+// sanitizeFilename strips path elements and control characters from an
+// uploaded file name and limits its length.
+func sanitizeFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '/' || r == '\\' {
+			return -1
+		}
+		return r
+	}, filepath.Base(name))
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." {
+		return "attachment"
+	}
+	if utf8.RuneCountInString(name) > 255 {
+		name = string([]rune(name)[:255])
+	}
+	return name
+}
+
+// messageFromPath loads the message whose id is in the request path, along
+// with its thread. It writes the error response and returns false if there is
+// none or if the current user cannot read the thread.
+func (app *application) messageFromPath(w http.ResponseWriter, r *http.Request) (*models.Message, *models.Thread, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return nil, nil, false
+	}
+
+	message, err := app.messages.Get(id)
+	if err == nil {
+		var thread *models.Thread
+		userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+		thread, err = app.threads.Get(message.ThreadID, userSessionID)
+		if err == nil {
+			return message, thread, true
+		}
+	}
+
+	if errors.Is(err, models.ErrNoRecord) {
+		http.NotFound(w, r)
+	} else {
+		app.serverError(w, r, err)
+	}
+	return nil, nil, false
+}
+
+// messageReactPost toggles the current user's reaction to a message. Requests
+// made from JavaScript with an Accept: application/json header get the new
+// count back as JSON; plain form submissions are redirected to the message.
+func (app *application) messageReactPost(w http.ResponseWriter, r *http.Request) {
+	message, _, ok := app.messageFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	emoji := r.PostForm.Get("emoji")
+	if !slices.Contains(app.reactionSet, emoji) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	reacted, count, err := app.reactions.Toggle(message.ID, userSessionID, emoji)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(struct {
+			Emoji   string `json:"emoji"`
+			Count   int    `json:"count"`
+			Reacted bool   `json:"reacted"`
+		}{emoji, count, reacted})
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/thread/view/%d#message-%d", message.ThreadID, message.ID), http.StatusSeeOther)
+}
+
+// messageReactions lists the users who reacted to a message, grouped by emoji.
+func (app *application) messageReactions(w http.ResponseWriter, r *http.Request) {
+	message, thread, ok := app.messageFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	users, err := app.reactions.Users(message.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Thread = thread
+	data.Messages = []*models.Message{message}
+	for _, emoji := range app.reactionSet {
+		if len(users[emoji]) > 0 {
+			data.Reactors = append(data.Reactors, reactors{Emoji: emoji, Users: users[emoji]})
+		}
+	}
+
+	app.render(w, r, http.StatusOK, "message-reactions.tmpl", data)
+}
+
+// parseVote reads the value of a vote from the value form field: 1 for an up
+// vote, -1 for a down vote and 0 to withdraw it. It writes the error response
+// and returns false if the request is invalid.
+func (app *application) parseVote(w http.ResponseWriter, r *http.Request) (int, bool) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return 0, false
+	}
+
+	value, err := strconv.Atoi(r.PostForm.Get("value"))
+	if err != nil || value < -1 || value > 1 {
+		app.clientError(w, http.StatusBadRequest)
+		return 0, false
+	}
+	return value, true
+}
+
+// threadVotePost records the current user's vote on a thread. Users cannot
+// vote on their own threads.
+func (app *application) threadVotePost(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	thread, err := app.threads.Get(id, userSessionID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	value, ok := app.parseVote(w, r)
+	if !ok {
+		return
+	}
+
+	if thread.Author.ID == userSessionID {
+		app.sessionManager.Put(r.Context(), "flash", "You cannot vote on your own thread.")
+	} else {
+		_, err = app.votes.VoteThread(thread.ID, userSessionID, value)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/thread/view/%d", thread.ID), http.StatusSeeOther)
+}
+
+// threadAcceptPost marks a message as the accepted answer of a question, or
+// unmarks the accepted answer if message_id is 0. Only the author of the
+// question and moderators can choose it.
+func (app *application) threadAcceptPost(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	thread, err := app.threads.Get(id, userSessionID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.users.GetUser(userSessionID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if thread.Author.ID != user.ID && !user.IsModerator() {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+	if thread.Kind != models.KindQuestion {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	messageID, err := strconv.Atoi(r.PostForm.Get("message_id"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	flash := "Accepted answer removed."
+	if messageID != 0 {
+		message := findMessage(thread, messageID)
+		if message == nil || message.IsOpening {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		flash = "Answer accepted!"
+	}
+
+	err = app.threads.SetAccepted(thread.ID, messageID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if messageID != 0 && messageID != thread.AcceptedID {
+		err = app.notify(models.NotificationAccepted, findMessage(thread, messageID).Author.ID, thread, userSessionID)
+		app.logError(r, err)
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, fmt.Sprintf("/thread/view/%d", thread.ID), http.StatusSeeOther)
+}
+
+// pollFromPath loads the thread whose id is in the request path along with
+// its poll. It writes the error response and returns false if the current
+// user cannot read the thread or it has no poll.
+func (app *application) pollFromPath(w http.ResponseWriter, r *http.Request) (*models.Thread, *models.Poll, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return nil, nil, false
+	}
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	thread, err := app.threads.Get(id, userSessionID)
+	if err == nil {
+		var poll *models.Poll
+		poll, err = app.polls.ForThread(thread.ID, userSessionID)
+		if err == nil {
+			return thread, poll, true
+		}
+	}
+	if errors.Is(err, models.ErrNoRecord) {
+		http.NotFound(w, r)
+	} else {
+		app.serverError(w, r, err)
+	}
+	return nil, nil, false
+}
+
+// pollVotePost records the current user's vote in the poll of a thread.
+// Single choice polls take one option field, multiple choice polls any
+// number of them.
+func (app *application) pollVotePost(w http.ResponseWriter, r *http.Request) {
+	thread, poll, ok := app.pollFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	var optionIDs []int
+	for _, value := range r.PostForm["option"] {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		optionIDs = append(optionIDs, id)
+	}
+	// An option sent twice is a single answer.
+	slices.Sort(optionIDs)
+	optionIDs = slices.Compact(optionIDs)
+	if len(optionIDs) > 1 && !poll.Multiple {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	flash := "Your vote was recorded."
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	if len(optionIDs) == 0 {
+		flash = "Please choose an option."
+	} else {
+		err = app.polls.Vote(poll.ID, userSessionID, optionIDs)
+		switch {
+		case errors.Is(err, models.ErrAlreadyVoted):
+			flash = "You already voted in this poll."
+		case errors.Is(err, models.ErrPollClosed):
+			flash = "This poll is closed."
+		case errors.Is(err, models.ErrNoRecord):
+			app.clientError(w, http.StatusBadRequest)
+			return
+		case err != nil:
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, fmt.Sprintf("/thread/view/%d#poll", thread.ID), http.StatusSeeOther)
+}
+
+// pollClosePost closes the poll of a thread. Only the author of the thread
+// and moderators can close it.
+func (app *application) pollClosePost(w http.ResponseWriter, r *http.Request) {
+	thread, poll, ok := app.pollFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	user, err := app.users.GetUser(userSessionID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if thread.Author.ID != user.ID && !user.IsModerator() {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	err = app.polls.Close(poll.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Poll closed successfully!")
+	http.Redirect(w, r, fmt.Sprintf("/thread/view/%d#poll", thread.ID), http.StatusSeeOther)
+}
+
+// messageVotePost records the current user's vote on a message. Users cannot
+// vote on their own messages.
+func (app *application) messageVotePost(w http.ResponseWriter, r *http.Request) {
+	message, _, ok := app.messageFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	value, ok := app.parseVote(w, r)
+	if !ok {
+		return
+	}
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	if message.Author.ID == userSessionID {
+		app.sessionManager.Put(r.Context(), "flash", "You cannot vote on your own message.")
+	} else {
+		_, err := app.votes.VoteMessage(message.ID, userSessionID, value)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/thread/view/%d#message-%d", message.ThreadID, message.ID), http.StatusSeeOther)
+}
+
+// attachmentView serves an attached file. Attachments are only served if the
+// thread they were posted in can be viewed. Only images and plain text are
+// displayed inline; everything else is sent as an opaque download.
+func (app *application) attachmentView(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	a, err := app.attachments.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	_, err = app.threads.Get(a.ThreadID, userSessionID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	f, err := app.storage.Open(a.StorageKey)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	defer f.Close()
+
+	disposition, contentType := "attachment", "application/octet-stream"
+	if a.IsImage() || a.IsText() {
+		disposition, contentType = "inline", a.ContentType
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": a.Filename}))
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+
+	http.ServeContent(w, r, "", a.DateAdded, f)
+}
+
+// threadsPerPage is the number of threads listed on each page of a category.
+const threadsPerPage = 20
+
+// categoryView displays a page of the threads in a category.
+func (app *application) categoryView(w http.ResponseWriter, r *http.Request) {
+	category, err := app.categories.GetBySlug(r.PathValue("slug"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// Categories list their latest threads; only the unanswered filter of
+	// the home page applies.
+	sort := newThreadSort(r)
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	count, err := app.threads.CountByCategory(category.ID, sort.Unanswered, userSessionID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	page, ok := newPagination(r, count, threadsPerPage)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	threads, err := app.threads.ByCategory(category.ID, sort.Unanswered, userSessionID, threadsPerPage, page.Offset())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	category.Children, err = app.categories.Children(category.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Category = category
+	data.Threads = threads
+	data.Pagination = page
+	data.Sort = sort
+	data.Feeds = append(data.Feeds, feedLink{Title: category.Name, Path: "/category/" + category.Slug + "/feed"})
+	if category.ParentID != 0 {
+		data.ParentCategory, err = app.categories.Get(category.ParentID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	app.render(w, r, http.StatusOK, "category-view.tmpl", data)
+}
+
+// categoryForm holds the data for the category creation and edition forms.
+type categoryForm struct {
+	Name        string
+	Slug        string
+	Description string
+	Position    int
+	ParentID    int
+	validator.Validator
+}
+
+// adminCategories lists the categories along with the creation form.
+func (app *application) adminCategories(w http.ResponseWriter, r *http.Request) {
+	app.renderAdminCategories(w, r, http.StatusOK, categoryForm{})
+}
+
+// renderAdminCategories renders the category administration page with the
+// given creation form.
+func (app *application) renderAdminCategories(
+	w http.ResponseWriter,
+	r *http.Request,
+	status int,
+	form categoryForm,
+) {
+	categories, err := app.categories.All()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Categories = categories
+	data.Form = form
+	app.render(w, r, status, "admin-categories.tmpl", data)
+}
+
+// adminCategoryCreatePost creates a category.
+func (app *application) adminCategoryCreatePost(w http.ResponseWriter, r *http.Request) {
+	form, ok := app.parseCategoryForm(w, r, 0)
+	if !ok {
+		return
+	}
+	if !form.Valid() {
+		app.renderAdminCategories(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+
+	_, err := app.categories.Insert(form.category(0))
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateSlug) {
+			form.AddFieldError("slug", "This slug is already used by another category.")
+			app.renderAdminCategories(w, r, http.StatusUnprocessableEntity, form)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Category created successfully!")
+	http.Redirect(w, r, "/admin/categories", http.StatusSeeOther)
+}
+
+// adminCategoryEdit displays the edition form of a category.
+func (app *application) adminCategoryEdit(w http.ResponseWriter, r *http.Request) {
+	category, ok := app.categoryFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	app.renderAdminCategoryEdit(w, r, http.StatusOK, category, categoryForm{
+		Name:        category.Name,
+		Slug:        category.Slug,
+		Description: category.Description,
+		Position:    category.Position,
+		ParentID:    category.ParentID,
+	})
+}
+
+// renderAdminCategoryEdit renders the edition page of a category with the
+// given form.
+func (app *application) renderAdminCategoryEdit(
+	w http.ResponseWriter,
+	r *http.Request,
+	status int,
+	category *models.Category,
+	form categoryForm,
+) {
+	categories, err := app.categories.All()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Category = category
+	data.Categories = categories
+	data.Form = form
+	app.render(w, r, status, "admin-category-edit.tmpl", data)
+}
+
+// adminCategoryEditPost saves the changes made to a category.
+func (app *application) adminCategoryEditPost(w http.ResponseWriter, r *http.Request) {
+	category, ok := app.categoryFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	form, ok := app.parseCategoryForm(w, r, category.ID)
+	if !ok {
+		return
+	}
+	if !form.Valid() {
+		app.renderAdminCategoryEdit(w, r, http.StatusUnprocessableEntity, category, form)
+		return
+	}
+
+	err := app.categories.Update(form.category(category.ID))
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateSlug) {
+			form.AddFieldError("slug", "This slug is already used by another category.")
+			app.renderAdminCategoryEdit(w, r, http.StatusUnprocessableEntity, category, form)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Category updated successfully!")
+	http.Redirect(w, r, "/admin/categories", http.StatusSeeOther)
+}
+
+// adminCategoryDeletePost deletes a category that holds no threads and no
+// sub-categories.
+func (app *application) adminCategoryDeletePost(w http.ResponseWriter, r *http.Request) {
+	category, ok := app.categoryFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	flash := "Category deleted successfully!"
+	err := app.categories.Delete(category.ID)
+	if err != nil {
+		if !errors.Is(err, models.ErrCategoryInUse) {
+			app.serverError(w, r, err)
+			return
+		}
+		flash = "Only categories without threads or sub-categories can be deleted."
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, "/admin/categories", http.StatusSeeOther)
+}
+
+// categoryFromPath loads the category whose id is in the request path. It
+// writes the error response and returns false if there is none.
+func (app *application) categoryFromPath(w http.ResponseWriter, r *http.Request) (*models.Category, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return nil, false
+	}
+
+	category, err := app.categories.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return nil, false
+	}
+	return category, true
+}
+
+// parseCategoryForm parses and validates the category form for the category
+// with the given id, zero for a new category. It writes the error response
+// and returns false if the request cannot be parsed.
+func (app *application) parseCategoryForm(w http.ResponseWriter, r *http.Request, id int) (categoryForm, bool) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return categoryForm{}, false
+	}
+
+	form := categoryForm{
+		Name:        strings.TrimSpace(r.PostForm.Get("name")),
+		Slug:        strings.TrimSpace(r.PostForm.Get("slug")),
+		Description: r.PostForm.Get("description"),
+	}
+	if form.Slug == "" {
+		form.Slug = models.Slugify(form.Name)
+	}
+
+	position, err := strconv.Atoi(r.PostForm.Get("position"))
+	form.CheckField(err == nil, "position", "This field must be a whole number.")
+	form.Position = position
+	form.ParentID, _ = strconv.Atoi(r.PostForm.Get("parent_id"))
+
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank.")
+	form.CheckField(validator.MaxChars(form.Name, 100), "name", "This field cannot be more than 100 characters.")
+	form.CheckField(validator.NotBlank(form.Slug), "slug", "This field cannot be blank.")
+	form.CheckField(validator.MaxChars(form.Slug, 100), "slug", "This field cannot be more than 100 characters.")
+	form.CheckField(form.Slug == models.Slugify(form.Slug), "slug", "This field can only contain lowercase letters, digits and dashes.")
+	form.CheckField(validator.MaxChars(form.Description, 500), "description", "This field cannot be more than 500 characters.")
+
+	// Categories nest one level deep: the parent must be another top level
+	// category, and a category with sub-categories cannot get a parent.
+	if form.ParentID != 0 {
+		parent, err := app.categories.Get(form.ParentID)
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			form.AddFieldError("parent_id", "This category does not exist.")
+		case err != nil:
+			app.serverError(w, r, err)
+			return categoryForm{}, false
+		case parent.ID == id || parent.ParentID != 0:
+			form.AddFieldError("parent_id", "Only other top level categories can be parents.")
+		}
+
+		if id != 0 {
+			children, err := app.categories.Children(id)
+			if err != nil {
+				app.serverError(w, r, err)
+				return categoryForm{}, false
+			}
+			form.CheckField(len(children) == 0, "parent_id", "A category with sub-categories cannot have a parent.")
+		}
+	}
+
+	return form, true
+}
+
+// category returns the category described by the form.
+func (f categoryForm) category(id int) *models.Category {
+	return &models.Category{
+		ID:          id,
+		Name:        f.Name,
+		Slug:        f.Slug,
+		Description: f.Description,
+		Position:    f.Position,
+		ParentID:    f.ParentID,
+	}
+}
+
+// threadTagsForm holds the data for the thread tags edition form.
+type threadTagsForm struct {
+	Tags string
+	validator.Validator
+}
+
+// threadVisibilityPost changes who can read a thread. Only its author and
+// moderators can change it.
+func (app *application) threadVisibilityPost(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	thread, err := app.threads.Get(id, userSessionID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.users.GetUser(userSessionID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if thread.Author.ID != user.ID && !user.IsModerator() {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	groups, err := app.groups.ForUser(thread.Author.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	visibility := r.PostForm.Get("visibility")
+	groupID, _ := strconv.Atoi(r.PostForm.Get("group_id"))
+	var v validator.Validator
+	checkVisibility(&v, visibility, groupID, groups)
+	if !v.Valid() {
+		for _, msg := range v.FieldErrors {
+			app.sessionManager.Put(r.Context(), "flash", msg)
+		}
+		http.Redirect(w, r, fmt.Sprintf("/thread/view/%d", thread.ID), http.StatusSeeOther)
+		return
+	}
+
+	err = app.threads.SetVisibility(thread.ID, visibility, groupID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Visibility updated successfully!")
+	http.Redirect(w, r, fmt.Sprintf("/thread/view/%d", thread.ID), http.StatusSeeOther)
+}
+
+// joinTags returns the names of tags as a comma separated list.
+func joinTags(tags []*models.Tag) string {
+	names := make([]string, len(tags))
+	for i, t := range tags {
+		names[i] = t.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// threadTagsPost replaces the tags of a thread. Only the author of the
+// thread and moderators can edit its tags.
+func (app *application) threadTagsPost(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	thread, err := app.threads.Get(id, userSessionID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.users.GetUser(userSessionID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if thread.Author.ID != user.ID && !user.IsModerator() {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	form := threadTagsForm{Tags: r.PostForm.Get("tags")}
+	tags := parseTags(form.Tags)
+	checkTags(&form.Validator, tags)
+
+	if !form.Valid() {
+		app.sessionManager.Put(r.Context(), "flash", form.FieldErrors["tags"])
+		http.Redirect(w, r, fmt.Sprintf("/thread/view/%d", thread.ID), http.StatusSeeOther)
+		return
+	}
+
+	err = app.tags.SetForThread(thread.ID, tags)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Tags updated successfully!")
+	http.Redirect(w, r, fmt.Sprintf("/thread/view/%d", thread.ID), http.StatusSeeOther)
+}
+
+// tagView displays a page of the threads tagged with a tag.
+func (app *application) tagView(w http.ResponseWriter, r *http.Request) {
+	tag, err := app.tags.GetBySlug(r.PathValue("slug"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	slugs := []string{tag.Slug}
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	count, err := app.threads.CountByTags(slugs, true, userSessionID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	page, ok := newPagination(r, count, threadsPerPage)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	threads, err := app.threads.ByTags(slugs, true, userSessionID, threadsPerPage, page.Offset())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Tag = tag
+	data.Threads = threads
+	data.Pagination = page
+	data.Feeds = append(data.Feeds, feedLink{Title: "Threads tagged " + tag.Name, Path: "/tag/" + tag.Slug + "/feed"})
+	app.render(w, r, http.StatusOK, "tag-view.tmpl", data)
+}
+
+// tagsAutocomplete returns as JSON the most used tags starting with the
+// last tag of the comma separated list in the q query parameter.
+func (app *application) tagsAutocomplete(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	if i := strings.LastIndexByte(q, ','); i >= 0 {
+		q = q[i+1:]
+	}
+	q = strings.Join(strings.Fields(q), " ")
+
+	type suggestion struct {
+		Name    string `json:"name"`
+		Slug    string `json:"slug"`
+		Threads int    `json:"threads"`
+	}
+	suggestions := []suggestion{}
+
+	if q != "" {
+		userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+		tags, err := app.tags.Search(q, userSessionID, 10)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		for _, t := range tags {
+			suggestions = append(suggestions, suggestion{t.Name, t.Slug, t.ThreadCount})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(suggestions)
+	if err != nil {
+		app.logger.Error(err.Error())
+	}
+}
+
+// tagForm holds the data for the tag renaming and merging forms.
+type tagForm struct {
+	TagID int
+	Name  string
+	Into  string
+	validator.Validator
+}
+
+// moderateTags lists every tag with the forms to rename and merge them.
+func (app *application) moderateTags(w http.ResponseWriter, r *http.Request) {
+	app.renderModerateTags(w, r, http.StatusOK, tagForm{})
+}
+
+// renderModerateTags renders the tag moderation page with the given form
+// errors, which belong to the tag form.TagID.
+func (app *application) renderModerateTags(w http.ResponseWriter, r *http.Request, status int, form tagForm) {
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	tags, err := app.tags.All(userSessionID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Tags = tags
+	data.Form = form
+	app.render(w, r, status, "moderate-tags.tmpl", data)
+}
+
+// tagFromPath loads the tag whose id is in the request path. It writes the
+// error response and returns false if there is none.
+func (app *application) tagFromPath(w http.ResponseWriter, r *http.Request) (*models.Tag, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return nil, false
+	}
+
+	tag, err := app.tags.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return nil, false
+	}
+	return tag, true
+}
+
+// moderateTagRenamePost renames a tag.
+func (app *application) moderateTagRenamePost(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	tag, ok := app.tagFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	form := tagForm{TagID: tag.ID}
+	names := parseTags(r.PostForm.Get("name"))
+	if len(names) > 0 {
+		form.Name = names[0]
+	}
+	form.CheckField(len(names) == 1, "name", "Please enter a single tag name.")
+	checkTags(&form.Validator, names)
+
+	if form.Valid() {
+		err = app.tags.Rename(tag.ID, form.Name)
+		if errors.Is(err, models.ErrDuplicateSlug) {
+			form.AddFieldError("name", "Another tag already has this name; merge the tags instead.")
+		} else if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+	if !form.Valid() {
+		app.renderModerateTags(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Tag %q renamed to %q.", tag.Name, form.Name))
+	http.Redirect(w, r, "/moderate/tags", http.StatusSeeOther)
+}
+
+// moderateTagMergePost merges a tag into another one, retagging its threads.
+func (app *application) moderateTagMergePost(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	tag, ok := app.tagFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	form := tagForm{TagID: tag.ID, Into: r.PostForm.Get("into")}
+	into, err := app.tags.GetBySlug(models.Slugify(form.Into))
+	switch {
+	case errors.Is(err, models.ErrNoRecord):
+		form.AddFieldError("into", "This tag does not exist.")
+	case err != nil:
+		app.serverError(w, r, err)
+		return
+	case into.ID == tag.ID:
+		form.AddFieldError("into", "A tag cannot be merged into itself.")
+	}
+
+	if !form.Valid() {
+		app.renderModerateTags(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+
+	err = app.tags.Merge(tag.ID, into.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Tag %q merged into %q.", tag.Name, into.Name))
+	http.Redirect(w, r, "/moderate/tags", http.StatusSeeOther)
+}
+
+// maxRecipients is the largest number of users a conversation can be started
+// with, besides its author.
+const maxRecipients = 9
+
+// conversationsPerPage is the number of conversations listed on each page of
+// the inbox.
+const conversationsPerPage = 20
+
+// conversationForm holds the data for the form starting a conversation. To
+// is a comma separated list of usernames.
+type conversationForm struct {
+	To      string
+	Subject string
+	Message string
+	validator.Validator
+}
+
+// conversationReplyForm holds the data for the form replying to a
+// conversation.
+type conversationReplyForm struct {
+	Message string
+	validator.Validator
+}
+
+// conversationsView displays a page of the conversations of the logged in
+// user, most recently updated first.
+func (app *application) conversationsView(w http.ResponseWriter, r *http.Request) {
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	count, err := app.conversations.CountInbox(userSessionID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	page, ok := newPagination(r, count, conversationsPerPage)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	conversations, err := app.conversations.Inbox(userSessionID, conversationsPerPage, page.Offset())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Conversations = conversations
+	data.Pagination = page
+
+	app.render(w, r, http.StatusOK, "conversations.tmpl", data)
+}
+
+// conversationCreate displays the form to start a conversation. The to query
+// parameter pre-fills its recipients.
+func (app *application) conversationCreate(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = conversationForm{To: r.URL.Query().Get("to")}
+	app.render(w, r, http.StatusOK, "conversation-create.tmpl", data)
+}
+
+// conversationCreatePost starts a conversation with the users named in the
+// form. Users cannot start a conversation with someone they blocked or who
+// blocked them.
+func (app *application) conversationCreatePost(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := conversationForm{
+		To:      r.PostForm.Get("to"),
+		Subject: r.PostForm.Get("subject"),
+		Message: r.PostForm.Get("message"),
+	}
+
+	form.CheckField(validator.NotBlank(form.Subject), "subject", "This field cannot be blank.")
+	form.CheckField(validator.MaxChars(form.Subject, 100), "subject", "This field cannot be more than 100 characters).")
+	checkMessage(&form.Validator, form.Message)
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	recipients, err := app.recipients(&form, userSessionID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "conversation-create.tmpl", data)
+		return
+	}
+
+	id, err := app.conversations.Insert(form.Subject, userSessionID, recipients, form.Message)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your message was sent.")
+	http.Redirect(w, r, fmt.Sprintf("/conversation/%d", id), http.StatusSeeOther)
+}
+
+// recipients resolves the usernames in the To field of a conversation form to
+// user ids, recording errors under the to field for unknown users, for the
+// author themselves and for users with a block between them and the author.
+func (app *application) recipients(form *conversationForm, authorID int) ([]int, error) {
+	names := make(map[string]string)
+	var keys []string
+	for _, name := range strings.Split(form.To, ",") {
+		name = strings.TrimSpace(name)
+		key := strings.ToLower(name)
+		if name == "" || names[key] != "" {
+			continue
+		}
+		names[key] = name
+		keys = append(keys, key)
+	}
+
+	form.CheckField(len(keys) > 0, "to", "This field cannot be blank.")
+	form.CheckField(len(keys) <= maxRecipients, "to", fmt.Sprintf("A conversation cannot have more than %d recipients.", maxRecipients))
+	if len(keys) == 0 || len(keys) > maxRecipients {
+		return nil, nil
+	}
+
+	users, err := app.users.GetByNames(keys)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]*models.User)
+	for _, key := range keys {
+		var found *models.User
+		for _, u := range users {
+			if strings.ToLower(u.Username) == key || u.Slug == key {
+				found = u
+				break
+			}
+		}
+		form.CheckField(found != nil, "to", fmt.Sprintf("There is no user named %q.", names[key]))
+		if found == nil {
+			continue
+		}
+		form.CheckField(found.ID != authorID, "to", "You cannot send a message to yourself.")
+		if found.ID != authorID {
+			byID[found.ID] = found
+		}
+	}
+
+	ids := make([]int, 0, len(byID))
+	for id := range byID {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	blocked, err := app.blocks.Between(authorID, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range blocked {
+		form.AddFieldError("to", fmt.Sprintf("You cannot start a conversation with %s.", byID[id].Username))
+	}
+	return ids, nil
+}
+
+// conversationFromPath loads the conversation whose id is in the request
+// path. It writes a 404 response and returns false if there is none or if the
+// logged in user does not participate in it.
+func (app *application) conversationFromPath(w http.ResponseWriter, r *http.Request) (*models.Conversation, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return nil, false
+	}
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	conversation, err := app.conversations.Get(id, userSessionID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return nil, false
+	}
+
+	for _, pm := range conversation.Messages {
+		key := fmt.Sprintf("private:%d", pm.ID)
+		pm.BodyHTML = app.markdown.Render(key, pm.Body, markdown.Options{})
+	}
+	return conversation, true
+}
+
+// conversationView displays a conversation, then marks it as read.
+func (app *application) conversationView(w http.ResponseWriter, r *http.Request) {
+	conversation, ok := app.conversationFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Conversation = conversation
+	data.Form = conversationReplyForm{}
+
+	app.render(w, r, http.StatusOK, "conversation-view.tmpl", data)
+
+	if n := len(conversation.Messages); n > 0 {
+		userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+		err := app.conversations.MarkRead(conversation.ID, userSessionID, conversation.Messages[n-1].ID)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	}
+}
+
+// conversationReplyPost adds a message to a conversation.
+func (app *application) conversationReplyPost(w http.ResponseWriter, r *http.Request) {
+	conversation, ok := app.conversationFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := conversationReplyForm{
+		Message: r.PostForm.Get("message"),
+	}
+	checkMessage(&form.Validator, form.Message)
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Conversation = conversation
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "conversation-view.tmpl", data)
+		return
+	}
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	id, err := app.conversations.Reply(conversation.ID, userSessionID, form.Message)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/conversation/%d#message-%d", conversation.ID, id), http.StatusSeeOther)
+}
+
+// userBlockPost blocks the user whose profile slug is in the request path.
+func (app *application) userBlockPost(w http.ResponseWriter, r *http.Request) {
+	app.setBlocked(w, r, true)
+}
+
+// userUnblockPost unblocks the user whose profile slug is in the request
+// path.
+func (app *application) userUnblockPost(w http.ResponseWriter, r *http.Request) {
+	app.setBlocked(w, r, false)
+}
+
+// setBlocked blocks or unblocks the user whose profile slug is in the request
+// path, then redirects to their profile.
+func (app *application) setBlocked(w http.ResponseWriter, r *http.Request, block bool) {
+	user, err := app.users.GetBySlug(r.PathValue("slug"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	if user.ID == userSessionID {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	flash := fmt.Sprintf("%s can no longer start conversations with you.", user.Username)
+	if block {
+		err = app.blocks.Block(userSessionID, user.ID)
+	} else {
+		err = app.blocks.Unblock(userSessionID, user.ID)
+		flash = fmt.Sprintf("%s is no longer blocked.", user.Username)
+	}
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, "/user/"+user.Slug, http.StatusSeeOther)
+}
+
+// groupForm holds the data for the group creation form.
+type groupForm struct {
+	Name        string
+	Description string
+	validator.Validator
+}
+
+// groupMemberForm holds the data for the form adding a member to a group.
+type groupMemberForm struct {
+	Username string
+	validator.Validator
+}
+
+// adminGroups lists the groups along with the creation form.
+func (app *application) adminGroups(w http.ResponseWriter, r *http.Request) {
+	app.renderAdminGroups(w, r, http.StatusOK, groupForm{})
+}
+
+// renderAdminGroups renders the group administration page with the given
+// creation form.
+func (app *application) renderAdminGroups(w http.ResponseWriter, r *http.Request, status int, form groupForm) {
+	groups, err := app.groups.All()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Groups = groups
+	data.Form = form
+	app.render(w, r, status, "admin-groups.tmpl", data)
+}
+
+// adminGroupCreatePost creates a group.
+func (app *application) adminGroupCreatePost(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := groupForm{
+		Name:        strings.TrimSpace(r.PostForm.Get("name")),
+		Description: strings.TrimSpace(r.PostForm.Get("description")),
+	}
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank.")
+	form.CheckField(validator.MaxChars(form.Name, 50), "name", "This field cannot be more than 50 characters.")
+	form.CheckField(form.Name == "" || models.Slugify(form.Name) != "", "name", "The name must contain a letter or a digit.")
+	form.CheckField(validator.MaxChars(form.Description, 500), "description", "This field cannot be more than 500 characters.")
+
+	if !form.Valid() {
+		app.renderAdminGroups(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+
+	id, err := app.groups.Insert(form.Name, form.Description)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateSlug) {
+			form.AddFieldError("name", "A group with this name already exists.")
+			app.renderAdminGroups(w, r, http.StatusUnprocessableEntity, form)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Group created successfully!")
+	http.Redirect(w, r, fmt.Sprintf("/admin/groups/%d", id), http.StatusSeeOther)
+}
+
+// groupFromPath loads the group whose id is in the request path. It writes
+// the error response and returns false if there is none.
+func (app *application) groupFromPath(w http.ResponseWriter, r *http.Request) (*models.Group, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return nil, false
+	}
+
+	group, err := app.groups.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return nil, false
+	}
+	return group, true
+}
+
+// adminGroup lists the members of a group along with the form to add one.
+func (app *application) adminGroup(w http.ResponseWriter, r *http.Request) {
+	group, ok := app.groupFromPath(w, r)
+	if !ok {
+		return
+	}
+	app.renderAdminGroup(w, r, http.StatusOK, group, groupMemberForm{})
+}
+
+// renderAdminGroup renders the page of a group with the given member form.
+func (app *application) renderAdminGroup(
+	w http.ResponseWriter,
+	r *http.Request,
+	status int,
+	group *models.Group,
+	form groupMemberForm,
+) {
+	members, err := app.groups.Members(group.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Group = group
+	data.Members = members
+	data.Form = form
+	app.render(w, r, status, "admin-group.tmpl", data)
+}
+
+// adminGroupMemberAddPost adds the user with the given username to a group.
+func (app *application) adminGroupMemberAddPost(w http.ResponseWriter, r *http.Request) {
+	group, ok := app.groupFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := groupMemberForm{Username: strings.TrimSpace(r.PostForm.Get("username"))}
+	form.CheckField(validator.NotBlank(form.Username), "username", "This field cannot be blank.")
+
+	var user *models.User
+	if form.Valid() {
+		users, err := app.users.GetByNames([]string{form.Username})
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		if len(users) > 0 {
+			user = users[0]
+		}
+		form.CheckField(user != nil, "username", fmt.Sprintf("There is no user named %q.", form.Username))
+	}
+
+	if !form.Valid() {
+		app.renderAdminGroup(w, r, http.StatusUnprocessableEntity, group, form)
+		return
+	}
+
+	err = app.groups.AddMember(group.ID, user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("%s was added to the group.", user.Username))
+	http.Redirect(w, r, fmt.Sprintf("/admin/groups/%d", group.ID), http.StatusSeeOther)
+}
+
+// adminGroupMemberRemovePost removes a user from a group. They immediately
+// lose access to the threads restricted to it.
+func (app *application) adminGroupMemberRemovePost(w http.ResponseWriter, r *http.Request) {
+	group, ok := app.groupFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.Atoi(r.PathValue("user"))
+	if err != nil || userID < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	err = app.groups.RemoveMember(group.ID, userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Member removed successfully!")
+	http.Redirect(w, r, fmt.Sprintf("/admin/groups/%d", group.ID), http.StatusSeeOther)
+}
+
+// adminGroupDeletePost deletes a group no thread is restricted to.
+func (app *application) adminGroupDeletePost(w http.ResponseWriter, r *http.Request) {
+	group, ok := app.groupFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	flash := "Group deleted successfully!"
+	err := app.groups.Delete(group.ID)
+	if err != nil {
+		if !errors.Is(err, models.ErrGroupInUse) {
+			app.serverError(w, r, err)
+			return
+		}
+		flash = "Only groups no thread is restricted to can be deleted."
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, "/admin/groups", http.StatusSeeOther)
+}
+
+const (
+	bookmarksPerPage = 20
+	maxBookmarkNote  = 500
+)
+
+// bookmarksView lists the bookmarks of the current user matching the q query
+// parameter, newest first.
+func (app *application) bookmarksView(w http.ResponseWriter, r *http.Request) {
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	count, err := app.bookmarks.CountForUser(userSessionID, query)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	page, ok := newPagination(r, count, bookmarksPerPage)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	bookmarks, err := app.bookmarks.ForUser(userSessionID, query, bookmarksPerPage, page.Offset())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Bookmarks = bookmarks
+	data.Search = query
+	data.Pagination = page
+	app.render(w, r, http.StatusOK, "bookmarks.tmpl", data)
+}
+
+// threadBookmarkPost bookmarks a thread for the current user, or updates the
+// note of its bookmark.
+func (app *application) threadBookmarkPost(w http.ResponseWriter, r *http.Request) {
+	app.setThreadBookmark(w, r, true)
+}
+
+// threadUnbookmarkPost removes the current user's bookmark on a thread.
+func (app *application) threadUnbookmarkPost(w http.ResponseWriter, r *http.Request) {
+	app.setThreadBookmark(w, r, false)
+}
+
+// setThreadBookmark saves or removes the current user's bookmark on the
+// thread whose id is in the request path.
+func (app *application) setThreadBookmark(w http.ResponseWriter, r *http.Request, save bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	thread, err := app.threads.Get(id, userSessionID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.setBookmark(w, r, thread.ID, 0, save, fmt.Sprintf("/thread/view/%d", thread.ID))
+}
+
+// messageBookmarkPost bookmarks a message for the current user, or updates
+// the note of its bookmark.
+func (app *application) messageBookmarkPost(w http.ResponseWriter, r *http.Request) {
+	app.setMessageBookmark(w, r, true)
+}
+
+// messageUnbookmarkPost removes the current user's bookmark on a message.
+func (app *application) messageUnbookmarkPost(w http.ResponseWriter, r *http.Request) {
+	app.setMessageBookmark(w, r, false)
+}
+
+// setMessageBookmark saves or removes the current user's bookmark on the
+// message whose id is in the request path.
+func (app *application) setMessageBookmark(w http.ResponseWriter, r *http.Request, save bool) {
+	message, _, ok := app.messageFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	redirect := fmt.Sprintf("/thread/view/%d#message-%d", message.ThreadID, message.ID)
+	app.setBookmark(w, r, message.ThreadID, message.ID, save, redirect)
+}
+
+// setBookmark saves, with the note of the submitted form, or removes the
+// current user's bookmark on a thread or, if messageID is not zero, on one of
+// its messages, then redirects to redirect. Forms submitted from the
+// bookmarks page send a next field set to "bookmarks" to go back to it.
+func (app *application) setBookmark(
+	w http.ResponseWriter,
+	r *http.Request,
+	threadID, messageID int,
+	save bool,
+	redirect string,
+) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	if r.PostForm.Get("next") == "bookmarks" {
+		redirect = "/bookmarks"
+	}
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	note := strings.TrimSpace(r.PostForm.Get("note"))
+	flash := "Bookmark saved."
+	switch {
+	case !save:
+		err = app.bookmarks.Remove(userSessionID, threadID, messageID)
+		flash = "Bookmark removed."
+	case !validator.MaxChars(note, maxBookmarkNote):
+		flash = fmt.Sprintf("Bookmark notes cannot be more than %d characters.", maxBookmarkNote)
+	default:
+		err = app.bookmarks.Save(userSessionID, threadID, messageID, note)
+	}
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// threadDraftPost saves the title and opening post of the thread creation
+// form as the current user's draft of a new thread.
+func (app *application) threadDraftPost(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	app.saveDraft(w, r, userSessionID, 0, r.PostForm.Get("title"), "/thread/create")
+}
+
+// messageDraftPost saves the body of the message creation form as the
+// current user's draft for a thread.
+func (app *application) messageDraftPost(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachments*maxAttachmentSize+1<<20)
+	err := r.ParseMultipartForm(1 << 20)
+	if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	thread, err := app.threads.Get(id, userSessionID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	if thread.IsScheduled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	redirect := composeURL(thread.ID)
+	if replyToID, _ := strconv.Atoi(r.PostForm.Get("reply_to_id")); replyToID > 0 {
+		redirect += fmt.Sprintf("?reply_to=%d", replyToID)
+	}
+	app.saveDraft(w, r, userSessionID, thread.ID, "", redirect)
+}
+
+// saveDraft saves the message field of the submitted form, along with the
+// given title, as a draft, then redirects to redirect. Saving an empty draft
+// deletes it.
+func (app *application) saveDraft(
+	w http.ResponseWriter,
+	r *http.Request,
+	userID, threadID int,
+	title string,
+	redirect string,
+) {
+	title = strings.TrimSpace(title)
+	body := r.PostForm.Get("message")
+
+	var err error
+	if title == "" && strings.TrimSpace(body) == "" {
+		err = app.drafts.Delete(userID, threadID)
+	} else {
+		err = app.drafts.Save(userID, threadID, title, body)
+	}
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Draft saved.")
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// draftDeletePost discards the current user's draft for the thread in the
+// request path, or their draft of a new thread if it is 0.
+func (app *application) draftDeletePost(w http.ResponseWriter, r *http.Request) {
+	threadID, err := strconv.Atoi(r.PathValue("thread"))
+	if err != nil || threadID < 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	err = app.drafts.Delete(userSessionID, threadID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Draft discarded.")
+	http.Redirect(w, r, composeURL(threadID), http.StatusSeeOther)
+}
+
+// scheduleForm holds the data for the form editing a scheduled thread.
+// PublishAt holds the value of a datetime-local input.
+type scheduleForm struct {
+	Title     string
+	Message   string
+	PublishAt string
+	validator.Validator
+}
+
+// scheduledThreads lists the current user's threads waiting to be published.
+func (app *application) scheduledThreads(w http.ResponseWriter, r *http.Request) {
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	threads, err := app.threads.Scheduled(userSessionID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Threads = threads
+	app.render(w, r, http.StatusOK, "threads-scheduled.tmpl", data)
+}
+
+// scheduledFromPath loads the thread in the request path along with its
+// opening post. Only the author of a thread that is still scheduled gets
+// it: anyone else gets a 404. It reports whether the handler can go on.
+func (app *application) scheduledFromPath(
+	w http.ResponseWriter,
+	r *http.Request,
+) (*models.Thread, *models.Message, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return nil, nil, false
+	}
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	thread, err := app.threads.Get(id, userSessionID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return nil, nil, false
+	}
+	if !thread.IsScheduled() || thread.Author.ID != userSessionID {
+		http.NotFound(w, r)
+		return nil, nil, false
+	}
+
+	opening := openingPost(thread)
+	if opening == nil {
+		app.serverError(w, r, fmt.Errorf("thread %d has no opening post", thread.ID))
+		return nil, nil, false
+	}
+	return thread, opening, true
+}
+
+// threadSchedule displays the form editing a scheduled thread.
+func (app *application) threadSchedule(w http.ResponseWriter, r *http.Request) {
+	thread, opening, ok := app.scheduledFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Thread = thread
+	data.Form = scheduleForm{
+		Title:     thread.Title,
+		Message:   opening.Body,
+		PublishAt: thread.ScheduledAt.Local().Format("2006-01-02T15:04"),
+	}
+	app.render(w, r, http.StatusOK, "thread-schedule.tmpl", data)
+}
+
+// threadSchedulePost saves the changes to a scheduled thread. When the
+// publish_now button was used, the thread is published right away instead
+// of at the submitted time.
+func (app *application) threadSchedulePost(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	thread, _, ok := app.scheduledFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	form := scheduleForm{
+		Title:     r.PostForm.Get("title"),
+		Message:   r.PostForm.Get("message"),
+		PublishAt: r.PostForm.Get("publish_at"),
+	}
+	publishNow := r.PostForm.Has("publish_now")
+	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank.")
+	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters.")
+	checkMessage(&form.Validator, form.Message)
+
+	at := time.Now()
+	if !publishNow {
+		at = parseFutureTime(&form.Validator, "publish_at", form.PublishAt, "publish time")
+	}
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	poll, err := app.polls.ForThread(thread.ID, userSessionID)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+	form.CheckField(
+		poll == nil || poll.ClosesAt.IsZero() || poll.ClosesAt.After(at),
+		"publish_at", "The thread must be published before its poll closes.",
+	)
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Thread = thread
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "thread-schedule.tmpl", data)
+		return
+	}
+
+	err = app.threads.Reschedule(thread.ID, userSessionID, form.Title, form.Message, at)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	flash := "Thread rescheduled for " + humanDate(at) + "."
+	if publishNow {
+		// The thread is due from now on, so the worker publishes it anyway
+		// if this fails.
+		app.logError(r, app.publishDue())
+		flash = "Thread published."
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, fmt.Sprintf("/thread/view/%d", thread.ID), http.StatusSeeOther)
+}
+
+// threadScheduleCancelPost deletes a scheduled thread. Its title and opening
+// post become the current user's draft of a new thread, replacing any other,
+// so that nothing they wrote is lost.
+func (app *application) threadScheduleCancelPost(w http.ResponseWriter, r *http.Request) {
+	thread, _, ok := app.scheduledFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	userSessionID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	title, body, err := app.threads.DeleteScheduled(thread.ID, userSessionID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.drafts.Save(userSessionID, 0, title, body)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Scheduled thread cancelled: it was saved as a draft.")
+	http.Redirect(w, r, "/thread/create", http.StatusSeeOther)
+}
+
+// feedSize is the number of entries in feeds.
+const feedSize = 20
+
+// serveFeed writes a feed of the given messages, in the format given by the
+// format path value: atom or rss. link is the path of the page the feed
+// follows. Conditional requests are answered by http.ServeContent, with the
+// time of the newest message as Last-Modified and a hash of the document as
+// ETag, so that edits are picked up too.
+func (app *application) serveFeed(
+	w http.ResponseWriter,
+	r *http.Request,
+	title, link string,
+	messages []*models.Message,
+) {
+	f := &feed.Feed{
+		Title: title + " — Forum",
+		Link:  app.baseURL + link,
+		Self:  app.baseURL + r.URL.Path,
+	}
+	for _, m := range messages {
+		e := &feed.Entry{
+			Title:     m.ThreadTitle,
+			Link:      fmt.Sprintf("%s/thread/view/%d", app.baseURL, m.ThreadID),
+			Author:    m.Author.Username,
+			Content:   string(markdown.Render(m.Body, markdown.Options{})),
+			Published: m.DateAdded,
+		}
+		if !m.IsOpening {
+			e.Title = "Re: " + m.ThreadTitle
+			e.Link += fmt.Sprintf("#message-%d", m.ID)
+		}
+		f.Entries = append(f.Entries, e)
+	}
+
+	var (
+		body []byte
+		err  error
+	)
+	switch r.PathValue("format") {
+	case "atom":
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		body, err = f.Atom()
+	case "rss":
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		body, err = f.RSS()
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	http.ServeContent(w, r, "", f.Updated(), bytes.NewReader(body))
+}
+
+// latestFeed serves the feed of the latest threads.
+func (app *application) latestFeed(w http.ResponseWriter, r *http.Request) {
+	messages, err := app.feeds.Threads(feedSize)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.serveFeed(w, r, "Latest threads", "/", messages)
+}
+
+// threadFeed serves the feed of the messages of a public thread.
+func (app *application) threadFeed(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	thread, err := app.threads.Get(id, 0)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	messages, err := app.feeds.Thread(thread.ID, feedSize)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.serveFeed(w, r, thread.Title, fmt.Sprintf("/thread/view/%d", thread.ID), messages)
+}
+
+// categoryFeed serves the feed of the latest threads of a category.
+func (app *application) categoryFeed(w http.ResponseWriter, r *http.Request) {
+	category, err := app.categories.GetBySlug(r.PathValue("slug"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	messages, err := app.feeds.Category(category.ID, feedSize)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.serveFeed(w, r, category.Name, "/category/"+category.Slug, messages)
+}
+
+// tagFeed serves the feed of the latest threads with a tag.
+func (app *application) tagFeed(w http.ResponseWriter, r *http.Request) {
+	tag, err := app.tags.GetBySlug(r.PathValue("slug"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	messages, err := app.feeds.Tag(tag.ID, feedSize)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.serveFeed(w, r, "Threads tagged "+tag.Name, "/tag/"+tag.Slug, messages)
+}
+
+// userFeed serves the feed of the latest messages of a user who shows their
+// activity on their profile.
+func (app *application) userFeed(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.GetBySlug(r.PathValue("slug"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	if !user.ShowActivity {
+		http.NotFound(w, r)
+		return
+	}
+
+	messages, err := app.feeds.User(user.ID, feedSize)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.serveFeed(w, r, "Posts by "+user.Username, "/user/"+user.Slug, messages)
+}
+
+// webhookForm holds the data for the webhook creation and edition forms.
+type webhookForm struct {
+	URL    string
+	Events []string
+	Active bool
+	validator.Validator
+}
+
+// parseWebhookForm reads and validates the submitted webhook form.
+func parseWebhookForm(r *http.Request) webhookForm {
+	form := webhookForm{
+		URL:    strings.TrimSpace(r.PostForm.Get("url")),
+		Events: r.PostForm["events"],
+		Active: r.PostForm.Get("active") != "",
+	}
+
+	form.CheckField(validator.NotBlank(form.URL), "url", "This field cannot be blank.")
+	form.CheckField(validator.MaxChars(form.URL, 500), "url", "This field cannot be more than 500 characters.")
+	u, err := url.Parse(form.URL)
+	form.CheckField(
+		err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+		"url", "Please enter a valid http or https URL.",
+	)
+
+	form.CheckField(len(form.Events) > 0, "events", "Please choose at least one event.")
+	for _, e := range form.Events {
+		form.CheckField(validator.PermittedValue(e, models.WebhookEvents...), "events", "Please choose known events.")
+	}
+	return form
+}
+
+// adminWebhooks lists the webhooks along with the creation form.
+func (app *application) adminWebhooks(w http.ResponseWriter, r *http.Request) {
+	app.renderAdminWebhooks(w, r, http.StatusOK, webhookForm{Events: models.WebhookEvents})
+}
+
+// renderAdminWebhooks renders the webhook administration page with the given
+// creation form.
+func (app *application) renderAdminWebhooks(w http.ResponseWriter, r *http.Request, status int, form webhookForm) {
+	webhooks, err := app.webhooks.All()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Webhooks = webhooks
+	data.WebhookEvents = models.WebhookEvents
+	data.Form = form
+	app.render(w, r, status, "admin-webhooks.tmpl", data)
+}
+
+// adminWebhookCreatePost creates an active webhook with a random secret.
+func (app *application) adminWebhookCreatePost(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := parseWebhookForm(r)
+	if !form.Valid() {
+		app.renderAdminWebhooks(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	id, err := app.webhooks.Insert(form.URL, hex.EncodeToString(secret), form.Events)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Webhook created successfully!")
+	http.Redirect(w, r, fmt.Sprintf("/admin/webhooks/%d", id), http.StatusSeeOther)
+}
+
+// webhookFromPath loads the webhook whose id is in the request path. It
+// writes the error response and returns false if there is none.
+func (app *application) webhookFromPath(w http.ResponseWriter, r *http.Request) (*models.Webhook, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return nil, false
+	}
+
+	webhook, err := app.webhooks.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return nil, false
+	}
+	return webhook, true
+}
+
+// webhookDeliveriesShown is the number of deliveries in the delivery log of
+// a webhook.
+const webhookDeliveriesShown = 50
+
+// adminWebhook displays a webhook with its secret, its edition form and its
+// latest deliveries.
+func (app *application) adminWebhook(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.webhookFromPath(w, r)
+	if !ok {
+		return
+	}
+	form := webhookForm{URL: webhook.URL, Events: webhook.Events, Active: webhook.Active}
+	app.renderAdminWebhook(w, r, http.StatusOK, webhook, form)
+}
+
+// renderAdminWebhook renders the page of a webhook with the given edition
+// form.
+func (app *application) renderAdminWebhook(
+	w http.ResponseWriter,
+	r *http.Request,
+	status int,
+	webhook *models.Webhook,
+	form webhookForm,
+) {
+	deliveries, err := app.webhooks.Deliveries(webhook.ID, webhookDeliveriesShown)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Webhook = webhook
+	data.Deliveries = deliveries
+	data.WebhookEvents = models.WebhookEvents
+	data.Form = form
+	app.render(w, r, status, "admin-webhook.tmpl", data)
+}
+
+// adminWebhookEditPost saves the changes to a webhook.
+func (app *application) adminWebhookEditPost(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.webhookFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := parseWebhookForm(r)
+	if !form.Valid() {
+		app.renderAdminWebhook(w, r, http.StatusUnprocessableEntity, webhook, form)
+		return
+	}
+
+	err = app.webhooks.Update(webhook.ID, form.URL, form.Events, form.Active)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if form.Active {
+		app.wakeWebhooks()
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Webhook saved successfully!")
+	http.Redirect(w, r, fmt.Sprintf("/admin/webhooks/%d", webhook.ID), http.StatusSeeOther)
+}
+
+// adminWebhookTestPost queues a ping event for a webhook, whatever the events
+// it subscribed to, so that admins can check their endpoint.
+func (app *application) adminWebhookTestPost(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.webhookFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	flash := "Test event queued: its delivery shows up in the log below."
+	if webhook.Active {
+		payload, err := webhookPayload(models.EventPing, map[string]any{"webhook_id": webhook.ID})
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		err = app.webhooks.EnqueueFor(webhook.ID, models.EventPing, payload)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		app.wakeWebhooks()
+	} else {
+		flash = "Inactive webhooks are sent no events."
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, fmt.Sprintf("/admin/webhooks/%d", webhook.ID), http.StatusSeeOther)
+}
+
+// adminWebhookRedeliverPost queues a delivery of a webhook to be sent again.
+func (app *application) adminWebhookRedeliverPost(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.webhookFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	deliveryID, err := strconv.Atoi(r.PathValue("delivery"))
+	if err != nil || deliveryID < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	err = app.webhooks.Redeliver(webhook.ID, deliveryID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.wakeWebhooks()
+
+	app.sessionManager.Put(r.Context(), "flash", "Delivery queued again.")
+	http.Redirect(w, r, fmt.Sprintf("/admin/webhooks/%d", webhook.ID), http.StatusSeeOther)
+}
+
+// adminWebhookDeletePost deletes a webhook along with its delivery log.
+func (app *application) adminWebhookDeletePost(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.webhookFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.webhooks.Delete(webhook.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Webhook deleted successfully!")
+	http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
 }
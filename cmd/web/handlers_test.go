@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"forum/cmd/internal/avatar"
+	"forum/cmd/internal/models"
+)
+
+// visibilityFixture holds the users and threads requested by the handler
+// visibility tests.
+type visibilityFixture struct {
+	author      int
+	member      int // logged in, but not in the group
+	groupMember int
+
+	threads []*fixtureThread
+}
+
+// fixtureThread is a thread whose reply has an attachment and a reaction.
+type fixtureThread struct {
+	name         string
+	id           int
+	replyID      int
+	attachmentID int
+	scheduled    bool
+
+	// readers are the users who can read the thread, zero standing for
+	// anonymous users.
+	readers []int
+}
+
+func newVisibilityFixture(t *testing.T, app *application) *visibilityFixture {
+	t.Helper()
+
+	f := &visibilityFixture{
+		author:      insertTestUser(t, app, "author"),
+		member:      insertTestUser(t, app, "member"),
+		groupMember: insertTestUser(t, app, "insider"),
+	}
+	reactor := insertTestUser(t, app, "reactor")
+
+	groupID, err := app.groups.Insert("Staff", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.groups.AddMember(groupID, f.groupMember)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	everyone := []int{0, f.member, f.groupMember, f.author}
+	f.threads = []*fixtureThread{
+		{name: "public", readers: everyone},
+		{name: "members", readers: everyone[1:]},
+		{name: "group", readers: []int{f.groupMember, f.author}},
+		{name: "scheduled", readers: []int{f.author}, scheduled: true},
+	}
+	for _, ft := range f.threads {
+		visibility := ft.name
+		var scheduledAt time.Time
+		if ft.scheduled {
+			visibility = models.VisibilityPublic
+			scheduledAt = time.Now().Add(time.Hour)
+		}
+		ft.id, _, err = app.threads.Insert(
+			"Thread", "Opening post", f.author, 0, models.KindDiscussion,
+			visibility, groupID, nil, nil, scheduledAt,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		key := "attachments/" + ft.name
+		err = app.storage.Put(key, strings.NewReader("attached to "+ft.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		attachment := &models.Attachment{
+			UserID: f.author, StorageKey: key, Filename: "notes.txt", ContentType: "text/plain", Size: 20,
+		}
+		ft.replyID, err = app.messages.InsertMessage(
+			"Reply", ft.id, f.author, 0, []*models.Attachment{attachment}, 1<<20,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ft.attachmentID = attachment.ID
+
+		_, _, err = app.reactions.Toggle(ft.replyID, reactor, app.reactionSet[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f
+}
+
+// viewers returns the users of the fixture by name.
+func (f *visibilityFixture) viewers() map[string]int {
+	return map[string]int{
+		"anonymous":    0,
+		"member":       f.member,
+		"group member": f.groupMember,
+		"author":       f.author,
+	}
+}
+
+func TestAttachmentViewVisibility(t *testing.T) {
+	app := newTestApplication(t)
+	f := newVisibilityFixture(t, app)
+
+	for name, userID := range f.viewers() {
+		for _, ft := range f.threads {
+			t.Run(name+"/"+ft.name, func(t *testing.T) {
+				resp := get(t, context.Background(), app, userID, "/attachment/"+strconv.Itoa(ft.attachmentID))
+				body, _ := io.ReadAll(resp.Body)
+
+				if slices.Contains(ft.readers, userID) {
+					if resp.StatusCode != http.StatusOK || string(body) != "attached to "+ft.name {
+						t.Errorf("got status %d and body %q", resp.StatusCode, body)
+					}
+					return
+				}
+				if resp.StatusCode != http.StatusNotFound || strings.Contains(string(body), "attached") {
+					t.Errorf("got status %d and body %q; want a 404", resp.StatusCode, body)
+				}
+			})
+		}
+	}
+}
+
+func TestMessageReactionsVisibility(t *testing.T) {
+	app := newTestApplication(t)
+	f := newVisibilityFixture(t, app)
+
+	for name, userID := range f.viewers() {
+		for _, ft := range f.threads {
+			t.Run(name+"/"+ft.name, func(t *testing.T) {
+				resp := get(t, context.Background(), app, userID, "/message/"+strconv.Itoa(ft.replyID)+"/reactions")
+				body, _ := io.ReadAll(resp.Body)
+
+				if slices.Contains(ft.readers, userID) {
+					if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "reactor") {
+						t.Errorf("got status %d without the reacting user", resp.StatusCode)
+					}
+					return
+				}
+				if resp.StatusCode != http.StatusNotFound || strings.Contains(string(body), "reactor") {
+					t.Errorf("got status %d; want a 404 without the reacting user", resp.StatusCode)
+				}
+			})
+		}
+	}
+}
+
+func TestThreadEventsVisibility(t *testing.T) {
+	app := newTestApplication(t)
+	f := newVisibilityFixture(t, app)
+
+	// With a canceled context the stream ends once it caught up.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for name, userID := range f.viewers() {
+		for _, ft := range f.threads {
+			t.Run(name+"/"+ft.name, func(t *testing.T) {
+				resp := get(t, ctx, app, userID, "/thread/view/"+strconv.Itoa(ft.id)+"/events?after=0")
+				body, _ := io.ReadAll(resp.Body)
+
+				// Scheduled threads cannot be replied to, so they have no
+				// stream, even for their author.
+				if slices.Contains(ft.readers, userID) && !ft.scheduled {
+					if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "id: "+strconv.Itoa(ft.replyID)) {
+						t.Errorf("got status %d and body %q; want the reply", resp.StatusCode, body)
+					}
+					return
+				}
+				if resp.StatusCode != http.StatusNotFound || strings.Contains(string(body), "event:") {
+					t.Errorf("got status %d and body %q; want a 404", resp.StatusCode, body)
+				}
+			})
+		}
+	}
+}
+
+func TestSendNewMessagesHiddenThread(t *testing.T) {
+	app := newTestApplication(t)
+	f := newVisibilityFixture(t, app)
+	public := f.threads[0]
+
+	thread, err := app.threads.Get(public.id, f.member)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.threads.SetVisibility(public.id, models.VisibilityGroup, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A stream opened before the thread was restricted sends nothing more.
+	var b strings.Builder
+	_, err = app.sendNewMessages(&b, thread, f.member, false, 0)
+	if !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("got error %v; want ErrNoRecord", err)
+	}
+	if b.Len() != 0 {
+		t.Errorf("got events %q", b.String())
+	}
+}
+
+func TestAvatarViewETag(t *testing.T) {
+	app := newTestApplication(t)
+	userID := insertTestUser(t, app, "alice")
+	path := "/avatar/" + strconv.Itoa(userID) + "/32"
+
+	resp := get(t, context.Background(), app, 0, path)
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" {
+		t.Fatalf("got status %d and ETag %q", resp.StatusCode, etag)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.Header.Set("If-None-Match", etag)
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, r)
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("got status %d for a matching ETag; want %d", rr.Code, http.StatusNotModified)
+	}
+
+	// An upload changes the image, and so the ETag.
+	img, err := avatar.Identicon("someone else", 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.storage.Put(avatarKey(userID, 32), bytes.NewReader(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.users.SetAvatar(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = get(t, context.Background(), app, 0, path)
+	if got := resp.Header.Get("ETag"); got == etag {
+		t.Errorf("got the same ETag %q after an upload", got)
+	}
+}
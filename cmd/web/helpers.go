@@ -2,17 +2,34 @@ package main
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/cmd/internal/mailer"
+	"forum/cmd/internal/markdown"
+	"forum/cmd/internal/models"
+	"forum/cmd/internal/validator"
 )
 
 // serverError writes a log entry at Error level (including the request
 // method and URI as attributes), then sends a generic 500 Internal Server Error
 // response to the user.
 func (app *application) serverError(
-    w http.ResponseWriter, 
-    r *http.Request, 
-    err error,
+	w http.ResponseWriter,
+	r *http.Request,
+	err error,
 ) {
 	var (
 		method = r.Method
@@ -21,10 +38,20 @@ func (app *application) serverError(
 
 	app.logger.Error(err.Error(), "method", method, "uri", uri)
 	http.Error(
-        w, 
-        http.StatusText(http.StatusInternalServerError), 
-        http.StatusInternalServerError,
-    )
+		w,
+		http.StatusText(http.StatusInternalServerError),
+		http.StatusInternalServerError,
+	)
+}
+
+// logError logs err, if not nil, with the request method and URI as
+// attributes. It is meant for the side effects of requests whose main change
+// is already committed, such as notifying users of a new message: failing
+// the request would lead users to submit the change again.
+func (app *application) logError(r *http.Request, err error) {
+	if err != nil {
+		app.logger.Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+	}
 }
 
 // clientError sends a specific status code and corresponding description
@@ -34,33 +61,743 @@ func (app *application) clientError(w http.ResponseWriter, status int) {
 }
 
 func (app *application) render(
-    w http.ResponseWriter, 
-    r *http.Request, 
-    status int, 
-    page string, 
-    data templateData,
+	w http.ResponseWriter,
+	r *http.Request,
+	status int,
+	page string,
+	data templateData,
 ) {
-    ts, ok := app.templateCache[page]
-    if !ok {
-        err := fmt.Errorf("the template %s does not exist", page)
-        app.serverError(w, r, err)
-        return
-    }
+	ts, ok := app.templateCache[page]
+	if !ok {
+		err := fmt.Errorf("the template %s does not exist", page)
+		app.serverError(w, r, err)
+		return
+	}
 
-    buf := new(bytes.Buffer)
+	buf := new(bytes.Buffer)
 
-    err := ts.ExecuteTemplate(buf, "base", data)
-    if err != nil {
-        app.serverError(w, r, err)
-        return
-    }
+	err := ts.ExecuteTemplate(buf, "base", data)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
 
-    w.WriteHeader(status)
+	w.WriteHeader(status)
 
-    buf.WriteTo(w)
+	buf.WriteTo(w)
+}
+
+// writeEvent writes a Server-Sent Event. Each line of data is sent as its
+// own data field, which browsers join back with newlines.
+func writeEvent(w io.Writer, id int, event, data string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "id: %d\nevent: %s\n", id, event)
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: ")
+		b.WriteString(strings.TrimSuffix(line, "\r"))
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+	_, err := io.WriteString(w, b.String())
+	return err
 }
 
 // isAuthenticated checks if the user is authenticated.
 func (app *application) isAuthenticated(r *http.Request) bool {
-    return app.sessionManager.Exists(r.Context(), "authenticatedUserID")
-}
\ No newline at end of file
+	return app.sessionManager.Exists(r.Context(), "authenticatedUserID")
+}
+
+// renderMarkdown fills in the sanitized HTML body of each message, reusing
+// the cached rendering of unchanged message revisions. The mentions resolved
+// when each message was posted, keyed by message id, are rendered as links
+// to the mentioned users' profiles. They are part of the cache key, since
+// they can change without a new revision, as when a scheduled thread is
+// published.
+func (app *application) renderMarkdown(
+	messages []*models.Message,
+	mentions map[int]map[string]string,
+) {
+	for _, m := range messages {
+		resolved := mentions[m.ID]
+		opts := markdown.Options{
+			Mention: func(name string) string {
+				if slug, ok := resolved[strings.ToLower(name)]; ok {
+					return "/user/" + slug
+				}
+				return ""
+			},
+		}
+		key := fmt.Sprintf("message:%d:%d:%x", m.ID, m.Revision, mentionsHash(resolved))
+		m.BodyHTML = app.markdown.Render(key, m.Body, opts)
+	}
+}
+
+// mentionsHash returns a hash of resolved mentions that does not depend on
+// the map iteration order.
+func mentionsHash(resolved map[string]string) uint64 {
+	names := make([]string, 0, len(resolved))
+	for name := range resolved {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	h := fnv.New64a()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s=%s\n", name, resolved[name])
+	}
+	return h.Sum64()
+}
+
+// threadVisibleTo reports whether the user with the given id can read a
+// thread. It is meant for code acting on behalf of other users than the one
+// making the request, such as notifications; errors are logged and treated
+// as the thread being hidden.
+func (app *application) threadVisibleTo(thread *models.Thread, userID int) bool {
+	ok, err := app.threads.VisibleTo(thread.ID, userID)
+	if err != nil {
+		app.logger.Error(err.Error())
+		return false
+	}
+	return ok
+}
+
+// notify notifies a user of an event caused by actorID in a thread. Users
+// are never notified of their own actions.
+func (app *application) notify(kind string, userID int, thread *models.Thread, actorID int) error {
+	if userID == actorID {
+		return nil
+	}
+	return app.notifications.Add(userID, kind, thread.ID, actorID)
+}
+
+// backgroundRestartDelay is how long background waits before running a
+// function that panicked again.
+const backgroundRestartDelay = time.Minute
+
+// background runs fn in a new goroutine, logging any panic instead of
+// crashing the server. A function that panics is run again after
+// backgroundRestartDelay, so that a worker meant to run as long as the
+// server is not stopped for good by one failed run.
+func (app *application) background(fn func()) {
+	go func() {
+		for app.recoverPanic(fn) {
+			time.Sleep(backgroundRestartDelay)
+		}
+	}()
+}
+
+// recoverPanic runs fn and reports whether it panicked, logging the panic.
+func (app *application) recoverPanic(fn func()) (panicked bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.Error(fmt.Sprint(err), "restart_in", backgroundRestartDelay)
+			panicked = true
+		}
+	}()
+
+	fn()
+	return false
+}
+
+// queueEmail stores an email in the outbox and wakes up the mail dispatcher.
+// The email is sent in the background and retried until it goes through.
+func (app *application) queueEmail(msg mailer.Message) error {
+	err := app.outbox.Enqueue(msg.To, msg.Subject, msg.Body, msg.Headers)
+	if err != nil {
+		return err
+	}
+
+	app.wakeMailer()
+	return nil
+}
+
+// wakeMailer wakes up the mail dispatcher, unless it is already due to wake
+// up.
+func (app *application) wakeMailer() {
+	select {
+	case app.mailWake <- struct{}{}:
+	default:
+	}
+}
+
+// unsubscribeToken signs the unsubscription of a user from a thread, so that
+// the links in emails work without logging in and cannot be forged.
+func (app *application) unsubscribeToken(userID, threadID int) string {
+	mac := hmac.New(sha256.New, app.secret)
+	fmt.Fprintf(mac, "unsubscribe:%d:%d", userID, threadID)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validUnsubscribeToken reports whether token was issued by unsubscribeToken
+// for the given user and thread.
+func (app *application) validUnsubscribeToken(userID, threadID int, token string) bool {
+	return hmac.Equal([]byte(token), []byte(app.unsubscribeToken(userID, threadID)))
+}
+
+// unsubscribeURL returns the one-click unsubscription link of a user from a
+// thread.
+func (app *application) unsubscribeURL(userID, threadID int) string {
+	return fmt.Sprintf(
+		"%s/unsubscribe/%d/%d/%s",
+		app.baseURL, userID, threadID, app.unsubscribeToken(userID, threadID),
+	)
+}
+
+// pagination describes the current page of a paginated listing.
+type pagination struct {
+	Page  int // starting at 1
+	Pages int
+	Size  int
+	query url.Values
+}
+
+// newPagination reads the page number from the page query parameter of a
+// listing of count items. ok is false if the page does not exist.
+func newPagination(r *http.Request, count, size int) (*pagination, bool) {
+	p := &pagination{
+		Page:  1,
+		Pages: max(1, (count+size-1)/size),
+		Size:  size,
+		query: r.URL.Query(),
+	}
+	if s := r.URL.Query().Get("page"); s != "" {
+		page, err := strconv.Atoi(s)
+		if err != nil || page < 1 || page > p.Pages {
+			return nil, false
+		}
+		p.Page = page
+	}
+	return p, true
+}
+
+// Offset returns the number of items before the current page.
+func (p *pagination) Offset() int {
+	return (p.Page - 1) * p.Size
+}
+
+// Prev returns the previous page number, or zero on the first page.
+func (p *pagination) Prev() int {
+	return p.Page - 1
+}
+
+// Next returns the next page number, or zero on the last page.
+func (p *pagination) Next() int {
+	if p.Page >= p.Pages {
+		return 0
+	}
+	return p.Page + 1
+}
+
+// URL returns the query string of the given page, keeping the other query
+// parameters of the listing such as filters.
+func (p *pagination) URL(page int) string {
+	q := url.Values{}
+	for k, v := range p.query {
+		q[k] = v
+	}
+	q.Set("page", strconv.Itoa(page))
+	return "?" + q.Encode()
+}
+
+// maxTags is the largest number of tags a thread can have.
+const maxTags = 5
+
+// parseTags splits a comma separated list of tag names. Names are lower
+// cased with their whitespace collapsed, and duplicates are dropped.
+func parseTags(s string) []string {
+	var (
+		names []string
+		seen  = make(map[string]bool)
+	)
+	for _, name := range strings.Split(s, ",") {
+		name = strings.Join(strings.Fields(strings.ToLower(name)), " ")
+		slug := models.Slugify(name)
+		if name == "" || seen[slug] {
+			continue
+		}
+		seen[slug] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// checkTags validates tag names parsed by parseTags, recording errors under
+// the tags field.
+func checkTags(v *validator.Validator, names []string) {
+	v.CheckField(len(names) <= maxTags, "tags", fmt.Sprintf("A thread cannot have more than %d tags.", maxTags))
+	for _, name := range names {
+		v.CheckField(validator.MaxChars(name, 30), "tags", fmt.Sprintf("%q is longer than 30 characters.", name))
+		v.CheckField(models.Slugify(name) != "", "tags", fmt.Sprintf("%q must contain a letter or a digit.", name))
+	}
+}
+
+// tagFilter holds the tags the thread index is filtered by.
+type tagFilter struct {
+	Input    string
+	Slugs    []string
+	MatchAll bool
+}
+
+// newTagFilter reads the tag filter from the tags and match query
+// parameters: tags is a comma separated list of tags, and match is "all" to
+// only list threads having every tag rather than any of them.
+func newTagFilter(r *http.Request) tagFilter {
+	f := tagFilter{
+		Input:    r.URL.Query().Get("tags"),
+		MatchAll: r.URL.Query().Get("match") == "all",
+	}
+	for _, name := range parseTags(f.Input) {
+		f.Slugs = append(f.Slugs, models.Slugify(name))
+	}
+	return f
+}
+
+// threadSort is the order of the thread listing on the home page.
+type threadSort struct {
+	Sort       models.Sort
+	Window     string // time window of the top and active sorts
+	Unanswered bool   // only list questions without an accepted answer
+}
+
+// newThreadSort reads the thread sort from the sort, t and unanswered query
+// parameters. Unknown values fall back to the latest threads and to a week
+// long window.
+func newThreadSort(r *http.Request) threadSort {
+	s := threadSort{
+		Sort:       models.Sort(r.URL.Query().Get("sort")),
+		Window:     r.URL.Query().Get("t"),
+		Unanswered: r.URL.Query().Get("unanswered") != "",
+	}
+	switch s.Sort {
+	case models.SortTop, models.SortHot, models.SortActive:
+	default:
+		s.Sort = models.SortLatest
+	}
+	if _, ok := models.Windows[s.Window]; !ok {
+		s.Window = "week"
+	}
+	return s
+}
+
+// Sorts returns the sorts the home page offers, in display order.
+func (s threadSort) Sorts() []models.Sort {
+	return []models.Sort{models.SortLatest, models.SortTop, models.SortHot, models.SortActive}
+}
+
+// Windows returns the time windows the home page offers, in display order.
+func (s threadSort) Windows() []string {
+	return []string{"day", "week", "month", "year", "all"}
+}
+
+// HasWindow reports whether the sort looks at a time window.
+func (s threadSort) HasWindow() bool {
+	return s.Sort == models.SortTop || s.Sort == models.SortActive
+}
+
+// checkMessage validates the body of a message, recording errors under the
+// message field. Thread messages and private messages follow the same rules.
+func checkMessage(v *validator.Validator, body string) {
+	v.CheckField(validator.NotBlank(body), "message", "This field cannot be blank.")
+	v.CheckField(validator.MaxChars(body, 1000), "message", "This field cannot be more than 1000 characters).")
+}
+
+// maxQuoteChars is the length past which a quoted message body is cut.
+const maxQuoteChars = 300
+
+// findMessage returns the message of a thread with the given id, or nil if
+// the thread has no such message.
+func findMessage(thread *models.Thread, id int) *models.Message {
+	for _, m := range thread.Messages {
+		if m.ID == id {
+			return m
+		}
+	}
+	return nil
+}
+
+// quoteMessage returns the markdown used to pre-fill a reply quoting m: an
+// attribution linking back to the message followed by an excerpt of its body.
+func quoteMessage(m *models.Message) string {
+	body := strings.TrimSpace(m.Body)
+	if runes := []rune(body); len(runes) > maxQuoteChars {
+		body = strings.TrimSpace(string(runes[:maxQuoteChars])) + "…"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s wrote:](/thread/view/%d#message-%d)\n", m.Author.Username, m.ThreadID, m.ID)
+	for _, line := range strings.Split(body, "\n") {
+		b.WriteString(strings.TrimRight("> "+line, " ") + "\n")
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// messageTree nests the replies of a thread under the messages they answer
+// and returns the top level ones. Replies to the opening post, or to nothing
+// in particular, are top level.
+func messageTree(messages []*models.Message) []*models.Message {
+	var roots []*models.Message
+	for _, m := range messages {
+		m.Replies = nil
+	}
+	for _, m := range messages {
+		switch {
+		case m.IsOpening:
+		case m.ReplyTo == nil || m.ReplyTo.IsOpening:
+			roots = append(roots, m)
+		default:
+			m.ReplyTo.Replies = append(m.ReplyTo.Replies, m)
+		}
+	}
+	return roots
+}
+
+// parseReactionSet splits the comma separated list of emojis users can react
+// with, dropping blanks and duplicates.
+func parseReactionSet(s string) []string {
+	var set []string
+	for _, emoji := range strings.Split(s, ",") {
+		emoji = strings.TrimSpace(emoji)
+		if emoji != "" && !slices.Contains(set, emoji) {
+			set = append(set, emoji)
+		}
+	}
+	return set
+}
+
+// setReactions fills in the reactions of messages from the counts returned by
+// ReactionModel.ForThread, with one entry for each emoji of the forum's set.
+// Reactions with emojis since removed from the set are not shown.
+func (app *application) setReactions(messages []*models.Message, counts map[int]map[string]*models.Reaction) {
+	for _, m := range messages {
+		m.Reactions = make([]*models.Reaction, 0, len(app.reactionSet))
+		for _, emoji := range app.reactionSet {
+			r, ok := counts[m.ID][emoji]
+			if !ok {
+				r = &models.Reaction{Emoji: emoji}
+			}
+			m.Reactions = append(m.Reactions, r)
+		}
+	}
+}
+
+// checkVisibility validates the visibility of a thread, recording errors
+// under the visibility and group_id fields. Threads can only be restricted to
+// one of the given groups, the ones their author is a member of.
+func checkVisibility(v *validator.Validator, visibility string, groupID int, groups []*models.Group) {
+	v.CheckField(
+		validator.PermittedValue(visibility, models.VisibilityPublic, models.VisibilityMembers, models.VisibilityGroup),
+		"visibility", "Please choose who can read the thread.",
+	)
+	if visibility != models.VisibilityGroup {
+		return
+	}
+	member := false
+	for _, g := range groups {
+		member = member || g.ID == groupID
+	}
+	v.CheckField(member, "group_id", "Please choose one of your groups.")
+}
+
+// newPoll validates the poll fields of the thread creation form and returns
+// the poll to attach to the thread, or nil if they were left blank.
+func newPoll(form *createThreadForm) *models.Poll {
+	var options []*models.PollOption
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(form.PollOptions, "\n") {
+		label := strings.TrimSpace(line)
+		if label == "" {
+			continue
+		}
+		form.CheckField(validator.MaxChars(label, 100), "poll_options", "Options cannot be more than 100 characters.")
+		form.CheckField(!seen[strings.ToLower(label)], "poll_options", "Options must all be different.")
+		seen[strings.ToLower(label)] = true
+		options = append(options, &models.PollOption{Label: label})
+	}
+	if form.PollQuestion == "" && len(options) == 0 {
+		return nil
+	}
+
+	form.CheckField(validator.NotBlank(form.PollQuestion), "poll_question", "This field cannot be blank.")
+	form.CheckField(validator.MaxChars(form.PollQuestion, 200), "poll_question", "This field cannot be more than 200 characters.")
+	form.CheckField(len(options) >= 2 && len(options) <= 10, "poll_options", "A poll needs between 2 and 10 options.")
+
+	poll := &models.Poll{
+		Question:  form.PollQuestion,
+		Multiple:  form.PollMultiple,
+		Anonymous: form.PollAnonymous,
+		Options:   options,
+	}
+	if form.PollCloses != "" {
+		poll.ClosesAt = parseFutureTime(&form.Validator, "poll_closes", form.PollCloses, "closing time")
+	}
+	return poll
+}
+
+// parseFutureTime parses the value of a datetime-local input, in local time,
+// and checks that it is in the future. Errors are added to v for the given
+// field, naming the time with what.
+func parseFutureTime(v *validator.Validator, field, value, what string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02T15:04", value, time.Local)
+	if err != nil {
+		v.AddFieldError(field, "Please enter a valid date and time.")
+	} else {
+		v.CheckField(t.After(time.Now()), field, fmt.Sprintf("The %s must be in the future.", what))
+	}
+	return t
+}
+
+// draftThread returns the thread the compose form submitted by r posts to,
+// zero for a new thread. ok is false if r is not a compose form submission.
+func draftThread(r *http.Request) (threadID int, ok bool) {
+	if r.URL.Path == "/thread/create" || r.URL.Path == "/thread/create/draft" {
+		return 0, true
+	}
+	for _, suffix := range []string{"/message/create", "/message/preview", "/message/draft"} {
+		if strings.HasPrefix(r.URL.Path, "/thread/view/") && strings.HasSuffix(r.URL.Path, suffix) {
+			id, err := strconv.Atoi(r.PathValue("id"))
+			return id, err == nil && id > 0
+		}
+	}
+	return 0, false
+}
+
+// maxStashSize is the number of bytes of a request body stashDraft reads.
+// The title and message come before the file inputs in the compose forms,
+// so they are read even when attachments make the body larger.
+const maxStashSize = 64 << 10
+
+// stashDraft keeps in the session the text of a thread or message submitted
+// after the session of its author expired, so that it is saved as a draft
+// once they log in again. Other submissions, and text that would not pass
+// the length checks of the compose forms, are ignored.
+func (app *application) stashDraft(w http.ResponseWriter, r *http.Request) {
+	threadID, ok := draftThread(r)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxStashSize)
+	fields, err := draftFields(r)
+	if err != nil {
+		return
+	}
+	title := strings.TrimSpace(fields["title"])
+	body := fields["message"]
+	if title == "" && strings.TrimSpace(body) == "" {
+		return
+	}
+	if !validator.MaxChars(title, 100) || !validator.MaxChars(body, 1000) {
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "draftThreadID", threadID)
+	app.sessionManager.Put(r.Context(), "draftTitle", title)
+	app.sessionManager.Put(r.Context(), "draftBody", body)
+}
+
+// draftFields reads the title and message fields of a compose form. The
+// parts of a multipart form are read one at a time and files are skipped,
+// so that nothing but the text is kept in memory.
+func draftFields(r *http.Request) (map[string]string, error) {
+	mr, err := r.MultipartReader()
+	if errors.Is(err, http.ErrNotMultipart) {
+		err = r.ParseForm()
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{
+			"title":   r.PostForm.Get("title"),
+			"message": r.PostForm.Get("message"),
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]string{}
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			// Past the text fields, a body cut short by maxStashSize only
+			// loses files.
+			if errors.Is(err, io.EOF) || len(fields) > 0 {
+				return fields, nil
+			}
+			return nil, err
+		}
+		name := part.FormName()
+		if part.FileName() != "" || (name != "title" && name != "message") {
+			continue
+		}
+		value, err := io.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+		fields[name] = string(value)
+	}
+}
+
+// saveStashedDraft saves the draft kept by stashDraft, if any, for the user
+// who just logged in. It returns the compose form to go back to, or an empty
+// string if there was no draft. Drafts for a thread the user cannot read are
+// dropped.
+func (app *application) saveStashedDraft(r *http.Request, userID int) (string, error) {
+	if !app.sessionManager.Exists(r.Context(), "draftBody") {
+		return "", nil
+	}
+	threadID := app.sessionManager.PopInt(r.Context(), "draftThreadID")
+	title := app.sessionManager.PopString(r.Context(), "draftTitle")
+	body := app.sessionManager.PopString(r.Context(), "draftBody")
+
+	if threadID != 0 {
+		visible, err := app.threads.VisibleTo(threadID, userID)
+		if err != nil || !visible {
+			return "", err
+		}
+	}
+
+	err := app.drafts.Save(userID, threadID, title, body)
+	if err != nil {
+		return "", err
+	}
+	return composeURL(threadID), nil
+}
+
+// composeURL returns the path of the form composing a message in the given
+// thread, or a new thread if threadID is zero.
+func composeURL(threadID int) string {
+	if threadID == 0 {
+		return "/thread/create"
+	}
+	return fmt.Sprintf("/thread/view/%d/message/create", threadID)
+}
+
+// webhookUser, webhookThread and webhookMessage are the JSON representations
+// of users, threads and messages in webhook payloads.
+type webhookUser struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	URL      string `json:"url"`
+}
+
+type webhookThread struct {
+	ID        int         `json:"id"`
+	Title     string      `json:"title"`
+	URL       string      `json:"url"`
+	Kind      string      `json:"kind"`
+	Category  string      `json:"category,omitempty"` // slug
+	Tags      []string    `json:"tags"`
+	Author    webhookUser `json:"author"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+type webhookMessage struct {
+	ID        int         `json:"id"`
+	URL       string      `json:"url"`
+	Body      string      `json:"body"` // Markdown
+	ReplyToID int         `json:"reply_to_id,omitempty"`
+	Author    webhookUser `json:"author"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// webhookUser returns the representation of u in webhook payloads.
+func (app *application) webhookUser(u *models.User) webhookUser {
+	return webhookUser{ID: u.ID, Username: u.Username, URL: app.baseURL + "/user/" + u.Slug}
+}
+
+// webhookThread returns the representation of t in webhook payloads.
+func (app *application) webhookThread(t *models.Thread) webhookThread {
+	wt := webhookThread{
+		ID:        t.ID,
+		Title:     t.Title,
+		URL:       fmt.Sprintf("%s/thread/view/%d", app.baseURL, t.ID),
+		Kind:      t.Kind,
+		Tags:      []string{},
+		Author:    app.webhookUser(t.Author),
+		CreatedAt: t.DateAdded,
+	}
+	if t.Category != nil {
+		wt.Category = t.Category.Slug
+	}
+	for _, tag := range t.Tags {
+		wt.Tags = append(wt.Tags, tag.Slug)
+	}
+	return wt
+}
+
+// webhookMessage returns the representation of m in webhook payloads.
+func (app *application) webhookMessage(m *models.Message) webhookMessage {
+	return webhookMessage{
+		ID:        m.ID,
+		URL:       fmt.Sprintf("%s/thread/view/%d#message-%d", app.baseURL, m.ThreadID, m.ID),
+		Body:      m.Body,
+		ReplyToID: m.ReplyToID,
+		Author:    app.webhookUser(&m.Author),
+		CreatedAt: m.DateAdded,
+	}
+}
+
+// webhookPayload encodes the payload posted to webhooks for an event.
+func webhookPayload(event string, data any) (string, error) {
+	payload, err := json.Marshal(struct {
+		Event     string    `json:"event"`
+		CreatedAt time.Time `json:"created_at"`
+		Data      any       `json:"data"`
+	}{event, time.Now().UTC().Truncate(time.Second), data})
+	if err != nil {
+		return "", fmt.Errorf("encoding webhook payload: %w", err)
+	}
+	return string(payload), nil
+}
+
+// fireEvent queues a delivery of an event to every webhook subscribed to it
+// and wakes up the webhook dispatcher.
+func (app *application) fireEvent(event string, data any) error {
+	payload, err := webhookPayload(event, data)
+	if err != nil {
+		return err
+	}
+
+	n, err := app.webhooks.Enqueue(event, payload)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		app.wakeWebhooks()
+	}
+	return nil
+}
+
+// wakeWebhooks wakes up the webhook dispatcher so that new deliveries are
+// sent right away.
+func (app *application) wakeWebhooks() {
+	select {
+	case app.webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// fireThreadEvent fires a thread or message event. Webhook receivers are
+// outside of the forum, so events are only fired for threads anonymous users
+// can read; scheduled threads fire thread.created once published. msg is the
+// new message, the opening post for thread.created.
+func (app *application) fireThreadEvent(event string, thread *models.Thread, msg *models.Message) error {
+	if thread.Visibility != models.VisibilityPublic || thread.IsScheduled() {
+		return nil
+	}
+	return app.fireEvent(event, map[string]any{
+		"thread":  app.webhookThread(thread),
+		"message": app.webhookMessage(msg),
+	})
+}
+
+// openingPost returns the opening post of a thread loaded with its messages.
+func openingPost(thread *models.Thread) *models.Message {
+	for _, m := range thread.Messages {
+		if m.IsOpening {
+			return m
+		}
+	}
+	return nil
+}
@@ -1,37 +1,91 @@
 package main
 
 import (
+	"crypto/rand"
 	"database/sql"
 	"flag"
 	"html/template"
 	"log/slog"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	"forum/cmd/internal/broker"
+	"forum/cmd/internal/mailer"
+	"forum/cmd/internal/markdown"
 	"forum/cmd/internal/models"
+	"forum/cmd/internal/storage"
 
-	"github.com/alexedwards/scs/v2"   
+	"github.com/alexedwards/scs/v2"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 // application holds the application-wide dependencies.
 type application struct {
-	logger        *slog.Logger
-	messages      *models.MessageModel
-	threads       *models.ThreadModel
-	users         *models.UserModel
-	templateCache map[string]*template.Template
+	logger         *slog.Logger
+	baseURL        string
+	secret         []byte
+	mailer         mailer.Sender
+	mailWake       chan struct{}
+	webhookWake    chan struct{}
+	webhookClient  *http.Client
+	markdown       *markdown.Cache
+	reactionSet    []string
+	broker         *broker.Broker
+	attachments    *models.AttachmentModel
+	blocks         *models.BlockModel
+	bookmarks      *models.BookmarkModel
+	categories     *models.CategoryModel
+	conversations  *models.ConversationModel
+	drafts         *models.DraftModel
+	feeds          *models.FeedModel
+	groups         *models.GroupModel
+	mentions       *models.MentionModel
+	messages       *models.MessageModel
+	notifications  *models.NotificationModel
+	outbox         *models.OutboxModel
+	polls          *models.PollModel
+	reactions      *models.ReactionModel
+	reads          *models.ReadModel
+	subscriptions  *models.SubscriptionModel
+	tags           *models.TagModel
+	threads        *models.ThreadModel
+	users          *models.UserModel
+	votes          *models.VoteModel
+	webhooks       *models.WebhookModel
+	storage        storage.Storage
+	templateCache  map[string]*template.Template
 	sessionManager *scs.SessionManager
 }
 
 func main() {
 	addr := flag.String("addr", ":4000", "HTTP network address")
 	dbPath := flag.String("db", "./db.sqlite", "Path to SQLite database")
+	uploadsPath := flag.String("uploads", "./uploads", "Directory for uploaded files")
+	baseURL := flag.String("base-url", "http://localhost:4000", "Public URL of the forum, used in emails")
+	smtpAddr := flag.String("smtp-addr", "", "SMTP server address (emails are written to -mail-dir if empty)")
+	smtpUsername := flag.String("smtp-username", "", "SMTP username")
+	smtpPassword := flag.String("smtp-password", "", "SMTP password")
+	mailFrom := flag.String("mail-from", "Forum <no-reply@localhost>", "Sender address of emails")
+	mailDir := flag.String("mail-dir", "./mail", "Directory emails are written to when no SMTP server is set")
+	secretKey := flag.String("secret", "", "Key used to sign links in emails (random if empty)")
+	reactionSet := flag.String("reactions", "👍,❤️,😂,🎉,😮,😢", "Comma separated emojis users can react to messages with")
 	flag.Parse()
 
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
+	secret := []byte(*secretKey)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		_, err := rand.Read(secret)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		logger.Warn("no -secret set, links in emails will stop working on restart")
+	}
+
 	db, err := openDB(*dbPath)
 	if err != nil {
 		logger.Error((err.Error()))
@@ -39,6 +93,22 @@ func main() {
 	}
 	defer db.Close()
 
+	store, err := storage.NewLocal(*uploadsPath)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	var sender mailer.Sender = &mailer.FileSink{Dir: *mailDir, From: *mailFrom}
+	if *smtpAddr != "" {
+		sender = &mailer.SMTP{
+			Addr:     *smtpAddr,
+			From:     *mailFrom,
+			Username: *smtpUsername,
+			Password: *smtpPassword,
+		}
+	}
+
 	templateCache, err := newTemplateCache()
 	if err != nil {
 		logger.Error(err.Error())
@@ -49,14 +119,61 @@ func main() {
 	sessionManager.Lifetime = 12 * time.Hour
 
 	app := &application{
-		logger:        logger,
-		messages:      &models.MessageModel{DB: db},
-		threads:       &models.ThreadModel{DB: db},
-		users:         &models.UserModel{DB: db},
-		templateCache: templateCache,
+		logger:         logger,
+		baseURL:        strings.TrimSuffix(*baseURL, "/"),
+		secret:         secret,
+		mailer:         sender,
+		mailWake:       make(chan struct{}, 1),
+		webhookWake:    make(chan struct{}, 1),
+		webhookClient:  &http.Client{Timeout: 10 * time.Second},
+		markdown:       markdown.NewCache(1000),
+		reactionSet:    parseReactionSet(*reactionSet),
+		broker:         broker.New(),
+		attachments:    &models.AttachmentModel{DB: db},
+		blocks:         &models.BlockModel{DB: db},
+		bookmarks:      &models.BookmarkModel{DB: db},
+		categories:     &models.CategoryModel{DB: db},
+		conversations:  &models.ConversationModel{DB: db},
+		drafts:         &models.DraftModel{DB: db},
+		feeds:          &models.FeedModel{DB: db},
+		groups:         &models.GroupModel{DB: db},
+		mentions:       &models.MentionModel{DB: db},
+		messages:       &models.MessageModel{DB: db},
+		notifications:  &models.NotificationModel{DB: db},
+		outbox:         &models.OutboxModel{DB: db},
+		polls:          &models.PollModel{DB: db},
+		reactions:      &models.ReactionModel{DB: db},
+		reads:          &models.ReadModel{DB: db},
+		subscriptions:  &models.SubscriptionModel{DB: db},
+		tags:           &models.TagModel{DB: db},
+		threads:        &models.ThreadModel{DB: db},
+		users:          &models.UserModel{DB: db},
+		votes:          &models.VoteModel{DB: db},
+		webhooks:       &models.WebhookModel{DB: db},
+		storage:        store,
+		templateCache:  templateCache,
 		sessionManager: sessionManager,
 	}
 
+	app.background(func() {
+		app.cleanupNotifications(time.Hour, 30*24*time.Hour, 90*24*time.Hour)
+	})
+	app.background(func() {
+		app.cleanupDrafts(time.Hour, 30*24*time.Hour)
+	})
+	app.background(func() {
+		app.publishScheduled(time.Minute)
+	})
+	app.background(func() {
+		app.dispatchMail(time.Minute)
+	})
+	app.background(func() {
+		app.dispatchWebhooks(time.Minute)
+	})
+	app.background(func() {
+		app.sendDigests(time.Hour)
+	})
+
 	logger.Info("Starting server", "addr", *addr)
 
 	err = http.ListenAndServe(*addr, app.routes())
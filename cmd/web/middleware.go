@@ -1,44 +1,50 @@
 package main
 
 import (
-    "net/http"
+	"errors"
+	"net/http"
+
+	"forum/cmd/internal/models"
 )
 
 // commonHeaders sets common security headers for HTTP responses.
 func commonHeaders(next http.Handler) http.Handler {
-    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        w.Header().Set("Content-Security-Policy",
-            "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com")
-        w.Header().Set("Referrer-Policy", "origin-when-cross-origin")
-        w.Header().Set("X-Content-Type-Options", "nosniff")
-        w.Header().Set("X-Frame-Options", "deny")
-        w.Header().Set("X-XSS-Protection", "0")
-        w.Header().Set("Server", "Go")
-
-        next.ServeHTTP(w, r)
-    })
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy",
+			"default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com")
+		w.Header().Set("Referrer-Policy", "origin-when-cross-origin")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("X-Frame-Options", "deny")
+		w.Header().Set("X-XSS-Protection", "0")
+		w.Header().Set("Server", "Go")
+
+		next.ServeHTTP(w, r)
+	})
 }
 
 // logRequest logs the details of each incoming HTTP request.
 func (app *application) logRequest(next http.Handler) http.Handler {
-    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        var (
-            ip     = r.RemoteAddr
-            proto  = r.Proto
-            method = r.Method
-            uri    = r.URL.RequestURI()
-        )
-
-        app.logger.Info(
-            "received request", "ip", ip, "proto", proto, "method", method, "uri", uri)
-
-        next.ServeHTTP(w, r)
-    })
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			ip     = r.RemoteAddr
+			proto  = r.Proto
+			method = r.Method
+			uri    = r.URL.RequestURI()
+		)
+
+		app.logger.Info(
+			"received request", "ip", ip, "proto", proto, "method", method, "uri", uri)
+
+		next.ServeHTTP(w, r)
+	})
 }
 
 func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !app.isAuthenticated(r) {
+			if r.Method == http.MethodPost {
+				app.stashDraft(w, r)
+			}
 			http.Redirect(w, r, "/account/login", http.StatusSeeOther)
 			return
 		}
@@ -47,3 +53,28 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// requireRole only lets through the users for which allowed returns true.
+// Other users get a 403 Forbidden response. It must run after
+// requireAuthentication.
+func (app *application) requireRole(allowed func(*models.User) bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+		user, err := app.users.GetUser(userID)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+			} else {
+				app.serverError(w, r, err)
+			}
+			return
+		}
+
+		if !allowed(user) {
+			app.clientError(w, http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -1,26 +1,118 @@
 package main
 
-import "net/http"
+import (
+	"net/http"
+
+	"forum/cmd/internal/models"
+)
 
 func (app *application) routes() http.Handler {
 	mux := http.NewServeMux()
 
 	mux.Handle("GET /{$}", app.dynamic(app.home))
+	mux.Handle("GET /feed/{format}", http.HandlerFunc(app.latestFeed))
 
 	mux.Handle("GET /account/create", app.dynamic(app.accountCreate))
 	mux.Handle("POST /account/create", app.dynamic(app.accountCreatePost))
 	mux.Handle("GET /account/view/{id}", app.protected(app.accountView))
+	mux.Handle("POST /account/profile", app.protected(app.accountProfilePost))
+	mux.Handle("POST /account/avatar", app.protected(app.accountAvatarPost))
+	mux.Handle("POST /account/avatar/delete", app.protected(app.accountAvatarDeletePost))
+	mux.Handle("GET /account/mentions", app.protected(app.accountMentions))
+
+	mux.Handle("GET /notifications", app.protected(app.notificationsView))
+	mux.Handle("POST /notifications/{id}/read", app.protected(app.notificationReadPost))
+	mux.Handle("POST /notifications/read", app.protected(app.notificationsReadAllPost))
+
+	mux.Handle("GET /bookmarks", app.protected(app.bookmarksView))
+
+	mux.Handle("GET /conversations", app.protected(app.conversationsView))
+	mux.Handle("GET /conversation/create", app.protected(app.conversationCreate))
+	mux.Handle("POST /conversation/create", app.protected(app.conversationCreatePost))
+	mux.Handle("GET /conversation/{id}", app.protected(app.conversationView))
+	mux.Handle("POST /conversation/{id}/reply", app.protected(app.conversationReplyPost))
+
+	mux.Handle("GET /user/{slug}", app.dynamic(app.userProfile))
+	mux.Handle("GET /user/{slug}/feed/{format}", http.HandlerFunc(app.userFeed))
+	mux.Handle("POST /user/{slug}/block", app.protected(app.userBlockPost))
+	mux.Handle("POST /user/{slug}/unblock", app.protected(app.userUnblockPost))
+	mux.Handle("GET /avatar/{id}/{size}", http.HandlerFunc(app.avatarView))
 
 	mux.Handle("GET /account/login", app.dynamic(app.accountLogin))
 	mux.Handle("POST /account/login", app.dynamic(app.accountLoginPost))
 	mux.Handle("POST /account/logout", app.protected(app.accountLogoutPost))
 
+	mux.Handle("GET /category/{slug}", app.dynamic(app.categoryView))
+	mux.Handle("GET /category/{slug}/feed/{format}", http.HandlerFunc(app.categoryFeed))
+
+	mux.Handle("GET /admin/categories", app.admin(app.adminCategories))
+	mux.Handle("POST /admin/categories", app.admin(app.adminCategoryCreatePost))
+	mux.Handle("GET /admin/categories/{id}/edit", app.admin(app.adminCategoryEdit))
+	mux.Handle("POST /admin/categories/{id}/edit", app.admin(app.adminCategoryEditPost))
+	mux.Handle("POST /admin/categories/{id}/delete", app.admin(app.adminCategoryDeletePost))
+
+	mux.Handle("GET /admin/groups", app.admin(app.adminGroups))
+	mux.Handle("POST /admin/groups", app.admin(app.adminGroupCreatePost))
+	mux.Handle("GET /admin/groups/{id}", app.admin(app.adminGroup))
+	mux.Handle("POST /admin/groups/{id}/members", app.admin(app.adminGroupMemberAddPost))
+	mux.Handle("POST /admin/groups/{id}/members/{user}/remove", app.admin(app.adminGroupMemberRemovePost))
+	mux.Handle("POST /admin/groups/{id}/delete", app.admin(app.adminGroupDeletePost))
+
+	mux.Handle("GET /admin/webhooks", app.admin(app.adminWebhooks))
+	mux.Handle("POST /admin/webhooks", app.admin(app.adminWebhookCreatePost))
+	mux.Handle("GET /admin/webhooks/{id}", app.admin(app.adminWebhook))
+	mux.Handle("POST /admin/webhooks/{id}", app.admin(app.adminWebhookEditPost))
+	mux.Handle("POST /admin/webhooks/{id}/test", app.admin(app.adminWebhookTestPost))
+	mux.Handle("POST /admin/webhooks/{id}/deliveries/{delivery}/redeliver", app.admin(app.adminWebhookRedeliverPost))
+	mux.Handle("POST /admin/webhooks/{id}/delete", app.admin(app.adminWebhookDeletePost))
+
+	mux.Handle("GET /tag/{slug}", app.dynamic(app.tagView))
+	mux.Handle("GET /tag/{slug}/feed/{format}", http.HandlerFunc(app.tagFeed))
+	mux.Handle("GET /tags/autocomplete", app.dynamic(app.tagsAutocomplete))
+
+	mux.Handle("GET /moderate/tags", app.moderator(app.moderateTags))
+	mux.Handle("POST /moderate/tags/{id}/rename", app.moderator(app.moderateTagRenamePost))
+	mux.Handle("POST /moderate/tags/{id}/merge", app.moderator(app.moderateTagMergePost))
+
+	mux.Handle("POST /threads/read", app.protected(app.threadsReadAllPost))
+	mux.Handle("GET /threads/scheduled", app.protected(app.scheduledThreads))
+
 	mux.Handle("GET /thread/create", app.protected(app.threadCreate))
 	mux.Handle("POST /thread/create", app.protected(app.threadCreatePost))
+	mux.Handle("POST /thread/create/draft", app.protected(app.threadDraftPost))
 	mux.Handle("GET /thread/view/{id}", app.dynamic(app.threadView))
+	mux.Handle("GET /thread/view/{id}/feed/{format}", http.HandlerFunc(app.threadFeed))
+	mux.Handle("GET /thread/view/{id}/events", app.dynamic(app.threadEvents))
+	mux.Handle("GET /thread/view/{id}/schedule", app.protected(app.threadSchedule))
+	mux.Handle("POST /thread/view/{id}/schedule", app.protected(app.threadSchedulePost))
+	mux.Handle("POST /thread/view/{id}/schedule/cancel", app.protected(app.threadScheduleCancelPost))
+	mux.Handle("POST /thread/view/{id}/tags", app.protected(app.threadTagsPost))
+	mux.Handle("POST /thread/view/{id}/visibility", app.protected(app.threadVisibilityPost))
+	mux.Handle("POST /thread/view/{id}/vote", app.protected(app.threadVotePost))
+	mux.Handle("POST /thread/view/{id}/accept", app.protected(app.threadAcceptPost))
+	mux.Handle("POST /thread/view/{id}/bookmark", app.protected(app.threadBookmarkPost))
+	mux.Handle("POST /thread/view/{id}/unbookmark", app.protected(app.threadUnbookmarkPost))
+	mux.Handle("POST /thread/view/{id}/poll/vote", app.protected(app.pollVotePost))
+	mux.Handle("POST /thread/view/{id}/poll/close", app.protected(app.pollClosePost))
+	mux.Handle("POST /thread/view/{id}/subscribe", app.protected(app.threadSubscribePost))
+	mux.Handle("POST /thread/view/{id}/unsubscribe", app.protected(app.threadUnsubscribePost))
+
+	mux.Handle("GET /unsubscribe/{user}/{thread}/{token}", app.dynamic(app.unsubscribe))
+	mux.Handle("POST /unsubscribe/{user}/{thread}/{token}", app.dynamic(app.unsubscribePost))
 
 	mux.Handle("GET /thread/view/{id}/message/create", app.protected(app.messageCreate))
 	mux.Handle("POST /thread/view/{id}/message/create", app.protected(app.messageCreatePost))
+	mux.Handle("POST /thread/view/{id}/message/preview", app.protected(app.messageCreatePreview))
+	mux.Handle("POST /thread/view/{id}/message/draft", app.protected(app.messageDraftPost))
+	mux.Handle("POST /drafts/{thread}/delete", app.protected(app.draftDeletePost))
+
+	mux.Handle("POST /message/{id}/vote", app.protected(app.messageVotePost))
+	mux.Handle("POST /message/{id}/react", app.protected(app.messageReactPost))
+	mux.Handle("GET /message/{id}/reactions", app.dynamic(app.messageReactions))
+	mux.Handle("POST /message/{id}/bookmark", app.protected(app.messageBookmarkPost))
+	mux.Handle("POST /message/{id}/unbookmark", app.protected(app.messageUnbookmarkPost))
+
+	mux.Handle("GET /attachment/{id}", app.dynamic(app.attachmentView))
 
 	fileServer := http.FileServer(http.Dir("./ui/static/"))
 	mux.Handle("GET /static/", http.StripPrefix("/static", fileServer))
@@ -32,6 +124,14 @@ func (app *application) protected(handler func(w http.ResponseWriter, r *http.Re
 	return app.sessionManager.LoadAndSave(app.requireAuthentication(http.HandlerFunc(handler)))
 }
 
+func (app *application) admin(handler func(w http.ResponseWriter, r *http.Request)) http.Handler {
+	return app.protected(app.requireRole((*models.User).IsAdmin, http.HandlerFunc(handler)).ServeHTTP)
+}
+
+func (app *application) moderator(handler func(w http.ResponseWriter, r *http.Request)) http.Handler {
+	return app.protected(app.requireRole((*models.User).IsModerator, http.HandlerFunc(handler)).ServeHTTP)
+}
+
 func (app *application) dynamic(handler func(w http.ResponseWriter, r *http.Request)) http.Handler {
 	return app.sessionManager.LoadAndSave(http.HandlerFunc(handler))
 }
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"forum/cmd/internal/models"
 	"html/template"
 	"net/http"
 	"path/filepath"
+	"slices"
 	"time"
 )
 
@@ -13,19 +16,130 @@ type templateData struct {
 	CurrentYear     int
 	Thread          *models.Thread
 	Threads         []*models.Thread
+	Category        *models.Category
+	ParentCategory  *models.Category
+	Categories      []*models.Category
+	Bookmarks       []*models.Bookmark
+	Conversation    *models.Conversation
+	Conversations   []*models.Conversation
+	Draft           *models.Draft
+	Feeds           []feedLink
+	Group           *models.Group
+	Groups          []*models.Group
+	Members         []*models.User
+	Pagination      *pagination
+	Poll            *models.Poll
+	Tag             *models.Tag
+	Tags            []*models.Tag
+	TagFilter       tagFilter
+	Sort            threadSort
+	Messages        []*models.Message
+	ReplyTo         *models.Message
+	Reactors        []reactors
+	Mentions        []*models.Mention
+	Notifications   []*models.Notification
 	User            *models.User
+	Webhook         *models.Webhook
+	Webhooks        []*models.Webhook
+	WebhookEvents   []string
+	Deliveries      []*models.WebhookDelivery
+	Stats           *models.UserStats
+	Preview         template.HTML
+	Search          string
+	IsOwner         bool
+	IsSubscribed    bool
+	IsBlocked       bool
+	FirstUnreadID   int
+	LatestMessageID int
+	TreeView        bool
 	Form            any
 	Flash           string
 	IsAuthenticated bool
+	IsAdmin         bool
+	IsModerator     bool
+
+	// UnreadNotifications and UnreadConversations are shown as badges in
+	// the navigation bar.
+	UnreadNotifications int
+	UnreadConversations int
+}
+
+// reactors holds the users who reacted to a message with an emoji.
+type reactors struct {
+	Emoji string
+	Users []*models.User
+}
+
+// feedLink is a feed advertised by a page. Path is the URL of the feed
+// without its format, to which /atom or /rss is appended.
+type feedLink struct {
+	Title string
+	Path  string
 }
 
 // newTemplate initializes a templateData struct with the current year and a flash message.
+// For logged in users it also counts their unread notifications and conversations.
 func (app *application) newTemplateData(r *http.Request) templateData {
-	return templateData{
+	data := templateData{
 		CurrentYear:     time.Now().Year(),
 		Flash:           app.sessionManager.PopString(r.Context(), "flash"),
 		IsAuthenticated: app.isAuthenticated(r),
+		Feeds:           []feedLink{{Title: "Latest threads", Path: "/feed"}},
+	}
+
+	if data.IsAuthenticated {
+		userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+		n, err := app.notifications.CountUnread(userID)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+		data.UnreadNotifications = n
+
+		n, err = app.conversations.CountUnread(userID)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+		data.UnreadConversations = n
+
+		user, err := app.users.GetUser(userID)
+		if err == nil {
+			data.IsAdmin = user.IsAdmin()
+			data.IsModerator = user.IsModerator()
+		} else if !errors.Is(err, models.ErrNoRecord) {
+			app.logger.Error(err.Error())
+		}
+	}
+
+	return data
+}
+
+// humanDate returns a nicely formatted string representation of a time.Time.
+func humanDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
 	}
+	return t.UTC().Format("02 Jan 2006 at 15:04")
+}
+
+// humanSize returns a short human readable representation of a size in bytes.
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGT"[exp])
+}
+
+// functions holds the custom template functions available to every template.
+var functions = template.FuncMap{
+	"humanDate": humanDate,
+	"humanSize": humanSize,
+	"contains":  slices.Contains[[]string],
 }
 
 // newTemplateCache creates a cache of parsed HTML templates.
@@ -40,7 +154,7 @@ func newTemplateCache() (map[string]*template.Template, error) {
 	for _, page := range pages {
 		name := filepath.Base(page)
 
-		ts, err := template.ParseFiles("./ui/html/base.tmpl")
+		ts, err := template.New(name).Funcs(functions).ParseFiles("./ui/html/base.tmpl")
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"forum/cmd/internal/broker"
+	"forum/cmd/internal/mailer"
+	"forum/cmd/internal/markdown"
+	"forum/cmd/internal/models"
+	"forum/cmd/internal/storage"
+
+	"github.com/alexedwards/scs/v2"
+)
+
+// TestMain runs the tests from the root of the repository, where the server
+// is run from, so that templates are found.
+func TestMain(m *testing.M) {
+	err := os.Chdir("../..")
+	if err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// newTestDB returns a new database with the forum schema, deleted at the end
+// of the test.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	schema, err := os.ReadFile("cmd/internal/schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(string(schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newTestApplication returns an application backed by a new test database
+// and upload directory. Log output is discarded and emails are written to
+// a temporary directory.
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	db := newTestDB(t)
+	store, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	templateCache, err := newTemplateCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &application{
+		logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+		baseURL:        "http://localhost:4000",
+		secret:         []byte("test secret"),
+		mailer:         &mailer.FileSink{Dir: t.TempDir(), From: "forum@example.com"},
+		mailWake:       make(chan struct{}, 1),
+		webhookWake:    make(chan struct{}, 1),
+		webhookClient:  &http.Client{Timeout: 5 * time.Second},
+		markdown:       markdown.NewCache(100),
+		reactionSet:    parseReactionSet("👍,❤️"),
+		broker:         broker.New(),
+		attachments:    &models.AttachmentModel{DB: db},
+		blocks:         &models.BlockModel{DB: db},
+		bookmarks:      &models.BookmarkModel{DB: db},
+		categories:     &models.CategoryModel{DB: db},
+		conversations:  &models.ConversationModel{DB: db},
+		drafts:         &models.DraftModel{DB: db},
+		feeds:          &models.FeedModel{DB: db},
+		groups:         &models.GroupModel{DB: db},
+		mentions:       &models.MentionModel{DB: db},
+		messages:       &models.MessageModel{DB: db},
+		notifications:  &models.NotificationModel{DB: db},
+		outbox:         &models.OutboxModel{DB: db},
+		polls:          &models.PollModel{DB: db},
+		reactions:      &models.ReactionModel{DB: db},
+		reads:          &models.ReadModel{DB: db},
+		subscriptions:  &models.SubscriptionModel{DB: db},
+		tags:           &models.TagModel{DB: db},
+		threads:        &models.ThreadModel{DB: db},
+		users:          &models.UserModel{DB: db},
+		votes:          &models.VoteModel{DB: db},
+		webhooks:       &models.WebhookModel{DB: db},
+		storage:        store,
+		templateCache:  templateCache,
+		sessionManager: scs.New(),
+	}
+}
+
+// insertTestUser inserts a user without going through the slow password
+// hashing of UserModel.InsertUser and returns its id.
+func insertTestUser(t *testing.T, app *application, username string) int {
+	t.Helper()
+
+	stmt := `
+		INSERT INTO users (username, slug, email, password, date_joined)
+		VALUES (?, ?, ?, '', CURRENT_TIMESTAMP)
+	`
+	result, err := app.users.DB.Exec(stmt, username, models.Slugify(username), username+"@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int(id)
+}
+
+// get sends a GET request for path through the routes of app, as the user
+// with the given id, or anonymously if it is zero, and returns the
+// response. Streams end as soon as ctx is canceled.
+func get(t *testing.T, ctx context.Context, app *application, userID int, path string) *http.Response {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, path, nil).WithContext(ctx)
+	if userID != 0 {
+		session, err := app.sessionManager.Load(context.Background(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		app.sessionManager.Put(session, "authenticatedUserID", userID)
+		token, _, err := app.sessionManager.Commit(session)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.AddCookie(&http.Cookie{Name: app.sessionManager.Cookie.Name, Value: token})
+	}
+
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, r)
+	return rr.Result()
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"forum/cmd/internal/mailer"
+	"forum/cmd/internal/models"
+	"forum/cmd/internal/webhook"
+)
+
+// cleanupNotifications periodically deletes read notifications older than
+// readRetention and unread ones older than unreadRetention. It never returns.
+func (app *application) cleanupNotifications(
+	interval time.Duration,
+	readRetention time.Duration,
+	unreadRetention time.Duration,
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+		n, err := app.notifications.DeleteExpired(now.Add(-readRetention), now.Add(-unreadRetention))
+		if err != nil {
+			app.logger.Error(err.Error())
+		} else if n > 0 {
+			app.logger.Info("deleted expired notifications", "count", n)
+		}
+
+		<-ticker.C
+	}
+}
+
+// cleanupDrafts periodically deletes the drafts that were not saved again
+// for longer than retention. It never returns.
+func (app *application) cleanupDrafts(interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := app.drafts.DeleteExpired(time.Now().Add(-retention))
+		if err != nil {
+			app.logger.Error(err.Error())
+		} else if n > 0 {
+			app.logger.Info("deleted expired drafts", "count", n)
+		}
+
+		<-ticker.C
+	}
+}
+
+// publishScheduled publishes the scheduled threads as they come due. It runs
+// every interval and never returns.
+func (app *application) publishScheduled(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := app.publishDue()
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+
+		<-ticker.C
+	}
+}
+
+// publishDue publishes the scheduled threads that are due, then records the
+// mentions in their opening posts and fires their thread.created events,
+// which were held back so that nobody was told about a thread they could not
+// read yet. Threads stay pending until that is done, so a thread whose
+// mentions or event failed, including because the server stopped, is
+// retried on the next call. An error with one thread does not hold back the
+// others.
+func (app *application) publishDue() error {
+	published, err := app.threads.PublishDue(time.Now())
+	if err != nil {
+		return err
+	}
+	for _, p := range published {
+		app.logger.Info("published scheduled thread", "thread", p.ID)
+	}
+
+	pending, err := app.threads.PublishedPending()
+	if err != nil {
+		return err
+	}
+	for _, p := range pending {
+		err := app.announceThread(p.ID, p.Author.ID)
+		if err != nil {
+			app.logger.Error(err.Error(), "thread", p.ID)
+		}
+	}
+	return nil
+}
+
+// announceThread records the mentions in the opening post of a thread that
+// was just published and fires its thread.created event, then clears its
+// pending flag.
+func (app *application) announceThread(threadID, authorID int) error {
+	thread, err := app.threads.Get(threadID, authorID)
+	if err != nil {
+		return err
+	}
+
+	opening := openingPost(thread)
+	if opening != nil {
+		err = app.recordMentions(thread, opening.ID, thread.Author.ID, opening.Body)
+		if err != nil {
+			return err
+		}
+		err = app.fireThreadEvent(models.EventThreadCreated, thread, opening)
+		if err != nil {
+			return err
+		}
+	}
+	return app.threads.ClearPublishedPending(threadID)
+}
+
+// maxMailAttempts and maxWebhookAttempts are the number of times an email
+// or a webhook delivery is tried before it is given up on.
+const (
+	maxMailAttempts    = 8
+	maxWebhookAttempts = 8
+)
+
+// retryDelay returns how long to wait before retrying an email or webhook
+// delivery that failed attempts times: a minute, doubling on every failure
+// up to six hours.
+func retryDelay(attempts int) time.Duration {
+	delay := time.Minute << attempts
+	if attempts > 10 || delay > 6*time.Hour {
+		return 6 * time.Hour
+	}
+	return delay
+}
+
+// dispatchMail sends the emails waiting in the outbox. It runs every interval
+// and whenever queueEmail wakes it up. Since emails are only removed from the
+// outbox once sent, none are lost if the server stops. It never returns.
+func (app *application) dispatchMail(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			emails, err := app.outbox.Due(time.Now(), 50)
+			if err != nil {
+				app.logger.Error(err.Error())
+				break
+			}
+			for _, e := range emails {
+				app.sendOutboxEmail(e)
+			}
+			if len(emails) < 50 {
+				break
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-app.mailWake:
+		}
+	}
+}
+
+// sendOutboxEmail tries to send an email from the outbox and records the
+// outcome.
+func (app *application) sendOutboxEmail(e *models.OutboxEmail) {
+	err := app.mailer.Send(mailer.Message{
+		To:      e.To,
+		Subject: e.Subject,
+		Body:    e.Body,
+		Headers: e.Headers,
+	})
+	if err == nil {
+		err = app.outbox.MarkSent(e.ID)
+		if err != nil {
+			app.logger.Error(err.Error(), "email", e.ID)
+		}
+		return
+	}
+
+	giveUp := e.Attempts+1 >= maxMailAttempts
+	app.logger.Error(err.Error(), "email", e.ID, "attempts", e.Attempts+1, "gave_up", giveUp)
+	err = app.outbox.MarkFailed(e.ID, err, time.Now().Add(retryDelay(e.Attempts)), giveUp)
+	if err != nil {
+		app.logger.Error(err.Error(), "email", e.ID)
+	}
+}
+
+// dispatchWebhooks posts the pending webhook deliveries. It runs every
+// interval and whenever fireEvent wakes it up. Since deliveries are stored
+// until they go through or are given up on, none are lost if the server
+// stops. It never returns.
+func (app *application) dispatchWebhooks(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			deliveries, err := app.webhooks.Due(time.Now(), 50)
+			if err != nil {
+				app.logger.Error(err.Error())
+				break
+			}
+			for _, d := range deliveries {
+				app.sendWebhook(d)
+			}
+			if len(deliveries) < 50 {
+				break
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-app.webhookWake:
+		}
+	}
+}
+
+// sendWebhook tries to post a webhook delivery and records the outcome.
+func (app *application) sendWebhook(d *models.WebhookDelivery) {
+	code, err := webhook.Send(app.webhookClient, webhook.Request{
+		URL:        d.URL,
+		Secret:     d.Secret,
+		Event:      d.Event,
+		DeliveryID: d.ID,
+		Body:       []byte(d.Payload),
+	})
+	if err == nil {
+		err = app.webhooks.MarkDelivered(d.ID, code)
+		if err != nil {
+			app.logger.Error(err.Error(), "delivery", d.ID)
+		}
+		return
+	}
+
+	giveUp := d.Attempts+1 >= maxWebhookAttempts
+	app.logger.Error(err.Error(), "delivery", d.ID, "attempts", d.Attempts+1, "gave_up", giveUp)
+	err = app.webhooks.MarkFailed(d.ID, code, err, time.Now().Add(retryDelay(d.Attempts)), giveUp)
+	if err != nil {
+		app.logger.Error(err.Error(), "delivery", d.ID)
+	}
+}
+
+// sendDigests queues the daily and weekly digest emails of the users they
+// are due for. It runs every interval and never returns.
+func (app *application) sendDigests(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, frequency := range []string{models.EmailDaily, models.EmailWeekly} {
+			err := app.sendDigestsFor(frequency, time.Now())
+			if err != nil {
+				app.logger.Error(err.Error(), "frequency", frequency)
+			}
+		}
+
+		<-ticker.C
+	}
+}
+
+// sendDigestsFor queues a digest for every user receiving digests at the
+// given frequency whose last one is older than the digest period. Users with
+// nothing new get no email, but their digest period starts over. A failure
+// for one user is logged without holding up the others.
+func (app *application) sendDigestsFor(frequency string, now time.Time) error {
+	subscribers, err := app.subscriptions.DueDigests(frequency, now)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range subscribers {
+		err := app.sendDigest(s, frequency, now)
+		if err != nil {
+			app.logger.Error(err.Error(), "user", s.UserID, "frequency", frequency)
+		}
+	}
+	return nil
+}
+
+// sendDigest queues the digest of s, if there is anything new, and starts
+// its digest period over.
+func (app *application) sendDigest(s *models.Subscriber, frequency string, now time.Time) error {
+	since := now.Add(-models.DigestPeriods[frequency])
+	if s.LastDigest.Valid {
+		since = s.LastDigest.Time
+	}
+
+	messages, err := app.subscriptions.DigestMessages(s.UserID, since)
+	if err != nil {
+		return err
+	}
+
+	var email *models.OutboxEmail
+	if len(messages) > 0 {
+		msg := app.digestEmail(s, frequency, messages)
+		email = &models.OutboxEmail{To: msg.To, Subject: msg.Subject, Body: msg.Body, Headers: msg.Headers}
+	}
+
+	err = app.subscriptions.RecordDigest(s.UserID, now, email)
+	if err != nil {
+		return err
+	}
+	if email != nil {
+		app.wakeMailer()
+	}
+	return nil
+}
+
+// digestEmail builds the digest of the given messages, which are ordered by
+// thread.
+func (app *application) digestEmail(
+	s *models.Subscriber,
+	frequency string,
+	messages []*models.Message,
+) mailer.Message {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Hi %s,\n\nHere is your %s digest of the threads you follow.\n", s.Username, frequency)
+
+	for i, m := range messages {
+		if i == 0 || messages[i-1].ThreadID != m.ThreadID {
+			fmt.Fprintf(&b, "\n== %s ==\n%s/thread/view/%d\n", m.ThreadTitle, app.baseURL, m.ThreadID)
+			fmt.Fprintf(&b, "Unsubscribe from this thread: %s\n", app.unsubscribeURL(s.UserID, m.ThreadID))
+		}
+		fmt.Fprintf(&b, "\n%s wrote on %s:\n%s\n", m.Author.Username, humanDate(m.DateAdded), m.Body)
+	}
+
+	fmt.Fprintf(
+		&b,
+		"\nYou can change how often you get these emails in your account settings:\n%s/account/view/%d\n",
+		app.baseURL, s.UserID,
+	)
+
+	return mailer.Message{
+		To:      s.Email,
+		Subject: fmt.Sprintf("Your %s forum digest", frequency),
+		Body:    b.String(),
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"forum/cmd/internal/models"
+)
+
+func TestSendWebhookRetries(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	webhookID, err := app.webhooks.Insert(ts.URL, "s3cret", []string{"thread.created"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = app.webhooks.Enqueue("thread.created", `{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every failed attempt but the last one schedules a retry after
+	// retryDelay.
+	now := time.Now()
+	for attempt := 1; attempt <= maxWebhookAttempts; attempt++ {
+		due, err := app.webhooks.Due(now, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(due) != 1 {
+			t.Fatalf("attempt %d: got %d due deliveries; want 1", attempt, len(due))
+		}
+
+		start := time.Now()
+		app.sendWebhook(due[0])
+
+		deliveries, err := app.webhooks.Deliveries(webhookID, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := deliveries[0]
+		if d.Attempts != attempt || d.ResponseCode != http.StatusInternalServerError {
+			t.Fatalf("attempt %d: got %d attempts and code %d", attempt, d.Attempts, d.ResponseCode)
+		}
+		if attempt == maxWebhookAttempts {
+			if d.Status != "failed" {
+				t.Fatalf("got status %q after %d attempts; want failed", d.Status, attempt)
+			}
+			break
+		}
+
+		if d.Status != "pending" {
+			t.Fatalf("attempt %d: got status %q; want pending", attempt, d.Status)
+		}
+		// Stored times have a one second precision.
+		want := start.Add(retryDelay(attempt - 1)).Truncate(time.Second)
+		if d.NextAttempt.Before(want) || d.NextAttempt.After(want.Add(2*time.Second)) {
+			t.Fatalf("attempt %d: got next attempt %v; want about %v", attempt, d.NextAttempt, want)
+		}
+		now = d.NextAttempt
+	}
+
+	// A delivery that was given up on is never due again.
+	due, err := app.webhooks.Due(now.Add(24*time.Hour), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 0 {
+		t.Errorf("got %d due deliveries after giving up; want none", len(due))
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, time.Minute},
+		{1, 2 * time.Minute},
+		{5, 32 * time.Minute},
+		{9, 6 * time.Hour},
+		{40, 6 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := retryDelay(tt.attempts); got != tt.want {
+			t.Errorf("retryDelay(%d) = %v; want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestSendDigestsFor(t *testing.T) {
+	app := newTestApplication(t)
+	db := app.users.DB
+
+	author := insertTestUser(t, app, "author")
+	threadID, _, err := app.threads.Insert(
+		"Thread", "Opening post", author, 0, models.KindDiscussion,
+		models.VisibilityPublic, 0, nil, nil, time.Time{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"ann", "bea", "cid"} {
+		err = app.subscriptions.Subscribe(insertTestUser(t, app, name), threadID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = db.Exec(`UPDATE users SET email_frequency = ? WHERE id != ?`, models.EmailDaily, author)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = app.messages.InsertMessage("Reply", threadID, author, 0, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Recording the digest of bea fails: the others still get theirs, and
+	// hers is not queued.
+	_, err = db.Exec(`
+		CREATE TRIGGER fail_digest BEFORE UPDATE OF last_digest ON users
+		WHEN NEW.username = 'bea' BEGIN SELECT RAISE(ABORT, 'disk full'); END
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recipients := func() []string {
+		t.Helper()
+		emails, err := app.outbox.Due(time.Now().Add(time.Minute), 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var to []string
+		for _, e := range emails {
+			to = append(to, e.To)
+		}
+		slices.Sort(to)
+		return to
+	}
+
+	now := time.Now()
+	err = app.sendDigestsFor(models.EmailDaily, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"ann@example.com", "cid@example.com"}
+	if got := recipients(); !slices.Equal(got, want) {
+		t.Fatalf("got digests for %v; want %v", got, want)
+	}
+
+	// Once bea can be recorded, only she gets a digest: the others are
+	// not due before the end of their period.
+	_, err = db.Exec(`DROP TRIGGER fail_digest`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.sendDigestsFor(models.EmailDaily, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"ann@example.com", "bea@example.com", "cid@example.com"}
+	if got := recipients(); !slices.Equal(got, want) {
+		t.Errorf("got digests for %v; want %v", got, want)
+	}
+}
@@ -0,0 +1,42 @@
+// Adds the replies posted to a thread while it is open, streamed as
+// Server-Sent Events by /thread/view/{id}/events. Each event holds the HTML
+// of a message. After a network error the browser reconnects on its own and
+// sends the id of the last message it got, so that none are missed.
+(function () {
+    var replies = document.querySelector("#replies[data-events]");
+    if (!replies || !window.EventSource) {
+        return;
+    }
+
+    var source = new EventSource(replies.dataset.events);
+    source.addEventListener("message", function (event) {
+        var template = document.createElement("template");
+        template.innerHTML = event.data;
+        var message = template.content.querySelector("dl[id^='message-']");
+        if (!message || document.getElementById(message.id)) {
+            return;
+        }
+
+        if (!replies.hasAttribute("data-tree")) {
+            replies.append(template.content);
+            return;
+        }
+
+        // In the threaded view, nest the reply under the message it answers.
+        // Replies to the opening post are at the top level.
+        var item = document.createElement("li");
+        item.append(template.content);
+        var list = replies.querySelector(".message-tree");
+        var parent = document.getElementById("message-" + message.dataset.replyTo);
+        if (parent && parent.tagName === "DL") {
+            var parentItem = parent.closest("li");
+            list = parentItem.querySelector(":scope > .replies");
+            if (!list) {
+                list = document.createElement("ul");
+                list.className = "replies";
+                parentItem.append(list);
+            }
+        }
+        list.append(item);
+    });
+})();
@@ -0,0 +1,34 @@
+// Toggles reactions without reloading the page. The forms post to
+// /message/{id}/react and still work when JavaScript is disabled; here they
+// ask for JSON instead and update the button in place. Anything unexpected,
+// such as a redirect to the login page, falls back to a normal submission.
+// Submissions are caught on the document so that messages added live work
+// too.
+document.addEventListener("submit", function (event) {
+    var form = event.target;
+    if (!form.matches("form[data-reaction]")) {
+        return;
+    }
+    event.preventDefault();
+    var button = form.querySelector("button");
+    button.disabled = true;
+    fetch(form.action, {
+        method: "POST",
+        headers: { "Accept": "application/json" },
+        body: new URLSearchParams(new FormData(form)),
+    })
+        .then(function (response) {
+            if (!response.ok || response.redirected) {
+                throw new Error("unexpected response");
+            }
+            return response.json();
+        })
+        .then(function (reaction) {
+            button.querySelector("span").textContent = reaction.count;
+            button.classList.toggle("reacted", reaction.reacted);
+            button.disabled = false;
+        })
+        .catch(function () {
+            form.submit();
+        });
+});
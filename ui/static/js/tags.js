@@ -0,0 +1,27 @@
+// Suggests existing tags while typing in tag inputs, using the
+// /tags/autocomplete endpoint. Inputs hold a comma separated list of tags:
+// suggestions complete the last one and keep the others.
+document.querySelectorAll("input[data-tag-autocomplete]").forEach(function (input) {
+    var list = document.getElementById(input.getAttribute("list"));
+    var pending = null;
+
+    input.addEventListener("input", function () {
+        clearTimeout(pending);
+        pending = setTimeout(function () {
+            var value = input.value;
+            var head = value.slice(0, value.lastIndexOf(",") + 1);
+            fetch("/tags/autocomplete?q=" + encodeURIComponent(value))
+                .then(function (response) { return response.json(); })
+                .then(function (tags) {
+                    list.replaceChildren();
+                    tags.forEach(function (tag) {
+                        var option = document.createElement("option");
+                        option.value = (head ? head + " " : "") + tag.name;
+                        option.label = tag.name + " (" + tag.threads + ")";
+                        list.appendChild(option);
+                    });
+                })
+                .catch(function () {});
+        }, 150);
+    });
+});